@@ -4,17 +4,24 @@ import (
 	"bytes"
 	"fmt"
 	"maz-lang/token"
+	"strconv"
 	"strings"
+	"unicode"
 )
 
 type Node interface {
 	String() string
+	// Span returns the portion of the source the node was parsed from.
+	Span() token.Span
 }
 
 type Program struct {
 	Statements []Node
+	Loc        token.Span
 }
 
+func (p *Program) Span() token.Span { return p.Loc }
+
 func (p *Program) String() string {
 	var buffer bytes.Buffer
 
@@ -28,8 +35,11 @@ func (p *Program) String() string {
 type PrefixExpression struct {
 	Prefix token.Token
 	Value  Node
+	Loc    token.Span
 }
 
+func (pe *PrefixExpression) Span() token.Span { return pe.Loc }
+
 func (pe *PrefixExpression) String() string {
 	return fmt.Sprintf("(%s%s)\n", pe.Prefix.Literal, strings.TrimSpace(pe.Value.String()))
 }
@@ -38,8 +48,11 @@ type InfixExpression struct {
 	Left     Node
 	Operator token.Token
 	Right    Node
+	Loc      token.Span
 }
 
+func (ie *InfixExpression) Span() token.Span { return ie.Loc }
+
 func (ie *InfixExpression) String() string {
 	left := strings.TrimSpace(ie.Left.String())
 	right := strings.TrimSpace(ie.Right.String())
@@ -48,29 +61,89 @@ func (ie *InfixExpression) String() string {
 
 type IntegerLiteral struct {
 	Value int64
+	Loc   token.Span
 }
 
+func (il *IntegerLiteral) Span() token.Span { return il.Loc }
+
 func (il *IntegerLiteral) String() string { return fmt.Sprintf("%d\n", il.Value) }
 
+type FloatLiteral struct {
+	Value float64
+	Loc   token.Span
+}
+
+func (fl *FloatLiteral) Span() token.Span { return fl.Loc }
+
+func (fl *FloatLiteral) String() string {
+	return fmt.Sprintf("%s\n", strconv.FormatFloat(fl.Value, 'g', -1, 64))
+}
+
 type BooleanLiteral struct {
 	Value bool
+	Loc   token.Span
 }
 
+func (bl *BooleanLiteral) Span() token.Span { return bl.Loc }
+
 func (bl *BooleanLiteral) String() string { return fmt.Sprintf("%v\n", bl.Value) }
 
 type StringLiteral struct {
 	Value string
+	Loc   token.Span
 }
 
-func (sl *StringLiteral) String() string { return fmt.Sprintf("%v\n", sl.Value) }
+func (sl *StringLiteral) Span() token.Span { return sl.Loc }
+
+// String returns the literal as it is written in a program, quoted and
+// with the characters that cannot appear as is escaped.
+func (sl *StringLiteral) String() string {
+	var buffer strings.Builder
+
+	buffer.WriteByte('"')
+	for _, char := range sl.Value {
+		switch {
+		case char == '"' || char == '\\':
+			buffer.WriteRune('\\')
+			buffer.WriteRune(char)
+		case char == '\n':
+			buffer.WriteString(`\n`)
+		case char == '\t':
+			buffer.WriteString(`\t`)
+		case char == '\r':
+			buffer.WriteString(`\r`)
+		case char == 0:
+			buffer.WriteString(`\0`)
+		case unicode.IsControl(char):
+			fmt.Fprintf(&buffer, `\u{%X}`, char)
+		default:
+			buffer.WriteRune(char)
+		}
+	}
+	buffer.WriteString("\"\n")
+
+	return buffer.String()
+}
 
 type SyntaxError struct {
 	Msg   string
 	Token token.Token
 }
 
+func (se *SyntaxError) Span() token.Span { return se.Token.Loc }
+
 func (se *SyntaxError) String() string {
-	return fmt.Sprintf("\nSyntax error: %s\nError near: '%s'\n", se.Msg, se.Token.Literal)
+	return fmt.Sprintf("%s: syntax error: %s, near '%s'", se.Token.Loc.Start, se.Msg, se.Token.Literal)
+}
+
+// Describe returns the error followed by the offending line of src
+// with a caret pointing at the error.
+func (se *SyntaxError) Describe(src string) string {
+	excerpt := token.Excerpt(src, se.Token.Loc.Start)
+	if excerpt == "" {
+		return se.String()
+	}
+	return se.String() + "\n" + excerpt
 }
 
 func (se *SyntaxError) Error() string { return se.String() }
@@ -78,16 +151,35 @@ func (se *SyntaxError) Error() string { return se.String() }
 type LetStatement struct {
 	Ident string
 	Value Node
+	Doc   string
+	Loc   token.Span
+	// Slot is where the variable is stored in the current scope, it is set
+	// by the resolver.
+	Slot int
 }
 
+func (ls *LetStatement) Span() token.Span { return ls.Loc }
+
 func (ls *LetStatement) String() string {
 	return fmt.Sprintf("let %s = %s;\n", ls.Ident, strings.Trim(ls.Value.String(), "\n"))
 }
 
 type Identifier struct {
 	Name string
+	Loc  token.Span
+	// Depth and Slot are set by the resolver, the variable is stored in
+	// the Slot of the environment Depth scopes up from the current one.
+	// Slot is Unresolved for names never declared, which are looked up
+	// by name among the globals.
+	Depth int
+	Slot  int
 }
 
+// Unresolved is the slot of an identifier whose name is never declared.
+const Unresolved = -1
+
+func (id *Identifier) Span() token.Span { return id.Loc }
+
 func (id *Identifier) String() string { return id.Name + "\n" }
 
 type IfStatement struct {
@@ -95,8 +187,11 @@ type IfStatement struct {
 	MainStatements []Node
 	ElseIfs        []ElseIf
 	ElseStatements []Node
+	Loc            token.Span
 }
 
+func (is *IfStatement) Span() token.Span { return is.Loc }
+
 func (is *IfStatement) String() string {
 	var buffer bytes.Buffer
 
@@ -124,8 +219,11 @@ func (is *IfStatement) String() string {
 type ElseIf struct {
 	Condition  Node
 	Statements []Node
+	Loc        token.Span
 }
 
+func (ei *ElseIf) Span() token.Span { return ei.Loc }
+
 func (ei *ElseIf) String() string {
 	var buffer bytes.Buffer
 
@@ -138,10 +236,69 @@ func (ei *ElseIf) String() string {
 	return buffer.String()
 }
 
+type WhileStatement struct {
+	Condition  Node
+	Statements []Node
+	Loc        token.Span
+}
+
+func (ws *WhileStatement) Span() token.Span { return ws.Loc }
+
+func (ws *WhileStatement) String() string {
+	var buffer bytes.Buffer
+
+	buffer.WriteString(fmt.Sprintf("while (%s) {\n", ws.Condition.String()))
+	for _, stmt := range ws.Statements {
+		buffer.WriteString("\t" + stmt.String() + "\n")
+	}
+	buffer.WriteString("}")
+
+	return buffer.String()
+}
+
+// ForStatement is a 'for variable in iterable { ... }' loop.
+type ForStatement struct {
+	Variable   string
+	Iterable   Node
+	Statements []Node
+	Loc        token.Span
+}
+
+func (fs *ForStatement) Span() token.Span { return fs.Loc }
+
+func (fs *ForStatement) String() string {
+	var buffer bytes.Buffer
+
+	buffer.WriteString(fmt.Sprintf("for %s in (%s) {\n", fs.Variable, fs.Iterable.String()))
+	for _, stmt := range fs.Statements {
+		buffer.WriteString("\t" + stmt.String() + "\n")
+	}
+	buffer.WriteString("}")
+
+	return buffer.String()
+}
+
+type BreakStatement struct {
+	Loc token.Span
+}
+
+func (bs *BreakStatement) Span() token.Span { return bs.Loc }
+func (bs *BreakStatement) String() string   { return "break;\n" }
+
+type ContinueStatement struct {
+	Loc token.Span
+}
+
+func (cs *ContinueStatement) Span() token.Span { return cs.Loc }
+func (cs *ContinueStatement) String() string   { return "continue;\n" }
+
 type ReturnStatement struct {
 	Expression Node
+	Loc        token.Span
 }
 
+func (rs *ReturnStatement) Span() token.Span { return rs.Loc }
+
 func (rs *ReturnStatement) String() string {
 	return fmt.Sprintf("return %s;\n", strings.TrimSpace(rs.Expression.String()))
 }
@@ -150,8 +307,19 @@ type FunctionDefinition struct {
 	Name       string
 	Parameters []Node
 	Body       []Node
+	Doc        string
+	Loc        token.Span
+	// Set by the resolver: Slot is where a named function is stored in the
+	// current scope, NumLocals the number of variables of its body, which
+	// includes the parameters, and Shadowed the variable with the same name
+	// visible where the function is defined, if any.
+	Slot      int
+	NumLocals int
+	Shadowed  *Identifier
 }
 
+func (f *FunctionDefinition) Span() token.Span { return f.Loc }
+
 func (f *FunctionDefinition) String() string {
 	var out bytes.Buffer
 
@@ -177,21 +345,22 @@ func (f *FunctionDefinition) String() string {
 	return out.String()
 }
 
-type FunctionCall struct {
-	Name      string
+type CallExpression struct {
+	Callee    Node
 	Arguments []Node
+	Loc       token.Span
 }
 
-func (fc *FunctionCall) String() string {
+func (ce *CallExpression) Span() token.Span { return ce.Loc }
+
+func (ce *CallExpression) String() string {
 	var out bytes.Buffer
 
 	out.WriteString("(")
-	if fc.Name != "" {
-		out.WriteString(fc.Name)
-	}
+	out.WriteString(strings.TrimSpace(ce.Callee.String()))
 
 	var arguments []string
-	for _, node := range fc.Arguments {
+	for _, node := range ce.Arguments {
 		arguments = append(arguments, strings.TrimSpace(node.String()))
 	}
 
@@ -201,3 +370,81 @@ func (fc *FunctionCall) String() string {
 
 	return out.String()
 }
+
+// MemberExpression is the access to a property of an object, e.g. 'obj.name'.
+type MemberExpression struct {
+	Object   Node
+	Property string
+	Loc      token.Span
+}
+
+func (me *MemberExpression) Span() token.Span { return me.Loc }
+
+func (me *MemberExpression) String() string {
+	return fmt.Sprintf("%s.%s\n", strings.TrimSpace(me.Object.String()), me.Property)
+}
+
+type ArrayLiteral struct {
+	Elements []Node
+	Loc      token.Span
+}
+
+func (al *ArrayLiteral) Span() token.Span { return al.Loc }
+
+func (al *ArrayLiteral) String() string {
+	var elements []string
+	for _, el := range al.Elements {
+		elements = append(elements, strings.TrimSpace(el.String()))
+	}
+
+	return fmt.Sprintf("[%s]\n", strings.Join(elements, ", "))
+}
+
+type IndexExpression struct {
+	Left  Node
+	Index Node
+	Loc   token.Span
+}
+
+func (ie *IndexExpression) Span() token.Span { return ie.Loc }
+
+func (ie *IndexExpression) String() string {
+	return fmt.Sprintf("(%s[%s])\n", strings.TrimSpace(ie.Left.String()), strings.TrimSpace(ie.Index.String()))
+}
+
+type HashLiteral struct {
+	Pairs []HashPair
+	Loc   token.Span
+}
+
+type HashPair struct {
+	Key   Node
+	Value Node
+}
+
+func (hl *HashLiteral) Span() token.Span { return hl.Loc }
+
+func (hl *HashLiteral) String() string {
+	var pairs []string
+	for _, pair := range hl.Pairs {
+		pairs = append(pairs, fmt.Sprintf("%s: %s", strings.TrimSpace(pair.Key.String()), strings.TrimSpace(pair.Value.String())))
+	}
+
+	return fmt.Sprintf("{%s}\n", strings.Join(pairs, ", "))
+}
+
+// AssignExpression stores a value into Target, which can be a variable,
+// an index expression or a member expression. Operator is either '='
+// or a compound assignment like '+='.
+type AssignExpression struct {
+	Target   Node
+	Operator token.Token
+	Value    Node
+	Loc      token.Span
+}
+
+func (ae *AssignExpression) Span() token.Span { return ae.Loc }
+
+func (ae *AssignExpression) String() string {
+	return fmt.Sprintf("%s %s %s\n", strings.TrimSpace(ae.Target.String()), ae.Operator.Literal, strings.TrimSpace(ae.Value.String()))
+}
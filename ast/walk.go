@@ -0,0 +1,66 @@
+package ast
+
+// Inspect traverses the tree rooted at node in depth first order, calling f for
+// every node. The children of a node are visited only if f returns true.
+func Inspect(node Node, f func(Node) bool) {
+	if node == nil || !f(node) {
+		return
+	}
+
+	inspectAll := func(nodes []Node) {
+		for _, n := range nodes {
+			Inspect(n, f)
+		}
+	}
+
+	switch node := node.(type) {
+	case *Program:
+		inspectAll(node.Statements)
+	case *PrefixExpression:
+		Inspect(node.Value, f)
+	case *InfixExpression:
+		Inspect(node.Left, f)
+		Inspect(node.Right, f)
+	case *LetStatement:
+		Inspect(node.Value, f)
+	case *IfStatement:
+		Inspect(node.MainCondition, f)
+		inspectAll(node.MainStatements)
+		for i := range node.ElseIfs {
+			Inspect(&node.ElseIfs[i], f)
+		}
+		inspectAll(node.ElseStatements)
+	case *ElseIf:
+		Inspect(node.Condition, f)
+		inspectAll(node.Statements)
+	case *WhileStatement:
+		Inspect(node.Condition, f)
+		inspectAll(node.Statements)
+	case *ForStatement:
+		Inspect(node.Iterable, f)
+		inspectAll(node.Statements)
+	case *ReturnStatement:
+		Inspect(node.Expression, f)
+	case *FunctionDefinition:
+		inspectAll(node.Parameters)
+		inspectAll(node.Body)
+	case *CallExpression:
+		Inspect(node.Callee, f)
+		inspectAll(node.Arguments)
+	case *MemberExpression:
+		Inspect(node.Object, f)
+	case *ArrayLiteral:
+		inspectAll(node.Elements)
+	case *IndexExpression:
+		Inspect(node.Left, f)
+		Inspect(node.Index, f)
+	case *HashLiteral:
+		for _, pair := range node.Pairs {
+			Inspect(pair.Key, f)
+			Inspect(pair.Value, f)
+		}
+	case *AssignExpression:
+		Inspect(node.Target, f)
+		Inspect(node.Value, f)
+	}
+}
@@ -0,0 +1,290 @@
+// Package code defines the instruction set executed by the virtual machine.
+package code
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"maz-lang/token"
+	"sort"
+)
+
+// Instructions is a sequence of instructions, each made of an opcode
+// followed by its operands in big endian order.
+type Instructions []byte
+
+type Opcode byte
+
+const (
+	OpConstant Opcode = iota // push the constant at the operand index
+	OpTrue
+	OpFalse
+	OpNull
+	OpPop
+	OpDup  // duplicate the top of the stack
+	OpDup2 // duplicate the two values on top of the stack
+
+	// Binary operators pop the right and the left operand and push the result
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+	OpMod
+	OpPow
+	OpBitAnd
+	OpBitOr
+	OpBitXor
+	OpShiftLeft
+	OpShiftRight
+	OpEqual
+	OpNotEqual
+	OpGreater
+	OpGreaterEqual
+	OpLess
+	OpLessEqual
+
+	OpMinus
+	OpBang
+	OpBitNot
+	OpBool // replace the top of the stack with its truth value
+
+	OpJump
+	OpJumpIfFalse      // pop the condition and jump if it is false
+	OpJumpIfFalseOrPop // jump if the boolean on top is false, pop it otherwise
+	OpJumpIfTrueOrPop  // jump if the boolean on top is true, pop it otherwise
+
+	OpGetGlobal     // push a global, builtins are used for globals never set
+	OpSetGlobal     // pop a value into a global
+	OpCheckGlobal   // fail if the global was never set, before assigning to it
+	OpCheckFunction // fail if the global is set, before defining a function with its name
+	OpGetLocal
+	OpSetLocal
+	OpNewCell  // put a new cell in a local slot, for variables captured by closures
+	OpGetCell  // push the value in the cell of a local slot
+	OpSetCell  // pop a value into the cell of a local slot
+	OpLoadCell // push the cell of a local slot itself, to be captured
+	OpGetFree  // push the value of a captured variable
+	OpSetFree  // pop a value into a captured variable
+	OpLoadFree // push the cell of a captured variable, to be captured again
+
+	OpArray    // build an array out of the given number of values
+	OpHash     // build a hash out of the given number of key/value pairs
+	OpIndex    // pop the index and the container, push the element
+	OpSetIndex // pop the value, the index and the container, store and push the value, for compound assignments the operand is the operator
+	OpMember   // replace a hash with the value of the property named by the constant
+
+	OpClosure     // build a closure of a compiled function and the given number of cells
+	OpCall        // call with the number of arguments, the callee description and its global
+	OpReturnValue // return the top of the stack from the current function
+
+	OpLoop       // enter a loop, break and continue go back to the current stack
+	OpLoopEnd    // leave a loop
+	OpBreak      // unwind the stack of the loop and jump to its end
+	OpContinue   // unwind the stack of the loop and jump to its next iteration
+	OpIter       // replace an iterable with an iterator
+	OpIterNext   // push the next value of the iterator, or jump when it is over
+	OpMark       // at the top level, enter a statement that a return leaves
+	OpUnmark     // leave the statement entered with OpMark
+	OpLoopSignal // fail because of a break (0) or a continue (1) outside of a loop
+	OpError      // fail with the message in the constant
+)
+
+// Definition describes an opcode, OperandWidths holds the size in bytes of
+// each of its operands.
+type Definition struct {
+	Name          string
+	OperandWidths []int
+}
+
+var definitions = map[Opcode]*Definition{
+	OpConstant: {"OpConstant", []int{2}},
+	OpTrue:     {"OpTrue", []int{}},
+	OpFalse:    {"OpFalse", []int{}},
+	OpNull:     {"OpNull", []int{}},
+	OpPop:      {"OpPop", []int{}},
+	OpDup:      {"OpDup", []int{}},
+	OpDup2:     {"OpDup2", []int{}},
+
+	OpAdd:          {"OpAdd", []int{}},
+	OpSub:          {"OpSub", []int{}},
+	OpMul:          {"OpMul", []int{}},
+	OpDiv:          {"OpDiv", []int{}},
+	OpMod:          {"OpMod", []int{}},
+	OpPow:          {"OpPow", []int{}},
+	OpBitAnd:       {"OpBitAnd", []int{}},
+	OpBitOr:        {"OpBitOr", []int{}},
+	OpBitXor:       {"OpBitXor", []int{}},
+	OpShiftLeft:    {"OpShiftLeft", []int{}},
+	OpShiftRight:   {"OpShiftRight", []int{}},
+	OpEqual:        {"OpEqual", []int{}},
+	OpNotEqual:     {"OpNotEqual", []int{}},
+	OpGreater:      {"OpGreater", []int{}},
+	OpGreaterEqual: {"OpGreaterEqual", []int{}},
+	OpLess:         {"OpLess", []int{}},
+	OpLessEqual:    {"OpLessEqual", []int{}},
+
+	OpMinus:  {"OpMinus", []int{}},
+	OpBang:   {"OpBang", []int{}},
+	OpBitNot: {"OpBitNot", []int{}},
+	OpBool:   {"OpBool", []int{}},
+
+	OpJump:             {"OpJump", []int{2}},
+	OpJumpIfFalse:      {"OpJumpIfFalse", []int{2}},
+	OpJumpIfFalseOrPop: {"OpJumpIfFalseOrPop", []int{2}},
+	OpJumpIfTrueOrPop:  {"OpJumpIfTrueOrPop", []int{2}},
+
+	OpGetGlobal:     {"OpGetGlobal", []int{2}},
+	OpSetGlobal:     {"OpSetGlobal", []int{2}},
+	OpCheckGlobal:   {"OpCheckGlobal", []int{2}},
+	OpCheckFunction: {"OpCheckFunction", []int{2}},
+	OpGetLocal:      {"OpGetLocal", []int{2}},
+	OpSetLocal:      {"OpSetLocal", []int{2}},
+	OpNewCell:       {"OpNewCell", []int{2}},
+	OpGetCell:       {"OpGetCell", []int{2}},
+	OpSetCell:       {"OpSetCell", []int{2}},
+	OpLoadCell:      {"OpLoadCell", []int{2}},
+	OpGetFree:       {"OpGetFree", []int{1}},
+	OpSetFree:       {"OpSetFree", []int{1}},
+	OpLoadFree:      {"OpLoadFree", []int{1}},
+
+	OpArray:    {"OpArray", []int{2}},
+	OpHash:     {"OpHash", []int{2}},
+	OpIndex:    {"OpIndex", []int{}},
+	OpSetIndex: {"OpSetIndex", []int{1}},
+	OpMember:   {"OpMember", []int{2}},
+
+	OpClosure:     {"OpClosure", []int{2, 1}},
+	OpCall:        {"OpCall", []int{1, 2, 2}},
+	OpReturnValue: {"OpReturnValue", []int{}},
+
+	OpLoop:       {"OpLoop", []int{}},
+	OpLoopEnd:    {"OpLoopEnd", []int{}},
+	OpBreak:      {"OpBreak", []int{2}},
+	OpContinue:   {"OpContinue", []int{2}},
+	OpIter:       {"OpIter", []int{}},
+	OpIterNext:   {"OpIterNext", []int{2}},
+	OpMark:       {"OpMark", []int{2}},
+	OpUnmark:     {"OpUnmark", []int{}},
+	OpLoopSignal: {"OpLoopSignal", []int{1}},
+	OpError:      {"OpError", []int{2}},
+}
+
+// Lookup returns the definition of op.
+func Lookup(op byte) (*Definition, error) {
+	def, ok := definitions[Opcode(op)]
+	if !ok {
+		return nil, fmt.Errorf("opcode %d undefined", op)
+	}
+
+	return def, nil
+}
+
+// Make encodes an instruction, it returns nil if op is not defined.
+func Make(op Opcode, operands ...int) []byte {
+	def, ok := definitions[op]
+	if !ok {
+		return nil
+	}
+
+	length := 1
+	for _, w := range def.OperandWidths {
+		length += w
+	}
+
+	instruction := make([]byte, length)
+	instruction[0] = byte(op)
+
+	offset := 1
+	for i, o := range operands {
+		switch def.OperandWidths[i] {
+		case 2:
+			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
+		case 1:
+			instruction[offset] = byte(o)
+		}
+		offset += def.OperandWidths[i]
+	}
+
+	return instruction
+}
+
+// ReadOperands decodes the operands of an instruction described by def,
+// it returns them along with the number of bytes read.
+func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
+	operands := make([]int, len(def.OperandWidths))
+	offset := 0
+
+	for i, width := range def.OperandWidths {
+		switch width {
+		case 2:
+			operands[i] = int(ReadUint16(ins[offset:]))
+		case 1:
+			operands[i] = int(ReadUint8(ins[offset:]))
+		}
+		offset += width
+	}
+
+	return operands, offset
+}
+
+func ReadUint16(ins Instructions) uint16 { return binary.BigEndian.Uint16(ins) }
+
+func ReadUint8(ins Instructions) uint8 { return ins[0] }
+
+// String returns the instructions in a human readable form, one per line.
+func (ins Instructions) String() string {
+	var out bytes.Buffer
+
+	i := 0
+	for i < len(ins) {
+		def, err := Lookup(ins[i])
+		if err != nil {
+			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			i++
+			continue
+		}
+
+		operands, read := ReadOperands(def, ins[i+1:])
+		fmt.Fprintf(&out, "%04d %s\n", i, fmtInstruction(def, operands))
+
+		i += 1 + read
+	}
+
+	return out.String()
+}
+
+func fmtInstruction(def *Definition, operands []int) string {
+	if len(operands) != len(def.OperandWidths) {
+		return fmt.Sprintf("ERROR: operand len %d does not match defined %d\n", len(operands), len(def.OperandWidths))
+	}
+
+	res := def.Name
+	for _, operand := range operands {
+		res += fmt.Sprintf(" %d", operand)
+	}
+
+	return res
+}
+
+// Location maps the instructions from Offset on to the span of the node
+// they were compiled from.
+type Location struct {
+	Offset int
+	Loc    token.Span
+}
+
+// DebugInfo relates instructions to the source of a function.
+type DebugInfo struct {
+	// Locations are sorted by offset.
+	Locations []Location
+}
+
+// Span returns the span of the node the instruction at offset was compiled from.
+func (d DebugInfo) Span(offset int) token.Span {
+	i := sort.Search(len(d.Locations), func(i int) bool { return d.Locations[i].Offset > offset })
+	if i == 0 {
+		return token.Span{}
+	}
+
+	return d.Locations[i-1].Loc
+}
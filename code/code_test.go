@@ -0,0 +1,110 @@
+package code
+
+import (
+	"maz-lang/token"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMake(t *testing.T) {
+	tests := []struct {
+		Op       Opcode
+		Operands []int
+		Expected []byte
+	}{
+		{
+			Op:       OpConstant,
+			Operands: []int{65534},
+			Expected: []byte{byte(OpConstant), 255, 254},
+		},
+		{
+			Op:       OpAdd,
+			Operands: []int{},
+			Expected: []byte{byte(OpAdd)},
+		},
+		{
+			Op:       OpClosure,
+			Operands: []int{65534, 255},
+			Expected: []byte{byte(OpClosure), 255, 254, 255},
+		},
+		{
+			Op:       OpCall,
+			Operands: []int{2, 1, 0},
+			Expected: []byte{byte(OpCall), 2, 0, 1, 0, 0},
+		},
+	}
+
+	for _, tt := range tests {
+		instruction := Make(tt.Op, tt.Operands...)
+		if !cmp.Equal(instruction, tt.Expected) {
+			t.Errorf("expected instruction %v, instead got %v\n", tt.Expected, instruction)
+		}
+
+		def, err := Lookup(byte(tt.Op))
+		if err != nil {
+			t.Fatalf("unexpected error: %s\n", err)
+		}
+		operands, read := ReadOperands(def, instruction[1:])
+		if read != len(tt.Expected)-1 || !cmp.Equal(operands, tt.Operands) {
+			t.Errorf("expected operands %v, instead got %v\n", tt.Operands, operands)
+		}
+	}
+}
+
+func TestInstructionsString(t *testing.T) {
+	var ins Instructions
+	for _, i := range [][]byte{
+		Make(OpConstant, 1),
+		Make(OpGetLocal, 2),
+		Make(OpAdd),
+		Make(OpClosure, 3, 1),
+		Make(OpReturnValue),
+	} {
+		ins = append(ins, i...)
+	}
+
+	expected := `0000 OpConstant 1
+0003 OpGetLocal 2
+0006 OpAdd
+0007 OpClosure 3 1
+0011 OpReturnValue
+`
+	if ins.String() != expected {
+		t.Errorf("expected instructions to be\n%s\ninstead got\n%s\n", expected, ins.String())
+	}
+}
+
+func TestDebugInfoSpan(t *testing.T) {
+	first := token.Span{Start: token.Position{Line: 1, Column: 1}}
+	second := token.Span{Start: token.Position{Line: 2, Column: 5}}
+	debug := DebugInfo{Locations: []Location{{Offset: 0, Loc: first}, {Offset: 4, Loc: second}}}
+
+	tests := []struct {
+		Offset   int
+		Expected token.Span
+	}{
+		{
+			Offset:   0,
+			Expected: first,
+		},
+		{
+			Offset:   3,
+			Expected: first,
+		},
+		{
+			Offset:   4,
+			Expected: second,
+		},
+		{
+			Offset:   10,
+			Expected: second,
+		},
+	}
+
+	for _, tt := range tests {
+		if span := debug.Span(tt.Offset); span != tt.Expected {
+			t.Errorf("expected span of offset %d to start at %s, instead got %s\n", tt.Offset, tt.Expected.Start, span.Start)
+		}
+	}
+}
@@ -0,0 +1,806 @@
+// Package compiler lowers programs to the instructions executed by the
+// virtual machine.
+package compiler
+
+import (
+	"fmt"
+	"math"
+	"maz-lang/ast"
+	"maz-lang/code"
+	"maz-lang/environment"
+	"maz-lang/object"
+	"maz-lang/resolver"
+	"maz-lang/token"
+	"strings"
+)
+
+// Bytecode is a compiled program.
+type Bytecode struct {
+	// Main holds the top level statements of the program, its locals are
+	// the variables declared in their blocks.
+	Main      *object.CompiledFunction
+	Constants []object.Object
+	// Globals are the names of the global variables, by slot.
+	Globals []string
+}
+
+// The operators with an instruction of their own.
+var infixOperators = map[string]code.Opcode{
+	"+":  code.OpAdd,
+	"-":  code.OpSub,
+	"*":  code.OpMul,
+	"/":  code.OpDiv,
+	"%":  code.OpMod,
+	"**": code.OpPow,
+	"&":  code.OpBitAnd,
+	"|":  code.OpBitOr,
+	"^":  code.OpBitXor,
+	"<<": code.OpShiftLeft,
+	">>": code.OpShiftRight,
+	"==": code.OpEqual,
+	"!=": code.OpNotEqual,
+	">":  code.OpGreater,
+	">=": code.OpGreaterEqual,
+	"<":  code.OpLess,
+	"<=": code.OpLessEqual,
+}
+
+var prefixOperators = map[string]code.Opcode{
+	"-": code.OpMinus,
+	"!": code.OpBang,
+	"~": code.OpBitNot,
+}
+
+// compilationScope holds the instructions of the function being compiled.
+type compilationScope struct {
+	instructions code.Instructions
+	locations    []code.Location
+	loops        []*loop
+	function     bool
+}
+
+type loop struct {
+	start  int
+	breaks []int
+}
+
+type Compiler struct {
+	constants []object.Object
+	constPool map[any]int
+	symbols   *SymbolTable
+	scopes    []*compilationScope
+	// nodes are the nodes being compiled, the instructions are tagged
+	// with the span of the innermost one.
+	nodes   []ast.Node
+	program *ast.Program
+	// err is the first operand found too large for its instruction.
+	err error
+}
+
+func New() *Compiler {
+	return &Compiler{
+		constPool: map[any]int{},
+		symbols:   NewSymbolTable(),
+	}
+}
+
+// Compile compiles a whole program, it can be called only once.
+func (c *Compiler) Compile(program *ast.Program) error {
+	// The mistakes the evaluator rejects before running a program are
+	// rejected here too.
+	globals := environment.New()
+	if err := resolver.Resolve(program, &globals); err != nil {
+		return fmt.Errorf("%s: %w", err.Loc.Start, err)
+	}
+
+	c.program = program
+	c.symbols.captured = capturedNames(program.Statements)
+	c.scopes = append(c.scopes, &compilationScope{})
+	c.nodes = append(c.nodes, program)
+
+	if len(program.Statements) == 0 {
+		c.emit(code.OpNull)
+	}
+	for i, stmt := range program.Statements {
+		// A return outside of functions only leaves the statement it is in
+		mark := -1
+		if containsReturn(stmt) {
+			mark = c.emit(code.OpMark, 0)
+		}
+
+		if err := c.compile(stmt); err != nil {
+			return err
+		}
+
+		if mark >= 0 {
+			c.emit(code.OpUnmark)
+			c.changeOperand(mark, len(c.scope().instructions))
+		}
+		if i < len(program.Statements)-1 {
+			c.emit(code.OpPop)
+		}
+	}
+	c.emit(code.OpReturnValue)
+
+	if c.err != nil {
+		return c.err
+	}
+	if len(c.scope().instructions) > math.MaxUint16 {
+		return fmt.Errorf("program too large: %d bytes of instructions", len(c.scope().instructions))
+	}
+
+	return nil
+}
+
+// Bytecode returns the compiled program.
+func (c *Compiler) Bytecode() *Bytecode {
+	scope := c.scope()
+	return &Bytecode{
+		Main: &object.CompiledFunction{
+			Instructions: scope.instructions,
+			NumLocals:    c.symbols.NumLocals(),
+			Name:         "<main>",
+			Debug:        code.DebugInfo{Locations: scope.locations},
+		},
+		Constants: c.constants,
+		Globals:   c.symbols.Globals(),
+	}
+}
+
+func (c *Compiler) compile(node ast.Node) error {
+	c.nodes = append(c.nodes, node)
+	defer func() { c.nodes = c.nodes[:len(c.nodes)-1] }()
+
+	switch node := node.(type) {
+	case *ast.SyntaxError:
+		c.emit(code.OpError, c.addConstant(&object.String{Value: node.String()}))
+	case *ast.IntegerLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.Integer{Value: node.Value}))
+	case *ast.FloatLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.Float{Value: node.Value}))
+	case *ast.StringLiteral:
+		c.emit(code.OpConstant, c.addConstant(&object.String{Value: node.Value}))
+	case *ast.BooleanLiteral:
+		if node.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+	case *ast.Identifier:
+		c.loadSymbol(c.symbols.Resolve(node.Name))
+	case *ast.PrefixExpression:
+		op, ok := prefixOperators[node.Prefix.Literal]
+		if !ok {
+			return fmt.Errorf("%s: unknown operator: %s", node.Loc.Start, node.Prefix.Literal)
+		}
+		if err := c.compile(node.Value); err != nil {
+			return err
+		}
+		c.emit(op)
+	case *ast.InfixExpression:
+		return c.compileInfix(node)
+	case *ast.LetStatement:
+		return c.compileLet(node)
+	case *ast.IfStatement:
+		return c.compileIf(node)
+	case *ast.WhileStatement:
+		return c.compileWhile(node)
+	case *ast.ForStatement:
+		return c.compileFor(node)
+	case *ast.BreakStatement:
+		c.compileLoopJump(code.OpBreak)
+	case *ast.ContinueStatement:
+		c.compileLoopJump(code.OpContinue)
+	case *ast.ReturnStatement:
+		if err := c.compile(node.Expression); err != nil {
+			return err
+		}
+		c.emit(code.OpReturnValue)
+	case *ast.FunctionDefinition:
+		return c.compileFunctionDef(node)
+	case *ast.CallExpression:
+		return c.compileCall(node)
+	case *ast.MemberExpression:
+		if err := c.compile(node.Object); err != nil {
+			return err
+		}
+		c.emit(code.OpMember, c.addConstant(&object.String{Value: node.Property}))
+	case *ast.ArrayLiteral:
+		if err := c.compileAll(node.Elements); err != nil {
+			return err
+		}
+		c.emit(code.OpArray, len(node.Elements))
+	case *ast.IndexExpression:
+		if err := c.compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.compile(node.Index); err != nil {
+			return err
+		}
+		c.emit(code.OpIndex)
+	case *ast.HashLiteral:
+		for _, pair := range node.Pairs {
+			if err := c.compile(pair.Key); err != nil {
+				return err
+			}
+			if err := c.compile(pair.Value); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpHash, len(node.Pairs))
+	case *ast.AssignExpression:
+		return c.compileAssign(node)
+	default:
+		return fmt.Errorf("%s: cannot compile %T", node.Span().Start, node)
+	}
+
+	return nil
+}
+
+func (c *Compiler) compileAll(nodes []ast.Node) error {
+	for _, node := range nodes {
+		if err := c.compile(node); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// compileBlock compiles statements in the current scope, leaving the value
+// of the last one on the stack.
+func (c *Compiler) compileBlock(statements []ast.Node) error {
+	if len(statements) == 0 {
+		c.emit(code.OpNull)
+	}
+
+	for i, stmt := range statements {
+		if err := c.compile(stmt); err != nil {
+			return err
+		}
+		if i < len(statements)-1 {
+			c.emit(code.OpPop)
+		}
+	}
+
+	return nil
+}
+
+// compileScopedBlock compiles statements in a new scope.
+func (c *Compiler) compileScopedBlock(statements []ast.Node) error {
+	c.symbols.EnterBlock()
+	defer c.symbols.LeaveBlock()
+
+	return c.compileBlock(statements)
+}
+
+// compileInfix compiles binary operators, '&&' and '||' skip their right
+// side if the left one already decides the result.
+func (c *Compiler) compileInfix(node *ast.InfixExpression) error {
+	if err := c.compile(node.Left); err != nil {
+		return err
+	}
+
+	if node.Operator.Type == token.AND || node.Operator.Type == token.OR {
+		c.emit(code.OpBool)
+		jump := code.OpJumpIfFalseOrPop
+		if node.Operator.Type == token.OR {
+			jump = code.OpJumpIfTrueOrPop
+		}
+		pos := c.emit(jump, 0)
+
+		if err := c.compile(node.Right); err != nil {
+			return err
+		}
+		c.emit(code.OpBool)
+		c.changeOperand(pos, len(c.scope().instructions))
+
+		return nil
+	}
+
+	op, ok := infixOperators[node.Operator.Literal]
+	if !ok {
+		return fmt.Errorf("%s: unknown operator: %s", node.Loc.Start, node.Operator.Literal)
+	}
+	if err := c.compile(node.Right); err != nil {
+		return err
+	}
+	c.emit(op)
+
+	return nil
+}
+
+// compileLet evaluates to true, like the evaluator does.
+func (c *Compiler) compileLet(node *ast.LetStatement) error {
+	// Functions may refer to themselves, any other value sees the
+	// variables which were visible before the declaration.
+	_, isFunction := node.Value.(*ast.FunctionDefinition)
+	var sym Symbol
+	if isFunction {
+		sym = c.define(node.Ident)
+	}
+
+	if err := c.compile(node.Value); err != nil {
+		return err
+	}
+
+	if !isFunction {
+		sym = c.define(node.Ident)
+	}
+	c.storeSymbol(sym)
+	c.emit(code.OpTrue)
+
+	return nil
+}
+
+// define declares a variable in the current scope, variables shared with
+// closures get a new cell each time their declaration is executed.
+func (c *Compiler) define(name string) Symbol {
+	sym, isNew := c.symbols.Define(name)
+	if isNew && sym.Cell {
+		c.emit(code.OpNewCell, sym.Index)
+	}
+
+	return sym
+}
+
+func (c *Compiler) compileIf(node *ast.IfStatement) error {
+	if err := c.compile(node.MainCondition); err != nil {
+		return err
+	}
+	next := c.emit(code.OpJumpIfFalse, 0)
+
+	if err := c.compileScopedBlock(node.MainStatements); err != nil {
+		return err
+	}
+	ends := []int{c.emit(code.OpJump, 0)}
+
+	for _, elseIf := range node.ElseIfs {
+		c.changeOperand(next, len(c.scope().instructions))
+
+		c.symbols.EnterBlock()
+		if err := c.compile(elseIf.Condition); err != nil {
+			return err
+		}
+		next = c.emit(code.OpJumpIfFalse, 0)
+		if err := c.compileBlock(elseIf.Statements); err != nil {
+			return err
+		}
+		c.symbols.LeaveBlock()
+
+		ends = append(ends, c.emit(code.OpJump, 0))
+	}
+
+	c.changeOperand(next, len(c.scope().instructions))
+	if err := c.compileScopedBlock(node.ElseStatements); err != nil {
+		return err
+	}
+
+	for _, pos := range ends {
+		c.changeOperand(pos, len(c.scope().instructions))
+	}
+
+	return nil
+}
+
+func (c *Compiler) compileWhile(node *ast.WhileStatement) error {
+	c.emit(code.OpLoop)
+	l := c.enterLoop()
+
+	if err := c.compile(node.Condition); err != nil {
+		return err
+	}
+	exit := c.emit(code.OpJumpIfFalse, 0)
+
+	if err := c.compileScopedBlock(node.Statements); err != nil {
+		return err
+	}
+	c.emit(code.OpPop)
+	c.emit(code.OpJump, l.start)
+
+	c.changeOperand(exit, len(c.scope().instructions))
+	c.leaveLoop()
+	c.emit(code.OpNull)
+
+	return nil
+}
+
+func (c *Compiler) compileFor(node *ast.ForStatement) error {
+	if err := c.compile(node.Iterable); err != nil {
+		return err
+	}
+	c.emit(code.OpIter)
+	c.emit(code.OpLoop)
+	l := c.enterLoop()
+
+	exit := c.emit(code.OpIterNext, 0)
+
+	c.symbols.EnterBlock()
+	c.storeSymbol(c.define(node.Variable))
+	if err := c.compileBlock(node.Statements); err != nil {
+		return err
+	}
+	c.symbols.LeaveBlock()
+	c.emit(code.OpPop)
+	c.emit(code.OpJump, l.start)
+
+	c.changeOperand(exit, len(c.scope().instructions))
+	c.leaveLoop()
+	c.emit(code.OpPop)
+	c.emit(code.OpNull)
+
+	return nil
+}
+
+// enterLoop starts a loop at the current instruction, leaveLoop ends it
+// and makes its breaks jump to the end.
+func (c *Compiler) enterLoop() *loop {
+	scope := c.scope()
+	l := &loop{start: len(scope.instructions)}
+	scope.loops = append(scope.loops, l)
+
+	return l
+}
+
+func (c *Compiler) leaveLoop() {
+	scope := c.scope()
+	l := scope.loops[len(scope.loops)-1]
+	scope.loops = scope.loops[:len(scope.loops)-1]
+
+	for _, pos := range l.breaks {
+		c.changeOperand(pos, len(scope.instructions))
+	}
+	c.emit(code.OpLoopEnd)
+}
+
+// compileLoopJump compiles a break or a continue, outside of a loop they
+// make the function fail where it was called, or the program fail as a whole.
+func (c *Compiler) compileLoopJump(op code.Opcode) {
+	scope := c.scope()
+	if len(scope.loops) == 0 {
+		signal := 0
+		if op == code.OpContinue {
+			signal = 1
+		}
+
+		if !scope.function {
+			c.nodes = append(c.nodes, c.program)
+			defer func() { c.nodes = c.nodes[:len(c.nodes)-1] }()
+		}
+		c.emit(code.OpLoopSignal, signal)
+
+		return
+	}
+
+	l := scope.loops[len(scope.loops)-1]
+	if op == code.OpContinue {
+		c.emit(code.OpContinue, l.start)
+		return
+	}
+	l.breaks = append(l.breaks, c.emit(code.OpBreak, 0))
+}
+
+// compileFunctionDef compiles a function, named ones are also declared in
+// the current scope and must not clash with a visible variable.
+func (c *Compiler) compileFunctionDef(node *ast.FunctionDefinition) error {
+	if node.Name == "" {
+		return c.compileFunction(node)
+	}
+
+	existing := c.symbols.Resolve(node.Name)
+	if existing.Scope != GlobalScope {
+		msg := fmt.Sprintf("evaluation error: function with name '%s' already exists", node.Name)
+		c.emit(code.OpError, c.addConstant(&object.String{Value: msg}))
+		return nil
+	}
+	c.emit(code.OpCheckFunction, existing.Index)
+
+	sym := c.define(node.Name)
+	if err := c.compileFunction(node); err != nil {
+		return err
+	}
+	c.emit(code.OpDup)
+	c.storeSymbol(sym)
+
+	return nil
+}
+
+// compileFunction compiles the body of a function and pushes a closure
+// over the variables it uses from the enclosing functions.
+func (c *Compiler) compileFunction(node *ast.FunctionDefinition) error {
+	c.symbols = NewEnclosedSymbolTable(c.symbols, capturedNames(node.Body))
+	c.scopes = append(c.scopes, &compilationScope{function: true})
+
+	for _, param := range node.Parameters {
+		ident, ok := param.(*ast.Identifier)
+		if !ok {
+			return fmt.Errorf("%s: invalid parameter: %s", param.Span().Start, strings.TrimSpace(param.String()))
+		}
+
+		sym := c.symbols.DefineParameter(ident.Name)
+		if sym.Cell {
+			c.emit(code.OpGetLocal, sym.Index)
+			c.emit(code.OpNewCell, sym.Index)
+			c.emit(code.OpSetCell, sym.Index)
+		}
+	}
+
+	if err := c.compileBlock(node.Body); err != nil {
+		return err
+	}
+	c.emit(code.OpReturnValue)
+
+	scope := c.scope()
+	if c.err != nil {
+		return c.err
+	}
+	if len(scope.instructions) > math.MaxUint16 {
+		return fmt.Errorf("%s: function too large: %d bytes of instructions", node.Loc.Start, len(scope.instructions))
+	}
+
+	symbols := c.symbols
+	c.symbols = symbols.Outer
+	c.scopes = c.scopes[:len(c.scopes)-1]
+
+	fn := &object.CompiledFunction{
+		Instructions:  scope.instructions,
+		NumLocals:     symbols.NumLocals(),
+		NumParameters: len(node.Parameters),
+		Name:          node.Name,
+		Debug:         code.DebugInfo{Locations: scope.locations},
+	}
+
+	if len(symbols.FreeSymbols) > math.MaxUint8 {
+		return fmt.Errorf("%s: function uses too many variables of the enclosing functions", node.Loc.Start)
+	}
+	for _, sym := range symbols.FreeSymbols {
+		if sym.Scope == FreeScope {
+			c.emit(code.OpLoadFree, sym.Index)
+		} else {
+			c.emit(code.OpLoadCell, sym.Index)
+		}
+	}
+	c.emit(code.OpClosure, c.addConstant(fn), len(symbols.FreeSymbols))
+
+	return nil
+}
+
+func (c *Compiler) compileCall(node *ast.CallExpression) error {
+	if err := c.compile(node.Callee); err != nil {
+		return err
+	}
+	if err := c.compileAll(node.Arguments); err != nil {
+		return err
+	}
+	if len(node.Arguments) > math.MaxUint8 {
+		return fmt.Errorf("%s: too many arguments in function call", node.Loc.Start)
+	}
+
+	// The callee is described in the error raised if it cannot be called,
+	// the global it comes from tells whether it was ever defined.
+	global := 0
+	if ident, ok := node.Callee.(*ast.Identifier); ok {
+		if sym := c.symbols.Resolve(ident.Name); sym.Scope == GlobalScope {
+			global = sym.Index + 1
+		}
+	}
+	callee := c.addConstant(&object.String{Value: strings.TrimSpace(node.Callee.String())})
+	c.emit(code.OpCall, len(node.Arguments), callee, global)
+
+	return nil
+}
+
+func (c *Compiler) compileAssign(node *ast.AssignExpression) error {
+	op := code.Opcode(0)
+	if node.Operator.Type != token.ASSIGN {
+		var ok bool
+		op, ok = infixOperators[strings.TrimSuffix(node.Operator.Literal, "=")]
+		if !ok {
+			return fmt.Errorf("%s: unknown operator: %s", node.Loc.Start, node.Operator.Literal)
+		}
+	}
+
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		sym := c.symbols.Resolve(target.Name)
+		if sym.Scope == GlobalScope {
+			c.emit(code.OpCheckGlobal, sym.Index)
+		}
+		if op != 0 {
+			c.loadSymbol(sym)
+		}
+		if err := c.compile(node.Value); err != nil {
+			return err
+		}
+		if op != 0 {
+			c.emit(op)
+		}
+		c.emit(code.OpDup)
+		c.storeSymbol(sym)
+	case *ast.IndexExpression:
+		if err := c.compileAll([]ast.Node{target.Left, target.Index, node.Value}); err != nil {
+			return err
+		}
+		c.emit(code.OpSetIndex, int(op))
+	case *ast.MemberExpression:
+		if err := c.compile(target.Object); err != nil {
+			return err
+		}
+		c.emit(code.OpConstant, c.addConstant(&object.String{Value: target.Property}))
+		if err := c.compile(node.Value); err != nil {
+			return err
+		}
+		c.emit(code.OpSetIndex, int(op))
+	default:
+		msg := fmt.Sprintf("invalid assignment target: %s", strings.TrimSpace(node.Target.String()))
+		c.emit(code.OpError, c.addConstant(&object.String{Value: msg}))
+	}
+
+	return nil
+}
+
+func (c *Compiler) loadSymbol(sym Symbol) {
+	switch {
+	case sym.Scope == GlobalScope:
+		c.emit(code.OpGetGlobal, sym.Index)
+	case sym.Scope == FreeScope:
+		c.emit(code.OpGetFree, sym.Index)
+	case sym.Cell:
+		c.emit(code.OpGetCell, sym.Index)
+	default:
+		c.emit(code.OpGetLocal, sym.Index)
+	}
+}
+
+// storeSymbol pops the value on top of the stack into sym.
+func (c *Compiler) storeSymbol(sym Symbol) {
+	switch {
+	case sym.Scope == GlobalScope:
+		c.emit(code.OpSetGlobal, sym.Index)
+	case sym.Scope == FreeScope:
+		c.emit(code.OpSetFree, sym.Index)
+	case sym.Cell:
+		c.emit(code.OpSetCell, sym.Index)
+	default:
+		c.emit(code.OpSetLocal, sym.Index)
+	}
+}
+
+// addConstant adds obj to the constant pool and returns its index, equal
+// numbers and strings share the same constant.
+func (c *Compiler) addConstant(obj object.Object) int {
+	var key any
+	switch obj := obj.(type) {
+	case *object.Integer:
+		key = obj.Value
+	case *object.Float:
+		// 0.0 and -0.0 are equal but must not share a constant
+		key = math.Float64bits(obj.Value)
+	case *object.String:
+		key = obj.Value
+	}
+
+	if key != nil {
+		if index, ok := c.constPool[key]; ok {
+			return index
+		}
+		c.constPool[key] = len(c.constants)
+	}
+
+	c.constants = append(c.constants, obj)
+	return len(c.constants) - 1
+}
+
+func (c *Compiler) scope() *compilationScope {
+	return c.scopes[len(c.scopes)-1]
+}
+
+// emit appends an instruction to the current function and returns its
+// position, the instruction is tagged with the span of the current node.
+func (c *Compiler) emit(op code.Opcode, operands ...int) int {
+	scope := c.scope()
+	pos := len(scope.instructions)
+
+	span := c.nodes[len(c.nodes)-1].Span()
+	if c.err == nil {
+		c.err = checkOperands(op, operands, span.Start)
+	}
+	if n := len(scope.locations); n == 0 || scope.locations[n-1].Loc != span {
+		scope.locations = append(scope.locations, code.Location{Offset: pos, Loc: span})
+	}
+	scope.instructions = append(scope.instructions, code.Make(op, operands...)...)
+
+	return pos
+}
+
+// operandNames tells what the operands of the instructions count or refer
+// to, for the errors about the programs which exceed their size.
+var operandNames = map[code.Opcode][]string{
+	code.OpConstant:      {"constants"},
+	code.OpGetGlobal:     {"global variables"},
+	code.OpSetGlobal:     {"global variables"},
+	code.OpCheckGlobal:   {"global variables"},
+	code.OpCheckFunction: {"global variables"},
+	code.OpGetLocal:      {"local variables"},
+	code.OpSetLocal:      {"local variables"},
+	code.OpNewCell:       {"local variables"},
+	code.OpGetCell:       {"local variables"},
+	code.OpSetCell:       {"local variables"},
+	code.OpLoadCell:      {"local variables"},
+	code.OpGetFree:       {"captured variables"},
+	code.OpSetFree:       {"captured variables"},
+	code.OpLoadFree:      {"captured variables"},
+	code.OpArray:         {"array elements"},
+	code.OpHash:          {"hash pairs"},
+	code.OpMember:        {"constants"},
+	code.OpClosure:       {"constants", "captured variables"},
+	code.OpCall:          {"arguments", "constants", "global variables"},
+	code.OpError:         {"constants"},
+}
+
+// checkOperands returns an error if an operand does not fit in the bytes
+// the instruction has for it, instead of letting it wrap around.
+func checkOperands(op code.Opcode, operands []int, pos token.Position) error {
+	def, _ := code.Lookup(byte(op))
+	for i, operand := range operands {
+		limit := 1<<(8*def.OperandWidths[i]) - 1
+		if operand > limit && i < len(operandNames[op]) {
+			return fmt.Errorf("%s: program too large: more than %d %s", pos, limit, operandNames[op][i])
+		}
+	}
+
+	return nil
+}
+
+// changeOperand replaces the first operand of the instruction at pos,
+// it is used to patch jumps once their target is known.
+func (c *Compiler) changeOperand(pos int, operand int) {
+	ins := c.scope().instructions
+	def, _ := code.Lookup(ins[pos])
+	operands, _ := code.ReadOperands(def, ins[pos+1:])
+	operands[0] = operand
+
+	copy(ins[pos:], code.Make(code.Opcode(ins[pos]), operands...))
+}
+
+// capturedNames returns the names used by the functions nested in body,
+// the variables with those names are stored in cells.
+func capturedNames(body []ast.Node) map[string]bool {
+	names := map[string]bool{}
+
+	for _, stmt := range body {
+		ast.Inspect(stmt, func(n ast.Node) bool {
+			fn, ok := n.(*ast.FunctionDefinition)
+			if !ok {
+				return true
+			}
+
+			ast.Inspect(fn, func(n ast.Node) bool {
+				if ident, ok := n.(*ast.Identifier); ok {
+					names[ident.Name] = true
+				}
+				return true
+			})
+			return false
+		})
+	}
+
+	return names
+}
+
+// containsReturn reports whether node returns, outside of the functions
+// defined in it.
+func containsReturn(node ast.Node) bool {
+	found := false
+	ast.Inspect(node, func(n ast.Node) bool {
+		switch n.(type) {
+		case *ast.ReturnStatement:
+			found = true
+		case *ast.FunctionDefinition:
+			return false
+		}
+		return !found
+	})
+
+	return found
+}
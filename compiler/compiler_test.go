@@ -0,0 +1,287 @@
+package compiler
+
+import (
+	"fmt"
+	"math"
+	"maz-lang/ast"
+	"maz-lang/code"
+	"maz-lang/lexer"
+	"maz-lang/object"
+	"maz-lang/parser"
+	"maz-lang/token"
+	"strings"
+	"testing"
+)
+
+func concatInstructions(instructions ...[]byte) code.Instructions {
+	var res code.Instructions
+	for _, ins := range instructions {
+		res = append(res, ins...)
+	}
+
+	return res
+}
+
+func compile(t *testing.T, src string) *Bytecode {
+	l := lexer.New(src)
+	program := parser.New(&l).Parse(token.EOF)
+
+	c := New()
+	if err := c.Compile(&program); err != nil {
+		t.Fatalf("unexpected compiler error: %s\n", err)
+	}
+
+	return c.Bytecode()
+}
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		Source               string
+		ExpectedInstructions code.Instructions
+		ExpectedNumLocals    int
+	}{
+		{
+			Source: "1 + 2 * 1",
+			ExpectedInstructions: concatInstructions(
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpMul),
+				code.Make(code.OpAdd),
+				code.Make(code.OpReturnValue),
+			),
+		},
+		{
+			Source: "let a = true; a = !a",
+			ExpectedInstructions: concatInstructions(
+				code.Make(code.OpTrue),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+				code.Make(code.OpCheckGlobal, 0),
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpBang),
+				code.Make(code.OpDup),
+				code.Make(code.OpSetGlobal, 0),
+				code.Make(code.OpReturnValue),
+			),
+		},
+		{
+			Source: "if x { let y = 1; y } else { 2 }",
+			ExpectedInstructions: concatInstructions(
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpJumpIfFalse, 20),
+				code.Make(code.OpConstant, 0),
+				code.Make(code.OpSetLocal, 0),
+				code.Make(code.OpTrue),
+				code.Make(code.OpPop),
+				code.Make(code.OpGetLocal, 0),
+				code.Make(code.OpJump, 23),
+				code.Make(code.OpConstant, 1),
+				code.Make(code.OpReturnValue),
+			),
+			ExpectedNumLocals: 1,
+		},
+		{
+			Source: "x && y",
+			ExpectedInstructions: concatInstructions(
+				code.Make(code.OpGetGlobal, 0),
+				code.Make(code.OpBool),
+				code.Make(code.OpJumpIfFalseOrPop, 11),
+				code.Make(code.OpGetGlobal, 1),
+				code.Make(code.OpBool),
+				code.Make(code.OpReturnValue),
+			),
+		},
+		{
+			Source: "while true { break; }",
+			ExpectedInstructions: concatInstructions(
+				code.Make(code.OpLoop),
+				code.Make(code.OpTrue),
+				code.Make(code.OpJumpIfFalse, 12),
+				code.Make(code.OpBreak, 12),
+				code.Make(code.OpPop),
+				code.Make(code.OpJump, 1),
+				code.Make(code.OpLoopEnd),
+				code.Make(code.OpNull),
+				code.Make(code.OpReturnValue),
+			),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("compiling: '%s'\n", tt.Source)
+		bytecode := compile(t, tt.Source)
+
+		if bytecode.Main.Instructions.String() != tt.ExpectedInstructions.String() {
+			t.Errorf("expected instructions\n%s\ninstead got\n%s\n", tt.ExpectedInstructions, bytecode.Main.Instructions)
+		}
+		if bytecode.Main.NumLocals != tt.ExpectedNumLocals {
+			t.Errorf("expected %d locals, instead got %d\n", tt.ExpectedNumLocals, bytecode.Main.NumLocals)
+		}
+	}
+}
+
+func TestCompileClosure(t *testing.T) {
+	bytecode := compile(t, "fn counter(n) { return fn() { n += 1; return n; }; }")
+
+	var counter, inner *object.CompiledFunction
+	for _, constant := range bytecode.Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			if fn.Name == "counter" {
+				counter = fn
+			} else {
+				inner = fn
+			}
+		}
+	}
+	if counter == nil || inner == nil {
+		t.Fatalf("expected both functions among the constants, instead got %+v\n", bytecode.Constants)
+	}
+
+	// n is captured, so the parameter is moved to a cell first
+	expected := concatInstructions(
+		code.Make(code.OpGetLocal, 0),
+		code.Make(code.OpNewCell, 0),
+		code.Make(code.OpSetCell, 0),
+		code.Make(code.OpLoadCell, 0),
+		code.Make(code.OpClosure, 1, 1),
+		code.Make(code.OpReturnValue),
+		code.Make(code.OpReturnValue),
+	)
+	if counter.Instructions.String() != expected.String() {
+		t.Errorf("expected instructions\n%s\ninstead got\n%s\n", expected, counter.Instructions)
+	}
+
+	expected = concatInstructions(
+		code.Make(code.OpGetFree, 0),
+		code.Make(code.OpConstant, 0),
+		code.Make(code.OpAdd),
+		code.Make(code.OpDup),
+		code.Make(code.OpSetFree, 0),
+		code.Make(code.OpPop),
+		code.Make(code.OpGetFree, 0),
+		code.Make(code.OpReturnValue),
+		code.Make(code.OpReturnValue),
+	)
+	if inner.Instructions.String() != expected.String() {
+		t.Errorf("expected instructions\n%s\ninstead got\n%s\n", expected, inner.Instructions)
+	}
+}
+
+// name returns a distinct identifier for every i, identifiers cannot
+// contain digits.
+func name(i int) string {
+	res := []byte{'v'}
+	for ; i > 0; i /= 26 {
+		res = append(res, byte('a'+i%26))
+	}
+
+	return string(res)
+}
+
+// repeat joins the results of f for 0 up to n (excluded).
+func repeat(n int, sep string, f func(i int) string) string {
+	parts := make([]string, n)
+	for i := range parts {
+		parts[i] = f(i)
+	}
+
+	return strings.Join(parts, sep)
+}
+
+func TestCompileLimits(t *testing.T) {
+	tests := []struct {
+		Source        string
+		ExpectedError string
+	}{
+		{
+			Source: repeat(30, "\n", func(i int) string {
+				return fmt.Sprintf("fn %s() { return [%s]; }", name(i), repeat(2500, ", ", func(j int) string {
+					return fmt.Sprint(i*2500 + j)
+				}))
+			}),
+			ExpectedError: "more than 65535 constants",
+		},
+		{
+			Source:        repeat(65537, "\n", func(i int) string { return fmt.Sprintf("let %s = 0;", name(i)) }),
+			ExpectedError: "more than 65535 global variables",
+		},
+		{
+			Source:        "fn f() {" + repeat(65537, "\n", func(i int) string { return fmt.Sprintf("let %s = 0;", name(i)) }) + "}",
+			ExpectedError: "more than 65535 local variables",
+		},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.Source)
+		program := parser.New(&l).Parse(token.EOF)
+
+		err := New().Compile(&program)
+		if err == nil || !strings.Contains(err.Error(), tt.ExpectedError) {
+			t.Errorf("expected an error about %q, instead got %v\n", tt.ExpectedError, err)
+		}
+	}
+}
+
+func TestCompileFloatConstants(t *testing.T) {
+	l := lexer.New("0.0; 0.0")
+	program := parser.New(&l).Parse(token.EOF)
+	// The optimizer can make a literal -0.0
+	program.Statements[0].(*ast.FloatLiteral).Value = math.Copysign(0, -1)
+
+	c := New()
+	if err := c.Compile(&program); err != nil {
+		t.Fatalf("unexpected compiler error: %s\n", err)
+	}
+
+	constants := c.Bytecode().Constants
+	if len(constants) != 2 || !math.Signbit(constants[0].(*object.Float).Value) || math.Signbit(constants[1].(*object.Float).Value) {
+		t.Errorf("expected the constants -0.0 and 0.0, instead got %+v\n", constants)
+	}
+}
+
+func TestSymbolTable(t *testing.T) {
+	main := NewSymbolTable()
+	global, _ := main.Define("a")
+	main.EnterBlock()
+	local, isNew := main.Define("b")
+	if global.Scope != GlobalScope || local.Scope != LocalScope || !isNew {
+		t.Fatalf("expected a global and a new local, instead got %+v and %+v\n", global, local)
+	}
+
+	fn := NewEnclosedSymbolTable(main, map[string]bool{})
+	param := fn.DefineParameter("c")
+
+	tests := []struct {
+		Name     string
+		Expected Symbol
+	}{
+		{
+			Name:     "a",
+			Expected: global,
+		},
+		{
+			Name:     "b",
+			Expected: Symbol{Name: "b", Scope: FreeScope, Index: 0},
+		},
+		{
+			Name:     "c",
+			Expected: param,
+		},
+		{
+			Name:     "len",
+			Expected: Symbol{Name: "len", Scope: GlobalScope, Index: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		if sym := fn.Resolve(tt.Name); sym != tt.Expected {
+			t.Errorf("expected %s to resolve to %+v, instead got %+v\n", tt.Name, tt.Expected, sym)
+		}
+	}
+
+	if len(fn.FreeSymbols) != 1 || fn.FreeSymbols[0] != local {
+		t.Errorf("expected b to be captured from the enclosing function, instead got %+v\n", fn.FreeSymbols)
+	}
+}
@@ -0,0 +1,114 @@
+package compiler
+
+import (
+	"fmt"
+	"io"
+	"maz-lang/code"
+	"maz-lang/object"
+	"strings"
+)
+
+// Disassemble writes a human readable listing of the program to w: the
+// instructions of each function, starting with the top level statements,
+// followed by the constant pool.
+//
+// Each instruction is shown with its offset, the line and column of the
+// node it was compiled from, its operands and what they refer to.
+func (b *Bytecode) Disassemble(w io.Writer) error {
+	fns := []*object.CompiledFunction{b.Main}
+	for _, constant := range b.Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			fns = append(fns, fn)
+		}
+	}
+
+	var out strings.Builder
+	for _, fn := range fns {
+		name := fn.Name
+		if name == "" {
+			name = "<anonymous>"
+		}
+		fmt.Fprintf(&out, "== %s ==\n", name)
+		fmt.Fprintf(&out, "parameters: %d, locals: %d\n", fn.NumParameters, fn.NumLocals)
+		b.disassembleFunction(&out, fn)
+		out.WriteString("\n")
+	}
+
+	out.WriteString("== constants ==\n")
+	for i, constant := range b.Constants {
+		fmt.Fprintf(&out, "%04d %-17s %s\n", i, constant.Type(), describeConstant(constant))
+	}
+
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+func (b *Bytecode) disassembleFunction(out *strings.Builder, fn *object.CompiledFunction) {
+	ins := fn.Instructions
+
+	for i := 0; i < len(ins); {
+		def, err := code.Lookup(ins[i])
+		if err != nil {
+			fmt.Fprintf(out, "%04d ERROR: %s\n", i, err)
+			i++
+			continue
+		}
+		operands, read := code.ReadOperands(def, ins[i+1:])
+
+		text := def.Name
+		for _, operand := range operands {
+			text += fmt.Sprintf(" %d", operand)
+		}
+
+		pos := "-"
+		if start := fn.Debug.Span(i).Start; start.IsValid() {
+			pos = fmt.Sprintf("%d:%d", start.Line, start.Column)
+		}
+
+		line := fmt.Sprintf("%04d %-7s %-24s", i, pos, text)
+		if comment := b.comment(code.Opcode(ins[i]), operands); comment != "" {
+			line += " ; " + comment
+		}
+		fmt.Fprintln(out, strings.TrimRight(line, " "))
+
+		i += 1 + read
+	}
+}
+
+// comment describes what the operands of an instruction refer to.
+func (b *Bytecode) comment(op code.Opcode, operands []int) string {
+	switch op {
+	case code.OpConstant, code.OpMember, code.OpError, code.OpClosure:
+		return describeConstant(b.Constants[operands[0]])
+	case code.OpGetGlobal, code.OpSetGlobal, code.OpCheckGlobal, code.OpCheckFunction:
+		return b.Globals[operands[0]]
+	case code.OpCall:
+		return b.Constants[operands[1]].Inspect()
+	case code.OpSetIndex:
+		if operands[0] != 0 {
+			def, _ := code.Lookup(byte(operands[0]))
+			return def.Name
+		}
+	case code.OpLoopSignal:
+		if operands[0] == 1 {
+			return "continue"
+		}
+		return "break"
+	}
+
+	return ""
+}
+
+func describeConstant(constant object.Object) string {
+	switch constant := constant.(type) {
+	case *object.String:
+		return fmt.Sprintf("%q", constant.Value)
+	case *object.CompiledFunction:
+		if constant.Name == "" {
+			return "<anonymous>"
+		}
+		return constant.Name
+	}
+
+	return constant.Inspect()
+}
@@ -0,0 +1,45 @@
+package compiler
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDisassemble(t *testing.T) {
+	bytecode := compile(t, "let add = fn(a, b) { return a + b; };\nadd(1, \"x\")")
+
+	var out strings.Builder
+	if err := bytecode.Disassemble(&out); err != nil {
+		t.Fatalf("unexpected error: %s\n", err)
+	}
+
+	expected := `== <main> ==
+parameters: 0, locals: 0
+0000 1:11    OpClosure 0 0            ; <anonymous>
+0004 1:1     OpSetGlobal 0            ; add
+0007 1:1     OpTrue
+0008 1:1     OpPop
+0009 2:1     OpGetGlobal 0            ; add
+0012 2:5     OpConstant 1             ; 1
+0015 2:8     OpConstant 2             ; "x"
+0018 2:1     OpCall 2 3 1             ; add
+0024 1:1     OpReturnValue
+
+== <anonymous> ==
+parameters: 2, locals: 2
+0000 1:29    OpGetLocal 0
+0003 1:33    OpGetLocal 1
+0006 1:29    OpAdd
+0007 1:22    OpReturnValue
+0008 1:11    OpReturnValue
+
+== constants ==
+0000 COMPILED_FUNCTION <anonymous>
+0001 INT               1
+0002 STRING            "x"
+0003 STRING            "add"
+`
+	if out.String() != expected {
+		t.Errorf("expected disassembly\n%s\ninstead got\n%s\n", expected, out.String())
+	}
+}
@@ -0,0 +1,403 @@
+package compiler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"maz-lang/code"
+	"maz-lang/object"
+	"maz-lang/token"
+	"slices"
+)
+
+// A compiled program is stored as:
+//
+//	magic     "MAZC"
+//	version   uint16, big endian
+//	globals   names of the global variables
+//	files     names of the source files referenced by the debug info
+//	functions the top level statements followed by every function literal
+//	constants the constant pool, functions refer to the function table
+//	checksum  CRC32 (IEEE) of everything before it, big endian
+//
+// Counts, lengths and indexes are unsigned varints, integers are signed
+// varints and strings are a length followed by their bytes.
+const magic = "MAZC"
+
+// FormatVersion is the version of the compiled format, it must be bumped
+// whenever the layout or the instruction set changes.
+const FormatVersion = 1
+
+const (
+	integerConstant byte = iota + 1
+	floatConstant
+	stringConstant
+	functionConstant
+)
+
+var (
+	ErrNotBytecode = errors.New("not a compiled maz program")
+	ErrCorrupted   = errors.New("corrupted compiled program")
+)
+
+// VersionError is returned when decoding a program compiled for a
+// different version of the format.
+type VersionError struct {
+	Version int
+}
+
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("compiled program has version %d but this build of maz only runs version %d, rebuild it from source", e.Version, FormatVersion)
+}
+
+// Encode writes the compiled program to w.
+func (b *Bytecode) Encode(w io.Writer) error {
+	var enc encoder
+	fns := []*object.CompiledFunction{b.Main}
+	for _, constant := range b.Constants {
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			fns = append(fns, fn)
+		}
+	}
+
+	var files []string
+	for _, fn := range fns {
+		for _, loc := range fn.Debug.Locations {
+			if !slices.Contains(files, loc.Loc.Start.Filename) {
+				files = append(files, loc.Loc.Start.Filename)
+			}
+		}
+	}
+
+	enc.buf = append(enc.buf, magic...)
+	enc.buf = binary.BigEndian.AppendUint16(enc.buf, FormatVersion)
+
+	enc.uint(len(b.Globals))
+	for _, name := range b.Globals {
+		enc.string(name)
+	}
+
+	enc.uint(len(files))
+	for _, file := range files {
+		enc.string(file)
+	}
+
+	enc.uint(len(fns))
+	for _, fn := range fns {
+		enc.string(fn.Name)
+		enc.uint(fn.NumParameters)
+		enc.uint(fn.NumLocals)
+		enc.uint(len(fn.Instructions))
+		enc.buf = append(enc.buf, fn.Instructions...)
+
+		enc.uint(len(fn.Debug.Locations))
+		for _, loc := range fn.Debug.Locations {
+			enc.uint(loc.Offset)
+			enc.uint(slices.Index(files, loc.Loc.Start.Filename))
+			enc.position(loc.Loc.Start)
+			enc.position(loc.Loc.End)
+		}
+	}
+
+	enc.uint(len(b.Constants))
+	for _, constant := range b.Constants {
+		switch constant := constant.(type) {
+		case *object.Integer:
+			enc.buf = append(enc.buf, integerConstant)
+			enc.buf = binary.AppendVarint(enc.buf, constant.Value)
+		case *object.Float:
+			enc.buf = append(enc.buf, floatConstant)
+			enc.buf = binary.BigEndian.AppendUint64(enc.buf, math.Float64bits(constant.Value))
+		case *object.String:
+			enc.buf = append(enc.buf, stringConstant)
+			enc.string(constant.Value)
+		case *object.CompiledFunction:
+			enc.buf = append(enc.buf, functionConstant)
+			enc.uint(slices.Index(fns, constant))
+		default:
+			return fmt.Errorf("cannot encode constant of type %s", constant.Type())
+		}
+	}
+
+	enc.buf = binary.BigEndian.AppendUint32(enc.buf, crc32.ChecksumIEEE(enc.buf))
+	_, err := w.Write(enc.buf)
+	return err
+}
+
+// Decode reads a program written by Encode, it fails if the data is not a
+// compiled program, was compiled for another version or is corrupted.
+func Decode(r io.Reader) (*Bytecode, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.HasPrefix(data, []byte(magic)) {
+		return nil, ErrNotBytecode
+	}
+	if len(data) < len(magic)+2+4 {
+		return nil, ErrCorrupted
+	}
+
+	// The version is checked first, older files may not even have a checksum
+	if version := binary.BigEndian.Uint16(data[len(magic):]); version != FormatVersion {
+		return nil, &VersionError{Version: int(version)}
+	}
+
+	body, checksum := data[:len(data)-4], binary.BigEndian.Uint32(data[len(data)-4:])
+	if crc32.ChecksumIEEE(body) != checksum {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrCorrupted)
+	}
+
+	dec := decoder{buf: body[len(magic)+2:]}
+	b := &Bytecode{}
+
+	b.Globals = make([]string, dec.count())
+	for i := range b.Globals {
+		b.Globals[i] = dec.string()
+	}
+
+	files := make([]string, dec.count())
+	for i := range files {
+		files[i] = dec.string()
+	}
+
+	fns := make([]*object.CompiledFunction, dec.count())
+	for i := range fns {
+		fn := &object.CompiledFunction{
+			Name:          dec.string(),
+			NumParameters: dec.uint(),
+			NumLocals:     dec.uint(),
+		}
+		fn.Instructions = code.Instructions(slices.Clone(dec.bytes(dec.count())))
+
+		fn.Debug.Locations = make([]code.Location, dec.count())
+		for j := range fn.Debug.Locations {
+			loc := &fn.Debug.Locations[j]
+			loc.Offset = dec.uint()
+			if file := dec.uint(); file < len(files) {
+				loc.Loc.Start.Filename = files[file]
+			} else {
+				dec.err = ErrCorrupted
+			}
+			loc.Loc.End.Filename = loc.Loc.Start.Filename
+			dec.position(&loc.Loc.Start)
+			dec.position(&loc.Loc.End)
+		}
+		fns[i] = fn
+	}
+
+	b.Constants = make([]object.Object, dec.count())
+	for i := range b.Constants {
+		switch tag := dec.bytes(1); {
+		case len(tag) == 0:
+		case tag[0] == integerConstant:
+			b.Constants[i] = &object.Integer{Value: dec.int()}
+		case tag[0] == floatConstant:
+			if bits := dec.bytes(8); len(bits) == 8 {
+				b.Constants[i] = &object.Float{Value: math.Float64frombits(binary.BigEndian.Uint64(bits))}
+			}
+		case tag[0] == stringConstant:
+			b.Constants[i] = &object.String{Value: dec.string()}
+		case tag[0] == functionConstant:
+			if fn := dec.uint(); fn > 0 && fn < len(fns) {
+				b.Constants[i] = fns[fn]
+			} else {
+				dec.err = ErrCorrupted
+			}
+		default:
+			dec.err = ErrCorrupted
+		}
+	}
+
+	if dec.err != nil || len(dec.buf) != 0 || len(fns) == 0 || !validate(b, fns) {
+		return nil, ErrCorrupted
+	}
+	b.Main = fns[0]
+
+	return b, nil
+}
+
+// validate checks that the instructions of the functions can be run by the
+// virtual machine: every opcode is defined, the operands index existing
+// constants of the expected type, globals, locals and captured variables,
+// and jumps land at the start of an instruction.
+func validate(b *Bytecode, fns []*object.CompiledFunction) bool {
+	type instruction struct {
+		op       code.Opcode
+		operands []int
+	}
+
+	// The instructions of each function, by offset
+	program := make([]map[int]instruction, len(fns))
+	for i, fn := range fns {
+		if fn.NumParameters > fn.NumLocals {
+			return false
+		}
+
+		program[i] = map[int]instruction{}
+		ip, last := 0, code.Opcode(0)
+		for ip < len(fn.Instructions) {
+			def, err := code.Lookup(fn.Instructions[ip])
+			if err != nil {
+				return false
+			}
+			width := 0
+			for _, w := range def.OperandWidths {
+				width += w
+			}
+			if ip+1+width > len(fn.Instructions) {
+				return false
+			}
+
+			operands, read := code.ReadOperands(def, fn.Instructions[ip+1:])
+			last = code.Opcode(fn.Instructions[ip])
+			program[i][ip] = instruction{op: last, operands: operands}
+			ip += 1 + read
+		}
+		if last != code.OpReturnValue {
+			return false
+		}
+	}
+
+	constant := func(index int, expected object.ObjectType) bool {
+		return index < len(b.Constants) && b.Constants[index].Type() == expected
+	}
+
+	// A function is always closed over with the same number of captured
+	// variables, the main function and those never closed over have none
+	free := make(map[*object.CompiledFunction]int)
+	for _, instructions := range program {
+		for _, ins := range instructions {
+			if ins.op != code.OpClosure {
+				continue
+			}
+			if !constant(ins.operands[0], object.COMPILED_FUNCTION_OBJ) {
+				return false
+			}
+			fn := b.Constants[ins.operands[0]].(*object.CompiledFunction)
+			if n, ok := free[fn]; ok && n != ins.operands[1] {
+				return false
+			}
+			free[fn] = ins.operands[1]
+		}
+	}
+	if free[fns[0]] != 0 {
+		return false
+	}
+
+	for i, fn := range fns {
+		for _, ins := range program[i] {
+			var ok bool
+			switch ins.op {
+			case code.OpConstant:
+				ok = ins.operands[0] < len(b.Constants) && b.Constants[ins.operands[0]].Type() != object.COMPILED_FUNCTION_OBJ
+			case code.OpMember, code.OpError:
+				ok = constant(ins.operands[0], object.STRING_OBJ)
+			case code.OpCall:
+				ok = constant(ins.operands[1], object.STRING_OBJ) && ins.operands[2] <= len(b.Globals)
+			case code.OpGetGlobal, code.OpSetGlobal, code.OpCheckGlobal, code.OpCheckFunction:
+				ok = ins.operands[0] < len(b.Globals)
+			case code.OpGetLocal, code.OpSetLocal, code.OpNewCell, code.OpGetCell, code.OpSetCell, code.OpLoadCell:
+				ok = ins.operands[0] < fn.NumLocals
+			case code.OpGetFree, code.OpSetFree, code.OpLoadFree:
+				ok = ins.operands[0] < free[fn]
+			case code.OpJump, code.OpJumpIfFalse, code.OpJumpIfFalseOrPop, code.OpJumpIfTrueOrPop,
+				code.OpBreak, code.OpContinue, code.OpIterNext, code.OpMark:
+				_, ok = program[i][ins.operands[0]]
+			case code.OpSetIndex:
+				ok = ins.operands[0] == 0 || code.Opcode(ins.operands[0]) >= code.OpAdd && code.Opcode(ins.operands[0]) <= code.OpLessEqual
+			case code.OpLoopSignal:
+				ok = ins.operands[0] <= 1
+			default:
+				ok = true
+			}
+			if !ok {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) uint(n int) {
+	e.buf = binary.AppendUvarint(e.buf, uint64(n))
+}
+
+func (e *encoder) string(s string) {
+	e.uint(len(s))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *encoder) position(pos token.Position) {
+	e.uint(pos.Offset)
+	e.uint(pos.Line)
+	e.uint(pos.Column)
+}
+
+// decoder reads the values written by encoder, after the first error
+// every read returns a zero value and err is left set.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) bytes(n int) []byte {
+	if d.err != nil || n > len(d.buf) {
+		d.err = ErrCorrupted
+		return nil
+	}
+	res := d.buf[:n]
+	d.buf = d.buf[n:]
+	return res
+}
+
+func (d *decoder) uint() int {
+	n, read := binary.Uvarint(d.buf)
+	if d.err != nil || read <= 0 || n > math.MaxInt32 {
+		d.err = ErrCorrupted
+		return 0
+	}
+	d.buf = d.buf[read:]
+	return int(n)
+}
+
+// count reads the number of elements or bytes that follow, which cannot be
+// more than the bytes left.
+func (d *decoder) count() int {
+	n := d.uint()
+	if n > len(d.buf) {
+		d.err = ErrCorrupted
+		return 0
+	}
+	return n
+}
+
+func (d *decoder) int() int64 {
+	n, read := binary.Varint(d.buf)
+	if d.err != nil || read <= 0 {
+		d.err = ErrCorrupted
+		return 0
+	}
+	d.buf = d.buf[read:]
+	return n
+}
+
+func (d *decoder) string() string {
+	return string(d.bytes(d.count()))
+}
+
+func (d *decoder) position(pos *token.Position) {
+	pos.Offset = d.uint()
+	pos.Line = d.uint()
+	pos.Column = d.uint()
+}
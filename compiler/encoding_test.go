@@ -0,0 +1,179 @@
+package compiler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"maz-lang/code"
+	"maz-lang/lexer"
+	"maz-lang/parser"
+	"maz-lang/token"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	src := `let pi = 3.14;
+fn area(r) { return pi * r ** 2; }
+let counter = fn() { let n = 0; return fn() { n += 1; return n; }; };
+[area(2), counter()(), "done", -7]`
+	l := lexer.NewWithFilename("circle.mz", src)
+	program := parser.New(&l).Parse(token.EOF)
+	c := New()
+	if err := c.Compile(&program); err != nil {
+		t.Fatalf("unexpected compiler error: %s\n", err)
+	}
+	bytecode := c.Bytecode()
+
+	var buf bytes.Buffer
+	if err := bytecode.Encode(&buf); err != nil {
+		t.Fatalf("unexpected error: %s\n", err)
+	}
+	decoded, err := Decode(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %s\n", err)
+	}
+
+	var expected, got strings.Builder
+	bytecode.Disassemble(&expected)
+	decoded.Disassemble(&got)
+	if expected.String() != got.String() {
+		t.Errorf("expected the decoded program to be\n%s\ninstead got\n%s\n", expected.String(), got.String())
+	}
+	if !cmp.Equal(decoded.Main.Debug, bytecode.Main.Debug) {
+		t.Errorf("expected debug info to survive, diff:\n%s\n", cmp.Diff(bytecode.Main.Debug, decoded.Main.Debug))
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	var buf bytes.Buffer
+	if err := compile(t, "let a = 1; a + 2").Encode(&buf); err != nil {
+		t.Fatalf("unexpected error: %s\n", err)
+	}
+	valid := buf.Bytes()
+
+	oldVersion := bytes.Clone(valid)
+	binary.BigEndian.PutUint16(oldVersion[len(magic):], FormatVersion+1)
+
+	flipped := bytes.Clone(valid)
+	flipped[len(flipped)/2] ^= 0xff
+
+	// Programs with a valid checksum whose main function cannot be run,
+	// the constants are 1 and 2 and there is a single global
+	tampered := func(instructions ...[]byte) []byte {
+		bytecode := compile(t, "let a = 1; a + 2")
+		bytecode.Main.Instructions = slices.Concat(instructions...)
+		var buf bytes.Buffer
+		if err := bytecode.Encode(&buf); err != nil {
+			t.Fatalf("unexpected error: %s\n", err)
+		}
+		return buf.Bytes()
+	}
+	ret := code.Make(code.OpReturnValue)
+
+	tests := []struct {
+		Name     string
+		Data     []byte
+		Expected string
+	}{
+		{
+			Name:     "source file",
+			Data:     []byte("let a = 1;"),
+			Expected: "not a compiled maz program",
+		},
+		{
+			Name:     "other version",
+			Data:     oldVersion,
+			Expected: "compiled program has version 2 but this build of maz only runs version 1, rebuild it from source",
+		},
+		{
+			Name:     "flipped byte",
+			Data:     flipped,
+			Expected: "corrupted compiled program: checksum mismatch",
+		},
+		{
+			Name:     "undefined opcode",
+			Data:     tampered([]byte{255}, ret),
+			Expected: "corrupted compiled program",
+		},
+		{
+			Name:     "truncated operand",
+			Data:     tampered(ret, code.Make(code.OpConstant, 0)[:2]),
+			Expected: "corrupted compiled program",
+		},
+		{
+			Name:     "missing return",
+			Data:     tampered(code.Make(code.OpNull)),
+			Expected: "corrupted compiled program",
+		},
+		{
+			Name:     "constant out of range",
+			Data:     tampered(code.Make(code.OpConstant, 2), ret),
+			Expected: "corrupted compiled program",
+		},
+		{
+			Name:     "member of an integer constant",
+			Data:     tampered(code.Make(code.OpNull), code.Make(code.OpMember, 0), ret),
+			Expected: "corrupted compiled program",
+		},
+		{
+			Name:     "closure of an integer constant",
+			Data:     tampered(code.Make(code.OpClosure, 0, 0), ret),
+			Expected: "corrupted compiled program",
+		},
+		{
+			Name:     "global out of range",
+			Data:     tampered(code.Make(code.OpNull), code.Make(code.OpSetGlobal, 1), ret),
+			Expected: "corrupted compiled program",
+		},
+		{
+			Name:     "local out of range",
+			Data:     tampered(code.Make(code.OpGetLocal, 0), ret),
+			Expected: "corrupted compiled program",
+		},
+		{
+			Name:     "captured variable out of range",
+			Data:     tampered(code.Make(code.OpGetFree, 0), ret),
+			Expected: "corrupted compiled program",
+		},
+		{
+			Name:     "jump inside an instruction",
+			Data:     tampered(code.Make(code.OpJump, 1), code.Make(code.OpNull), ret),
+			Expected: "corrupted compiled program",
+		},
+		{
+			Name:     "jump past the end",
+			Data:     tampered(code.Make(code.OpJump, 5), code.Make(code.OpNull), ret),
+			Expected: "corrupted compiled program",
+		},
+		{
+			Name:     "truncated",
+			Data:     valid[:len(magic)+3],
+			Expected: "corrupted compiled program",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("decoding: '%s'\n", tt.Name)
+		_, err := Decode(bytes.NewReader(tt.Data))
+		if err == nil {
+			t.Errorf("expected an error, instead got nil\n")
+			continue
+		}
+		if err.Error() != tt.Expected {
+			t.Errorf("expected error '%s', instead got '%s'\n", tt.Expected, err)
+		}
+	}
+
+	if _, err := Decode(bytes.NewReader(tampered(code.Make(code.OpConstant, 1), ret))); err != nil {
+		t.Errorf("expected the untampered instructions to decode, instead got '%s'\n", err)
+	}
+
+	var versionErr *VersionError
+	if _, err := Decode(bytes.NewReader(oldVersion)); !errors.As(err, &versionErr) {
+		t.Errorf("expected a *VersionError, instead got %T\n", err)
+	}
+}
@@ -0,0 +1,159 @@
+package compiler
+
+type SymbolScope int
+
+const (
+	// GlobalScope holds the variables declared at the top level of the program.
+	GlobalScope SymbolScope = iota
+	// LocalScope holds the parameters of a function and the variables
+	// declared in its blocks.
+	LocalScope
+	// FreeScope holds the variables of the enclosing functions captured by a closure.
+	FreeScope
+)
+
+type Symbol struct {
+	Name  string
+	Scope SymbolScope
+	Index int
+	// Cell is set for local variables used by nested functions, they are
+	// stored in a cell shared with the closures capturing them.
+	Cell bool
+}
+
+// globalTable assigns a slot to every global variable, by name.
+type globalTable struct {
+	store map[string]int
+	names []string
+}
+
+func (g *globalTable) define(name string) Symbol {
+	index, ok := g.store[name]
+	if !ok {
+		index = len(g.names)
+		g.store[name] = index
+		g.names = append(g.names, name)
+	}
+
+	return Symbol{Name: name, Scope: GlobalScope, Index: index}
+}
+
+// SymbolTable resolves the variables of a function, Outer is the table of
+// the enclosing function. The table of the program itself has no Outer and
+// the variables declared outside of its blocks are globals.
+type SymbolTable struct {
+	Outer *SymbolTable
+	// FreeSymbols are the symbols captured from the enclosing function,
+	// as resolved there.
+	FreeSymbols []Symbol
+
+	globals   *globalTable
+	blocks    []map[string]Symbol
+	free      map[string]int
+	captured  map[string]bool
+	numLocals int
+}
+
+// NewSymbolTable returns the table of a program.
+func NewSymbolTable() *SymbolTable {
+	return &SymbolTable{
+		globals: &globalTable{store: map[string]int{}},
+		blocks:  []map[string]Symbol{{}},
+		free:    map[string]int{},
+	}
+}
+
+// NewEnclosedSymbolTable returns the table of a function nested in outer,
+// captured are the names used by the functions nested in it.
+func NewEnclosedSymbolTable(outer *SymbolTable, captured map[string]bool) *SymbolTable {
+	return &SymbolTable{
+		Outer:    outer,
+		globals:  outer.globals,
+		blocks:   []map[string]Symbol{{}},
+		free:     map[string]int{},
+		captured: captured,
+	}
+}
+
+// EnterBlock opens a new scope, LeaveBlock closes it.
+func (s *SymbolTable) EnterBlock() {
+	s.blocks = append(s.blocks, map[string]Symbol{})
+}
+
+func (s *SymbolTable) LeaveBlock() {
+	s.blocks = s.blocks[:len(s.blocks)-1]
+}
+
+// NumLocals returns the number of slots needed by the local variables.
+func (s *SymbolTable) NumLocals() int { return s.numLocals }
+
+// Globals returns the names of the global variables, by slot.
+func (s *SymbolTable) Globals() []string { return s.globals.names }
+
+func (s *SymbolTable) isGlobalScope() bool {
+	return s.Outer == nil && len(s.blocks) == 1
+}
+
+// Define declares name in the innermost scope, declaring it again in the
+// same scope gives back the same symbol. isNew reports whether the symbol
+// was just created.
+func (s *SymbolTable) Define(name string) (sym Symbol, isNew bool) {
+	if s.isGlobalScope() {
+		return s.globals.define(name), false
+	}
+
+	block := s.blocks[len(s.blocks)-1]
+	if sym, ok := block[name]; ok {
+		return sym, false
+	}
+
+	return s.defineLocal(name), true
+}
+
+// DefineParameter declares a parameter of the function, each one gets its
+// own slot even if names are repeated.
+func (s *SymbolTable) DefineParameter(name string) Symbol {
+	return s.defineLocal(name)
+}
+
+func (s *SymbolTable) defineLocal(name string) Symbol {
+	sym := Symbol{Name: name, Scope: LocalScope, Index: s.numLocals, Cell: s.captured[name]}
+	s.blocks[len(s.blocks)-1][name] = sym
+	s.numLocals++
+
+	return sym
+}
+
+// Resolve returns the symbol name refers to, looking in the scopes from
+// the innermost outwards. Names declared nowhere are globals, which may be
+// set later on or be builtins.
+func (s *SymbolTable) Resolve(name string) Symbol {
+	first := 0
+	if s.Outer == nil {
+		first = 1
+	}
+	for i := len(s.blocks) - 1; i >= first; i-- {
+		if sym, ok := s.blocks[i][name]; ok {
+			return sym
+		}
+	}
+
+	if s.Outer == nil {
+		return s.globals.define(name)
+	}
+
+	if index, ok := s.free[name]; ok {
+		return Symbol{Name: name, Scope: FreeScope, Index: index}
+	}
+
+	sym := s.Outer.Resolve(name)
+	if sym.Scope == GlobalScope {
+		return sym
+	}
+
+	index := len(s.FreeSymbols)
+	s.free[name] = index
+	s.FreeSymbols = append(s.FreeSymbols, sym)
+
+	return Symbol{Name: name, Scope: FreeScope, Index: index}
+}
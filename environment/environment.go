@@ -2,24 +2,58 @@ package environment
 
 import "maz-lang/object"
 
+// Environment holds the variables of a scope in the slots assigned to them
+// by the resolver, so that they are accessed by index instead of by name.
+// The outermost environment also keeps the names of the global variables.
 type Environment struct {
-	values map[string]object.Object
+	values []object.Object
+	names  map[string]int
 	child  *Environment
 }
 
 func New() Environment {
 	return Environment{
-		values: make(map[string]object.Object),
+		names: make(map[string]int),
 	}
 }
 
+// NewEnclosed returns an environment for a scope nested in outer, with
+// room for size variables.
+func NewEnclosed(outer *Environment, size int) *Environment {
+	return &Environment{
+		values: make([]object.Object, size),
+		child:  outer,
+	}
+}
+
+// Declare returns the slot of the variable name, adding it if needed.
+func (e *Environment) Declare(name string) int {
+	if slot, ok := e.names[name]; ok {
+		return slot
+	}
+
+	if e.names == nil {
+		e.names = make(map[string]int)
+	}
+	slot := len(e.names)
+	e.names[name] = slot
+
+	return slot
+}
+
+// Slot returns the slot of the variable name, ok is false if it was never declared.
+func (e *Environment) Slot(name string) (slot int, ok bool) {
+	slot, ok = e.names[name]
+	return slot, ok
+}
+
 func (e *Environment) Set(name string, value object.Object) {
-	e.values[name] = value
+	e.Define(e.Declare(name), value)
 }
 
 func (e *Environment) Get(name string) object.Object {
-	if res, ok := e.values[name]; ok {
-		return res
+	if slot, ok := e.names[name]; ok && e.GetAt(0, slot) != nil {
+		return e.values[slot]
 	}
 
 	if e.child != nil {
@@ -29,6 +63,54 @@ func (e *Environment) Get(name string) object.Object {
 	return nil
 }
 
+// Assign updates the closest existing binding of name, walking up the enclosing scopes.
+// It reports false if name was never declared.
+func (e *Environment) Assign(name string, value object.Object) bool {
+	if slot, ok := e.names[name]; ok && e.GetAt(0, slot) != nil {
+		e.values[slot] = value
+		return true
+	}
+
+	if e.child != nil {
+		return e.child.Assign(name, value)
+	}
+
+	return false
+}
+
+// Define stores value in the given slot of e.
+func (e *Environment) Define(slot int, value object.Object) {
+	if slot >= len(e.values) {
+		e.values = append(e.values, make([]object.Object, slot+1-len(e.values))...)
+	}
+	e.values[slot] = value
+}
+
+// GetAt returns the value in the given slot of the environment depth
+// scopes up from e, or nil if the variable has no value yet.
+func (e *Environment) GetAt(depth, slot int) object.Object {
+	env := e.outer(depth)
+	if slot >= len(env.values) {
+		return nil
+	}
+
+	return env.values[slot]
+}
+
+// SetAt stores value in the given slot of the environment depth scopes up from e.
+func (e *Environment) SetAt(depth, slot int, value object.Object) {
+	e.outer(depth).Define(slot, value)
+}
+
+func (e *Environment) outer(depth int) *Environment {
+	env := e
+	for ; depth > 0; depth-- {
+		env = env.child
+	}
+
+	return env
+}
+
 func (e *Environment) Extend(env *Environment) {
 	e.child = env
 }
@@ -45,3 +45,80 @@ func TestEnvironment(t *testing.T) {
 		t.Errorf("expected 'num' to be %+v, instead got %+v\n", nil, none)
 	}
 }
+
+func TestEnvironmentSlots(t *testing.T) {
+	global := New()
+	global.Set("num", &object.Integer{Value: 1})
+
+	fn := NewEnclosed(&global, 2)
+	fn.Define(0, &object.Integer{Value: 2})
+	block := NewEnclosed(fn, 0)
+	block.Define(1, &object.Integer{Value: 3})
+
+	block.SetAt(2, 0, &object.Integer{Value: 4})
+	if num := global.Get("num"); !cmp.Equal(num, &object.Integer{Value: 4}) {
+		t.Errorf("expected 'num' to be %+v, instead got %+v\n", &object.Integer{Value: 4}, num)
+	}
+
+	tests := []struct {
+		Depth    int
+		Slot     int
+		Expected object.Object
+	}{
+		{
+			Depth:    0,
+			Slot:     1,
+			Expected: &object.Integer{Value: 3},
+		},
+		{
+			Depth:    0,
+			Slot:     0,
+			Expected: nil,
+		},
+		{
+			Depth:    1,
+			Slot:     0,
+			Expected: &object.Integer{Value: 2},
+		},
+		{
+			Depth:    1,
+			Slot:     1,
+			Expected: nil,
+		},
+		{
+			Depth:    2,
+			Slot:     0,
+			Expected: &object.Integer{Value: 4},
+		},
+	}
+
+	for _, tt := range tests {
+		if obj := block.GetAt(tt.Depth, tt.Slot); !cmp.Equal(obj, tt.Expected) {
+			t.Errorf("expected (%d, %d) to be %+v, instead got %+v\n", tt.Depth, tt.Slot, tt.Expected, obj)
+		}
+	}
+}
+
+func TestEnvironmentAssign(t *testing.T) {
+	outer := New()
+	outer.Set("num", &object.Integer{Value: 1})
+
+	inner := New()
+	inner.Extend(&outer)
+
+	if !inner.Assign("num", &object.Integer{Value: 2}) {
+		t.Fatalf("expected 'num' to be assigned\n")
+	}
+
+	if num := outer.Get("num"); !cmp.Equal(num, &object.Integer{Value: 2}) {
+		t.Errorf("expected 'num' to be %+v, instead got %+v\n", &object.Integer{Value: 2}, num)
+	}
+
+	if inner.Assign("doesnotexists", &object.Integer{Value: 3}) {
+		t.Errorf("expected assignment of an undeclared name to fail\n")
+	}
+
+	if none := outer.Get("doesnotexists"); none != nil {
+		t.Errorf("expected 'doesnotexists' to be %+v, instead got %+v\n", nil, none)
+	}
+}
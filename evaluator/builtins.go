@@ -0,0 +1,488 @@
+package evaluator
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"maz-lang/object"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+var builtins = map[string]*object.Builtin{
+	"len":   {Name: "len", Fn: builtinLen},
+	"first": {Name: "first", Fn: builtinFirst},
+	"last":  {Name: "last", Fn: builtinLast},
+	"range": {Name: "range", Fn: builtinRange},
+
+	"has":    {Name: "has", Fn: builtinHas},
+	"delete": {Name: "delete", Fn: builtinDelete},
+
+	"int":   {Name: "int", Fn: builtinInt},
+	"float": {Name: "float", Fn: builtinFloat},
+	"type":  {Name: "type", Fn: builtinType},
+
+	"exit": {Name: "exit", Fn: builtinExit},
+}
+
+func newError(format string, a ...any) *object.Error {
+	return &object.Error{Value: fmt.Errorf(format, a...)}
+}
+
+// checkArgs returns an error if the number of arguments is not between min and max.
+func checkArgs(name string, args []object.Object, min, max int) *object.Error {
+	if len(args) < min || len(args) > max {
+		if min == max {
+			return newError("%s() takes %d arguments, instead got %d", name, min, len(args))
+		}
+		return newError("%s() takes from %d to %d arguments, instead got %d", name, min, max, len(args))
+	}
+
+	return nil
+}
+
+// arrayArg returns the argument at index i if it is an array.
+func arrayArg(name string, args []object.Object, i int) (*object.Array, *object.Error) {
+	arr, ok := args[i].(*object.Array)
+	if !ok {
+		return nil, newError("argument %d of %s() must be ARRAY, instead got %s", i+1, name, args[i].Type())
+	}
+
+	return arr, nil
+}
+
+// hashArg returns the argument at index i if it is a hash.
+func hashArg(name string, args []object.Object, i int) (*object.Hash, *object.Error) {
+	hash, ok := args[i].(*object.Hash)
+	if !ok {
+		return nil, newError("argument %d of %s() must be HASH, instead got %s", i+1, name, args[i].Type())
+	}
+
+	return hash, nil
+}
+
+// keyArg returns the argument at index i if it can be used as key of a hash.
+func keyArg(name string, args []object.Object, i int) (object.Hashable, *object.Error) {
+	key, ok := args[i].(object.Hashable)
+	if !ok {
+		return nil, newError("unusable as hash key: %s", args[i].Type())
+	}
+
+	return key, nil
+}
+
+// integerArg returns the argument at index i if it is an integer.
+func integerArg(name string, args []object.Object, i int) (int64, *object.Error) {
+	num, ok := args[i].(*object.Integer)
+	if !ok {
+		return 0, newError("argument %d of %s() must be INT, instead got %s", i+1, name, args[i].Type())
+	}
+
+	return num.Value, nil
+}
+
+// len(value) returns the number of elements of an array or a hash, or the number of characters of a string.
+func builtinLen(args ...object.Object) object.Object {
+	if err := checkArgs("len", args, 1, 1); err != nil {
+		return err
+	}
+
+	switch arg := args[0].(type) {
+	case *object.Array:
+		return &object.Integer{Value: int64(len(arg.Elements))}
+	case *object.Hash:
+		return &object.Integer{Value: int64(len(arg.Pairs))}
+	case *object.String:
+		return &object.Integer{Value: int64(utf8.RuneCountInString(arg.Value))}
+	}
+
+	return newError("len() is not supported for %s", args[0].Type())
+}
+
+// push(array, values...) appends the values to the array and returns it.
+func (e *Evaluator) builtinPush(args ...object.Object) object.Object {
+	if len(args) < 2 {
+		return newError("push() takes at least 2 arguments, instead got %d", len(args))
+	}
+
+	arr, err := arrayArg("push", args, 0)
+	if err != nil {
+		return err
+	}
+	if err := e.Allocate(int64(len(args) - 1)); err != nil {
+		return err
+	}
+	arr.Elements = append(arr.Elements, args[1:]...)
+
+	return arr
+}
+
+// first(array) returns the first element of the array, or null if it is empty.
+func builtinFirst(args ...object.Object) object.Object {
+	if err := checkArgs("first", args, 1, 1); err != nil {
+		return err
+	}
+
+	arr, err := arrayArg("first", args, 0)
+	if err != nil {
+		return err
+	}
+	if len(arr.Elements) == 0 {
+		return &NULL
+	}
+
+	return arr.Elements[0]
+}
+
+// last(array) returns the last element of the array, or null if it is empty.
+func builtinLast(args ...object.Object) object.Object {
+	if err := checkArgs("last", args, 1, 1); err != nil {
+		return err
+	}
+
+	arr, err := arrayArg("last", args, 0)
+	if err != nil {
+		return err
+	}
+	if len(arr.Elements) == 0 {
+		return &NULL
+	}
+
+	return arr.Elements[len(arr.Elements)-1]
+}
+
+// rest(array) returns a new array with every element but the first one.
+func (e *Evaluator) builtinRest(args ...object.Object) object.Object {
+	if err := checkArgs("rest", args, 1, 1); err != nil {
+		return err
+	}
+
+	arr, err := arrayArg("rest", args, 0)
+	if err != nil {
+		return err
+	}
+	if len(arr.Elements) == 0 {
+		return &object.Array{Elements: []object.Object{}}
+	}
+
+	if err := e.Allocate(int64(len(arr.Elements) - 1)); err != nil {
+		return err
+	}
+	elements := make([]object.Object, len(arr.Elements)-1)
+	copy(elements, arr.Elements[1:])
+
+	return &object.Array{Elements: elements}
+}
+
+// slice(array, start, end) returns a new array with the elements going from start
+// up to end (excluded). If end is omitted the slice goes up to the end of the array.
+func (e *Evaluator) builtinSlice(args ...object.Object) object.Object {
+	if err := checkArgs("slice", args, 2, 3); err != nil {
+		return err
+	}
+
+	arr, err := arrayArg("slice", args, 0)
+	if err != nil {
+		return err
+	}
+
+	start, err := integerArg("slice", args, 1)
+	if err != nil {
+		return err
+	}
+
+	end := int64(len(arr.Elements))
+	if len(args) == 3 {
+		end, err = integerArg("slice", args, 2)
+		if err != nil {
+			return err
+		}
+	}
+
+	if start < 0 || end > int64(len(arr.Elements)) || start > end {
+		return newError("slice bounds out of range: [%d:%d] with length %d", start, end, len(arr.Elements))
+	}
+
+	if err := e.Allocate(end - start); err != nil {
+		return err
+	}
+	elements := make([]object.Object, end-start)
+	copy(elements, arr.Elements[start:end])
+
+	return &object.Array{Elements: elements}
+}
+
+// range(end), range(start, end) and range(start, end, step) return the integers
+// going from start (0 if omitted) up to end (excluded), to be iterated with 'for'.
+func builtinRange(args ...object.Object) object.Object {
+	if err := checkArgs("range", args, 1, 3); err != nil {
+		return err
+	}
+
+	values := make([]int64, len(args))
+	for i := range args {
+		value, err := integerArg("range", args, i)
+		if err != nil {
+			return err
+		}
+		values[i] = value
+	}
+
+	switch len(values) {
+	case 1:
+		return &object.Range{Start: 0, End: values[0], Step: 1}
+	case 2:
+		return &object.Range{Start: values[0], End: values[1], Step: 1}
+	}
+
+	if values[2] == 0 {
+		return newError("range() step must not be zero")
+	}
+	return &object.Range{Start: values[0], End: values[1], Step: values[2]}
+}
+
+// keys(hash) returns an array with the keys of the hash, in insertion order.
+func (e *Evaluator) builtinKeys(args ...object.Object) object.Object {
+	if err := checkArgs("keys", args, 1, 1); err != nil {
+		return err
+	}
+
+	hash, err := hashArg("keys", args, 0)
+	if err != nil {
+		return err
+	}
+	if err := e.Allocate(int64(len(hash.Order))); err != nil {
+		return err
+	}
+
+	elements := make([]object.Object, 0, len(hash.Order))
+	for _, key := range hash.Order {
+		elements = append(elements, hash.Pairs[key].Key)
+	}
+
+	return &object.Array{Elements: elements}
+}
+
+// values(hash) returns an array with the values of the hash, in insertion order.
+func (e *Evaluator) builtinValues(args ...object.Object) object.Object {
+	if err := checkArgs("values", args, 1, 1); err != nil {
+		return err
+	}
+
+	hash, err := hashArg("values", args, 0)
+	if err != nil {
+		return err
+	}
+	if err := e.Allocate(int64(len(hash.Order))); err != nil {
+		return err
+	}
+
+	elements := make([]object.Object, 0, len(hash.Order))
+	for _, key := range hash.Order {
+		elements = append(elements, hash.Pairs[key].Value)
+	}
+
+	return &object.Array{Elements: elements}
+}
+
+// has(hash, key) reports whether the hash contains key.
+func builtinHas(args ...object.Object) object.Object {
+	if err := checkArgs("has", args, 2, 2); err != nil {
+		return err
+	}
+
+	hash, err := hashArg("has", args, 0)
+	if err != nil {
+		return err
+	}
+
+	key, err := keyArg("has", args, 1)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := hash.Get(key); ok {
+		return &TRUE
+	}
+	return &FALSE
+}
+
+// delete(hash, key) removes key from the hash and reports whether it was present.
+func builtinDelete(args ...object.Object) object.Object {
+	if err := checkArgs("delete", args, 2, 2); err != nil {
+		return err
+	}
+
+	hash, err := hashArg("delete", args, 0)
+	if err != nil {
+		return err
+	}
+
+	key, err := keyArg("delete", args, 1)
+	if err != nil {
+		return err
+	}
+
+	if hash.Delete(key) {
+		return &TRUE
+	}
+	return &FALSE
+}
+
+// int(value) converts a float, truncating it toward zero, or a string to an integer.
+func builtinInt(args ...object.Object) object.Object {
+	if err := checkArgs("int", args, 1, 1); err != nil {
+		return err
+	}
+
+	switch arg := args[0].(type) {
+	case *object.Integer, *object.BigInteger:
+		return arg
+	case *object.Float:
+		if math.IsNaN(arg.Value) || arg.Value < math.MinInt64 || arg.Value >= math.MaxInt64 {
+			return newError("cannot convert %s to INT", arg.Inspect())
+		}
+		return &object.Integer{Value: int64(arg.Value)}
+	case *object.String:
+		num, err := strconv.ParseInt(strings.TrimSpace(arg.Value), 10, 64)
+		if err != nil {
+			return newError("cannot convert %q to INT", arg.Value)
+		}
+		return &object.Integer{Value: num}
+	}
+
+	return newError("int() is not supported for %s", args[0].Type())
+}
+
+// float(value) converts an integer or a string to a float.
+func builtinFloat(args ...object.Object) object.Object {
+	if err := checkArgs("float", args, 1, 1); err != nil {
+		return err
+	}
+
+	switch arg := args[0].(type) {
+	case *object.Float:
+		return arg
+	case *object.Integer, *object.BigInteger:
+		return &object.Float{Value: floatValue(arg)}
+	case *object.String:
+		num, err := strconv.ParseFloat(strings.TrimSpace(arg.Value), 64)
+		if err != nil {
+			return newError("cannot convert %q to FLOAT", arg.Value)
+		}
+		return &object.Float{Value: num}
+	}
+
+	return newError("float() is not supported for %s", args[0].Type())
+}
+
+// str(value) returns the textual representation of the value.
+func (e *Evaluator) builtinStr(args ...object.Object) object.Object {
+	if err := checkArgs("str", args, 1, 1); err != nil {
+		return err
+	}
+
+	if str, ok := args[0].(*object.String); ok {
+		return str
+	}
+
+	res := &object.String{Value: args[0].Inspect()}
+	if err := e.Allocate(bytesSize(len(res.Value))); err != nil {
+		return err
+	}
+
+	return res
+}
+
+// type(value) returns the name of the type of the value.
+func builtinType(args ...object.Object) object.Object {
+	if err := checkArgs("type", args, 1, 1); err != nil {
+		return err
+	}
+
+	return &object.String{Value: string(args[0].Type())}
+}
+
+// exit(code) stops the program, the exit code defaults to 0.
+func builtinExit(args ...object.Object) object.Object {
+	if err := checkArgs("exit", args, 0, 1); err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return &object.Exit{Code: 0}
+	}
+
+	code, err := integerArg("exit", args, 0)
+	if err != nil {
+		return err
+	}
+
+	return &object.Exit{Code: int(code)}
+}
+
+// print(values...) writes the values separated by a space.
+func (e *Evaluator) builtinPrint(args ...object.Object) object.Object {
+	return e.print("print", args, "")
+}
+
+// println(values...) is like print but terminates the output with a newline.
+func (e *Evaluator) builtinPrintln(args ...object.Object) object.Object {
+	return e.print("println", args, "\n")
+}
+
+func (e *Evaluator) print(name string, args []object.Object, end string) object.Object {
+	values := make([]string, len(args))
+	for i, arg := range args {
+		values[i] = arg.Inspect()
+	}
+
+	if _, err := io.WriteString(e.Stdout, strings.Join(values, " ")+end); err != nil {
+		return newError("%s(): %s", name, err)
+	}
+
+	return &NULL
+}
+
+// input(prompt) writes the optional prompt and reads a line, without the line
+// terminator. At the end of the input it returns null.
+func (e *Evaluator) builtinInput(args ...object.Object) object.Object {
+	if err := checkArgs("input", args, 0, 1); err != nil {
+		return err
+	}
+	if len(args) == 1 {
+		if res := e.print("input", args, ""); isError(res) {
+			return res
+		}
+	}
+
+	line, err := e.stdin().ReadString('\n')
+	if err == io.EOF && line == "" {
+		return &NULL
+	}
+	if err != nil && err != io.EOF {
+		return newError("input(): %s", err)
+	}
+	line = strings.TrimSuffix(line, "\n")
+	line = strings.TrimSuffix(line, "\r")
+	if err := e.Allocate(bytesSize(len(line))); err != nil {
+		return err
+	}
+
+	return &object.String{Value: line}
+}
+
+// stdin returns a buffered reader of Stdin, which is kept between calls
+// so that no input gets lost in the buffer.
+func (e *Evaluator) stdin() *bufio.Reader {
+	if r, ok := e.Stdin.(*bufio.Reader); ok {
+		return r
+	}
+	if e.reader == nil || e.readerSrc != e.Stdin {
+		e.reader = bufio.NewReader(e.Stdin)
+		e.readerSrc = e.Stdin
+	}
+
+	return e.reader
+}
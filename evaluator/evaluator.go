@@ -1,10 +1,20 @@
 package evaluator
 
 import (
-	"fmt"
+	"bufio"
+	"context"
+	"io"
+	"maps"
+	"math"
+	"math/big"
 	"maz-lang/ast"
 	"maz-lang/environment"
 	"maz-lang/object"
+	"maz-lang/resolver"
+	"maz-lang/token"
+	"os"
+	"slices"
+	"strings"
 )
 
 var (
@@ -13,14 +23,150 @@ var (
 	NULL  = object.Null{}
 )
 
+// OverflowPolicy selects what happens when the result of an integer
+// operation does not fit in 64 bits.
+type OverflowPolicy int
+
+const (
+	// OverflowError makes the operation fail with an error.
+	OverflowError OverflowPolicy = iota
+	// OverflowWrap wraps the result around, like Go does.
+	OverflowWrap
+	// OverflowPromote turns the result into a big integer.
+	OverflowPromote
+)
+
+type Evaluator struct {
+	Overflow OverflowPolicy
+
+	// Strict makes conditions accept only booleans, instead of
+	// using the truthiness of any value (see isTruthy).
+	Strict bool
+
+	// Stdout and Stdin are used by print(), println() and input().
+	Stdout io.Writer
+	Stdin  io.Reader
+
+	// MaxSteps, MaxDepth and MaxAllocations limit respectively the number
+	// of evaluated nodes, the depth of function calls and the size of the
+	// objects created by an evaluation, zero means no limit. Every object
+	// counts for one, plus one per element of arrays, hashes and function
+	// scopes and one per 8 bytes of strings and big integers.
+	MaxSteps       int64
+	MaxDepth       int
+	MaxAllocations int64
+
+	builtins  map[string]*object.Builtin
+	reader    *bufio.Reader
+	readerSrc io.Reader
+	run       *run
+}
+
+// New returns an evaluator with the default settings and builtins,
+// reading from and writing to the standard streams.
+func New() *Evaluator {
+	e := &Evaluator{
+		Stdout:   os.Stdout,
+		Stdin:    os.Stdin,
+		MaxDepth: DefaultMaxDepth,
+		builtins: maps.Clone(builtins),
+	}
+	// The builtins which create objects of any size account for them
+	e.RegisterBuiltin("push", e.builtinPush)
+	e.RegisterBuiltin("rest", e.builtinRest)
+	e.RegisterBuiltin("slice", e.builtinSlice)
+	e.RegisterBuiltin("keys", e.builtinKeys)
+	e.RegisterBuiltin("values", e.builtinValues)
+	e.RegisterBuiltin("str", e.builtinStr)
+	e.RegisterBuiltin("print", e.builtinPrint)
+	e.RegisterBuiltin("println", e.builtinPrintln)
+	e.RegisterBuiltin("input", e.builtinInput)
+
+	return e
+}
+
+// RegisterBuiltin makes fn callable as name, replacing any builtin with the
+// same name. Like the other builtins it can be shadowed by variables.
+func (e *Evaluator) RegisterBuiltin(name string, fn object.BuiltinFunction) {
+	if e.builtins == nil {
+		e.builtins = map[string]*object.Builtin{}
+	}
+	e.builtins[name] = &object.Builtin{Name: name, Fn: fn}
+}
+
+// Builtin returns the builtin registered as name, or nil if there is none.
+func (e *Evaluator) Builtin(name string) *object.Builtin {
+	return e.builtins[name]
+}
+
+// Infix applies a binary operator to two values, like evaluating 'left operator right'.
+func (e *Evaluator) Infix(operator string, left, right object.Object) object.Object {
+	return e.evalInfix(operator, left, right)
+}
+
+// Prefix applies a unary operator to a value, like evaluating 'operator obj'.
+func (e *Evaluator) Prefix(operator string, obj object.Object) object.Object {
+	return e.evalPrefix(operator, obj)
+}
+
+// Truthy returns the truth value of obj when used as a condition,
+// in strict mode it returns an error if obj is not a boolean.
+func (e *Evaluator) Truthy(obj object.Object) (bool, object.Object) {
+	return e.truthy(obj)
+}
+
+// Index returns the element of an array or a hash at the given index.
+func Index(left, index object.Object) object.Object {
+	return evalIndex(left, index)
+}
+
+// SetIndex stores value at the given index of an array or a hash.
+func SetIndex(obj, index, value object.Object) object.Object {
+	return setIndex(obj, index, value)
+}
+
+// Eval evaluates node in env with the default evaluator.
 func Eval(node ast.Node, env *environment.Environment) object.Object {
+	return New().Eval(node, env)
+}
+
+func (e *Evaluator) Eval(node ast.Node, env *environment.Environment) object.Object {
+	if e.run == nil {
+		return e.EvalContext(context.Background(), node, env)
+	}
+
+	var obj object.Object
+	if err := e.step(); err != nil {
+		obj = err
+	} else if obj = e.eval(node, env); !isTerminal(obj) {
+		if err := e.alloc(node); err != nil {
+			obj = err
+		}
+	}
+
+	// Errors are tagged with the innermost node that produced them,
+	// outer nodes leave the location untouched.
+	if err, ok := obj.(*object.Error); ok && !err.Loc.Start.IsValid() {
+		err.Loc = node.Span()
+		err.Stack = e.stackTrace()
+	}
+
+	return obj
+}
+
+func (e *Evaluator) eval(node ast.Node, env *environment.Environment) object.Object {
 	switch node := node.(type) {
 	case *ast.SyntaxError:
 		return &object.Error{Value: node}
 	case *ast.Program:
-		return evalProgram(node.Statements, env)
+		if err := resolver.Resolve(node, env); err != nil {
+			return &object.Error{Value: err, Loc: err.Loc}
+		}
+		return e.evalProgram(node.Statements, env)
 	case *ast.IntegerLiteral:
 		return &object.Integer{Value: node.Value}
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}
 	case *ast.BooleanLiteral:
 		if node.Value == true {
 			return &TRUE
@@ -29,43 +175,72 @@ func Eval(node ast.Node, env *environment.Environment) object.Object {
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
 	case *ast.PrefixExpression:
-		return evalPrefixExpression(*node, env)
+		return e.evalPrefixExpression(*node, env)
 	case *ast.InfixExpression:
-		return evalInfixExpression(*node, env)
+		return e.evalInfixExpression(*node, env)
 	case *ast.LetStatement:
-		return evalLetStatement(*node, env)
+		return e.evalLetStatement(*node, env)
 	case *ast.Identifier:
-		return evalIdentifier(*node, env)
+		return e.evalIdentifier(*node, env)
 	case *ast.IfStatement:
-		return evalIfStatement(*node, env)
+		return e.evalIfStatement(*node, env)
 	case *ast.FunctionDefinition:
-		return evalFunctionDef(*node, env)
-	case *ast.FunctionCall:
-		return evalFunctionCall(*node, env)
+		return e.evalFunctionDef(*node, env)
+	case *ast.CallExpression:
+		return e.evalCallExpression(*node, env)
+	case *ast.MemberExpression:
+		return e.evalMemberExpression(*node, env)
+	case *ast.ArrayLiteral:
+		return e.evalArrayLiteral(*node, env)
+	case *ast.IndexExpression:
+		return e.evalIndexExpression(*node, env)
+	case *ast.HashLiteral:
+		return e.evalHashLiteral(*node, env)
+	case *ast.AssignExpression:
+		return e.evalAssignExpression(*node, env)
+	case *ast.WhileStatement:
+		return e.evalWhileStatement(*node, env)
+	case *ast.ForStatement:
+		return e.evalForStatement(*node, env)
+	case *ast.BreakStatement:
+		return &object.Break{}
+	case *ast.ContinueStatement:
+		return &object.Continue{}
 	case *ast.ReturnStatement:
-		obj := Eval(node.Expression, env)
+		obj := unwrapReturn(e.Eval(node.Expression, env))
+		if isTerminal(obj) {
+			return obj
+		}
 		return &object.Return{Value: obj}
 	}
 
-	return nil
+	return newError("cannot evaluate %T", node)
 }
 
-func evalProgram(statements []ast.Node, env *environment.Environment) object.Object {
-	var obj object.Object
+func (e *Evaluator) evalProgram(statements []ast.Node, env *environment.Environment) object.Object {
+	var obj object.Object = &NULL
 
 	for _, stmt := range statements {
-		obj = Eval(stmt, env)
+		// A return only leaves the statement it is in, its value is the
+		// value of the statement
+		obj = unwrapReturn(e.Eval(stmt, env))
+		if isTerminal(obj) {
+			return obj
+		}
+		if isLoopSignal(obj) {
+			return newError("%s outside of a loop", obj.Inspect())
+		}
 	}
 
 	return obj
 }
 
-func evalBlockStatement(statements []ast.Node, env *environment.Environment) object.Object {
-	var obj object.Object
+func (e *Evaluator) evalBlockStatement(statements []ast.Node, env *environment.Environment) object.Object {
+	var obj object.Object = &NULL
 
 	for _, stmt := range statements {
-		obj = Eval(stmt, env)
-		if obj != nil && obj.Type() == object.RETURN_OBJ {
+		obj = e.Eval(stmt, env)
+		if obj.Type() == object.RETURN_OBJ || isTerminal(obj) || isLoopSignal(obj) {
 			break
 		}
 	}
@@ -73,217 +248,983 @@ func evalBlockStatement(statements []ast.Node, env *environment.Environment) obj
 	return obj
 }
 
-func evalPrefixExpression(node ast.PrefixExpression, env *environment.Environment) object.Object {
-	obj := Eval(node.Value, env)
+func (e *Evaluator) evalPrefixExpression(node ast.PrefixExpression, env *environment.Environment) object.Object {
+	obj := unwrapReturn(e.Eval(node.Value, env))
+	if isTerminal(obj) {
+		return obj
+	}
+
+	return e.evalPrefix(node.Prefix.Literal, obj)
+}
 
-	switch node.Prefix.Literal {
+// evalPrefix applies a unary operator to an already evaluated operand.
+func (e *Evaluator) evalPrefix(operator string, obj object.Object) object.Object {
+	switch operator {
 	case "!":
-		switch obj := obj.(type) {
-		case *object.Boolean:
-			return &object.Boolean{Value: !obj.Value}
+		value, errObj := e.truthy(obj)
+		if errObj != nil {
+			return errObj
 		}
+		return nativeBoolToBoolean(!value)
 	case "-":
 		switch obj := obj.(type) {
 		case *object.Integer:
+			if obj.Value == math.MinInt64 {
+				return e.overflow("-", big.NewInt(0), big.NewInt(obj.Value), obj.Value)
+			}
 			return &object.Integer{Value: -obj.Value}
+		case *object.BigInteger:
+			return normalizeBigInteger(new(big.Int).Neg(obj.Value))
+		case *object.Float:
+			return &object.Float{Value: -obj.Value}
+		}
+	case "~":
+		switch obj := obj.(type) {
+		case *object.Integer:
+			return &object.Integer{Value: ^obj.Value}
+		case *object.BigInteger:
+			return normalizeBigInteger(new(big.Int).Not(obj.Value))
 		}
 	}
 
-	return nil
+	return newError("unknown operator: %s%s", operator, obj.Type())
 }
 
-func evalInfixExpression(node ast.InfixExpression, env *environment.Environment) object.Object {
-	left := Eval(node.Left, env)
-	right := Eval(node.Right, env)
+func (e *Evaluator) evalInfixExpression(node ast.InfixExpression, env *environment.Environment) object.Object {
+	if node.Operator.Type == token.AND || node.Operator.Type == token.OR {
+		return e.evalLogicalExpression(node, env)
+	}
 
-	if left.Type() == object.RETURN_OBJ {
-		left = left.(*object.Return).Value
+	left := unwrapReturn(e.Eval(node.Left, env))
+	if isTerminal(left) {
+		return left
 	}
 
-	if right.Type() == object.RETURN_OBJ {
-		right = right.(*object.Return).Value
+	right := unwrapReturn(e.Eval(node.Right, env))
+	if isTerminal(right) {
+		return right
 	}
 
-	switch node.Operator.Literal {
-	case "+":
-		if (left.Type() == right.Type()) && left.Type() == object.INTEGER_OBJ {
-			return &object.Integer{
-				Value: left.(*object.Integer).Value + right.(*object.Integer).Value,
-			}
-		}
+	return e.evalInfix(node.Operator.Literal, left, right)
+}
 
-		if (left.Type() == right.Type()) && left.Type() == object.STRING_OBJ {
-			return &object.String{
-				Value: left.(*object.String).Value + right.(*object.String).Value,
+// evalLogicalExpression evaluates '&&' and '||', the right side is evaluated
+// only if the left one does not already decide the result.
+func (e *Evaluator) evalLogicalExpression(node ast.InfixExpression, env *environment.Environment) object.Object {
+	left, errObj := e.evalCondition(node.Left, env)
+	if errObj != nil {
+		return errObj
+	}
+	if left == (node.Operator.Type == token.OR) {
+		return nativeBoolToBoolean(left)
+	}
+
+	right, errObj := e.evalCondition(node.Right, env)
+	if errObj != nil {
+		return errObj
+	}
+
+	return nativeBoolToBoolean(right)
+}
+
+// evalCondition evaluates node and returns its truth value.
+func (e *Evaluator) evalCondition(node ast.Node, env *environment.Environment) (bool, object.Object) {
+	obj := unwrapReturn(e.Eval(node, env))
+	if isTerminal(obj) {
+		return false, obj
+	}
+
+	return e.truthy(obj)
+}
+
+// truthy returns the truth value of obj, in strict mode obj must be a boolean.
+func (e *Evaluator) truthy(obj object.Object) (bool, object.Object) {
+	if !e.Strict {
+		return isTruthy(obj), nil
+	}
+
+	if obj, ok := obj.(*object.Boolean); ok {
+		return obj.Value, nil
+	}
+	return false, newError("expected boolean, instead got '%s'", obj.Inspect())
+}
+
+// isTruthy reports whether obj counts as true in conditions ('if', 'while', '!', '&&' and '||').
+// false, null, 0, 0.0, the empty string and empty arrays, hashes and ranges are falsy,
+// any other value is truthy.
+func isTruthy(obj object.Object) bool {
+	switch obj := obj.(type) {
+	case *object.Boolean:
+		return obj.Value
+	case *object.Null:
+		return false
+	case *object.Integer:
+		return obj.Value != 0
+	case *object.Float:
+		return obj.Value != 0
+	case *object.String:
+		return obj.Value != ""
+	case *object.Array:
+		return len(obj.Elements) > 0
+	case *object.Hash:
+		return len(obj.Pairs) > 0
+	case *object.Range:
+		return (obj.Step > 0 && obj.Start < obj.End) || (obj.Step < 0 && obj.Start > obj.End)
+	}
+
+	return true
+}
+
+// evalInfix applies a binary operator to two already evaluated operands.
+func (e *Evaluator) evalInfix(operator string, left, right object.Object) object.Object {
+	switch {
+	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
+		return e.evalIntegerInfix(operator, left.(*object.Integer).Value, right.(*object.Integer).Value)
+	case isInteger(left) && isInteger(right):
+		return e.evalBigIntegerInfix(operator, bigValue(left), bigValue(right))
+	case isNumber(left) && isNumber(right):
+		// Mixing floats and integers gives a float
+		return evalFloatInfix(operator, floatValue(left), floatValue(right))
+	case left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ:
+		l, r := left.(*object.String).Value, right.(*object.String).Value
+		if operator == "+" {
+			if err := e.Allocate(bytesSize(len(l) + len(r))); err != nil {
+				return err
 			}
 		}
+		return evalStringInfix(operator, l, r)
+	case left.Type() == object.BOOLEAN_OBJ && right.Type() == object.BOOLEAN_OBJ:
+		return evalBooleanInfix(operator, left.(*object.Boolean).Value, right.(*object.Boolean).Value)
+	case operator == "==":
+		return nativeBoolToBoolean(objectsEqual(left, right))
+	case operator == "!=":
+		return nativeBoolToBoolean(!objectsEqual(left, right))
+	case left.Type() != right.Type():
+		return newError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
+	}
 
+	return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
+}
+
+func (e *Evaluator) evalIntegerInfix(operator string, left, right int64) object.Object {
+	switch operator {
+	case "+":
+		res := left + right
+		if (right > 0 && res < left) || (right < 0 && res > left) {
+			return e.overflow(operator, big.NewInt(left), big.NewInt(right), res)
+		}
+		return &object.Integer{Value: res}
 	case "-":
-		if (left.Type() == right.Type()) && left.Type() == object.INTEGER_OBJ {
-			return &object.Integer{
-				Value: left.(*object.Integer).Value - right.(*object.Integer).Value,
-			}
+		res := left - right
+		if (right > 0 && res > left) || (right < 0 && res < left) {
+			return e.overflow(operator, big.NewInt(left), big.NewInt(right), res)
 		}
+		return &object.Integer{Value: res}
 	case "*":
-		if (left.Type() == right.Type()) && left.Type() == object.INTEGER_OBJ {
-			return &object.Integer{
-				Value: left.(*object.Integer).Value * right.(*object.Integer).Value,
-			}
+		res := left * right
+		if mulOverflows(left, right, res) {
+			return e.overflow(operator, big.NewInt(left), big.NewInt(right), res)
 		}
+		return &object.Integer{Value: res}
+	case "**":
+		if right < 0 {
+			return &object.Float{Value: math.Pow(float64(left), float64(right))}
+		}
+		res, overflowed := powInt(left, right)
+		if overflowed {
+			return e.overflow(operator, big.NewInt(left), big.NewInt(right), res)
+		}
+		return &object.Integer{Value: res}
+	case "&":
+		return &object.Integer{Value: left & right}
+	case "|":
+		return &object.Integer{Value: left | right}
+	case "^":
+		return &object.Integer{Value: left ^ right}
+	case "<<":
+		if right < 0 {
+			return newError("negative shift count: %d", right)
+		}
+		res := left << right
+		if left != 0 && (right >= 64 || res>>right != left) {
+			return e.overflow(operator, big.NewInt(left), big.NewInt(right), res)
+		}
+		return &object.Integer{Value: res}
+	case ">>":
+		if right < 0 {
+			return newError("negative shift count: %d", right)
+		}
+		return &object.Integer{Value: left >> right}
 	case "/":
-		if (left.Type() == right.Type()) && left.Type() == object.INTEGER_OBJ {
-			return &object.Integer{
-				Value: left.(*object.Integer).Value / right.(*object.Integer).Value,
-			}
+		if right == 0 {
+			return newError("division by zero")
 		}
-	case ">":
-		if (left.Type() == right.Type()) && left.Type() == object.INTEGER_OBJ {
-			return &object.Boolean{Value: left.(*object.Integer).Value > right.(*object.Integer).Value}
+		if left == math.MinInt64 && right == -1 {
+			return e.overflow(operator, big.NewInt(left), big.NewInt(right), left)
 		}
-	case ">=":
-		if (left.Type() == right.Type()) && left.Type() == object.INTEGER_OBJ {
-			return &object.Boolean{Value: left.(*object.Integer).Value >= right.(*object.Integer).Value}
+		return &object.Integer{Value: left / right}
+	case "%":
+		if right == 0 {
+			return newError("division by zero")
 		}
-
-	case "<":
-		if (left.Type() == right.Type()) && left.Type() == object.INTEGER_OBJ {
-			return &object.Boolean{Value: left.(*object.Integer).Value < right.(*object.Integer).Value}
+		if right == -1 {
+			// MinInt64 % -1 is the only remainder which can overflow
+			return &object.Integer{Value: 0}
 		}
+		return &object.Integer{Value: left % right}
+	case ">":
+		return nativeBoolToBoolean(left > right)
+	case ">=":
+		return nativeBoolToBoolean(left >= right)
+	case "<":
+		return nativeBoolToBoolean(left < right)
 	case "<=":
-		if (left.Type() == right.Type()) && left.Type() == object.INTEGER_OBJ {
-			return &object.Boolean{Value: left.(*object.Integer).Value <= right.(*object.Integer).Value}
-		}
+		return nativeBoolToBoolean(left <= right)
 	case "==":
-		if (left.Type() == right.Type()) && left.Type() == object.INTEGER_OBJ {
-			return &object.Boolean{Value: left.(*object.Integer).Value == right.(*object.Integer).Value}
-		}
+		return nativeBoolToBoolean(left == right)
+	case "!=":
+		return nativeBoolToBoolean(left != right)
+	}
 
-		if (left.Type() == right.Type()) && left.Type() == object.BOOLEAN_OBJ {
-			return &object.Boolean{Value: left.(*object.Boolean).Value == right.(*object.Boolean).Value}
-		}
+	return newError("unknown operator: %s %s %s", object.INTEGER_OBJ, operator, object.INTEGER_OBJ)
+}
+
+// mulOverflows reports whether res, the wrapped product of left and right,
+// overflowed.
+func mulOverflows(left, right, res int64) bool {
+	return left != 0 && (res/left != right || (left == -1 && right == math.MinInt64))
+}
 
-		if (left.Type() == right.Type()) && left.Type() == object.STRING_OBJ {
-			return &object.Boolean{Value: left.(*object.String).Value == right.(*object.String).Value}
+// powInt raises base to a non negative exponent by squaring, the result wraps
+// around on overflow.
+func powInt(base, exp int64) (int64, bool) {
+	res, overflowed := int64(1), false
+	for exp > 0 {
+		if exp&1 == 1 {
+			next := res * base
+			overflowed = overflowed || mulOverflows(res, base, next)
+			res = next
 		}
-	case "!=":
-		if (left.Type() == right.Type()) && left.Type() == object.INTEGER_OBJ {
-			return &object.Boolean{Value: left.(*object.Integer).Value != right.(*object.Integer).Value}
+		exp >>= 1
+		if exp > 0 {
+			next := base * base
+			overflowed = overflowed || mulOverflows(base, base, next)
+			base = next
 		}
+	}
+	return res, overflowed
+}
+
+// overflow applies the overflow policy to an operation whose result does not fit
+// in 64 bits, wrapped is the result computed with wrap around.
+func (e *Evaluator) overflow(operator string, left, right *big.Int, wrapped int64) object.Object {
+	switch e.Overflow {
+	case OverflowWrap:
+		return &object.Integer{Value: wrapped}
+	case OverflowPromote:
+		return e.evalBigIntegerInfix(operator, left, right)
+	}
+
+	if operator == "-" && left.Sign() == 0 {
+		return newError("integer overflow: -(%s)", right)
+	}
+	return newError("integer overflow: %s %s %s", left, operator, right)
+}
 
-		if (left.Type() == right.Type()) && left.Type() == object.BOOLEAN_OBJ {
-			return &object.Boolean{Value: left.(*object.Boolean).Value != right.(*object.Boolean).Value}
+// evalBigIntegerInfix applies a binary operator to integers of arbitrary size,
+// results which fit in 64 bits are turned back into plain integers.
+func (e *Evaluator) evalBigIntegerInfix(operator string, left, right *big.Int) object.Object {
+	if err := e.Allocate(bigIntegerSize(operator, left, right)); err != nil {
+		return err
+	}
+
+	switch operator {
+	case "+":
+		return normalizeBigInteger(new(big.Int).Add(left, right))
+	case "-":
+		return normalizeBigInteger(new(big.Int).Sub(left, right))
+	case "*":
+		return normalizeBigInteger(new(big.Int).Mul(left, right))
+	case "/":
+		if right.Sign() == 0 {
+			return newError("division by zero")
+		}
+		return normalizeBigInteger(new(big.Int).Quo(left, right))
+	case "%":
+		if right.Sign() == 0 {
+			return newError("division by zero")
+		}
+		return normalizeBigInteger(new(big.Int).Rem(left, right))
+	case "**":
+		if right.Sign() < 0 {
+			l, _ := left.Float64()
+			r, _ := right.Float64()
+			return &object.Float{Value: math.Pow(l, r)}
+		}
+		if !right.IsInt64() {
+			return newError("exponent too large: %s", right)
+		}
+		return normalizeBigInteger(new(big.Int).Exp(left, right, nil))
+	case "&":
+		return normalizeBigInteger(new(big.Int).And(left, right))
+	case "|":
+		return normalizeBigInteger(new(big.Int).Or(left, right))
+	case "^":
+		return normalizeBigInteger(new(big.Int).Xor(left, right))
+	case "<<", ">>":
+		if right.Sign() < 0 {
+			return newError("negative shift count: %s", right)
+		}
+		if !right.IsUint64() || right.Uint64() > math.MaxUint32 {
+			return newError("shift count too large: %s", right)
 		}
+		if operator == "<<" {
+			return normalizeBigInteger(new(big.Int).Lsh(left, uint(right.Uint64())))
+		}
+		return normalizeBigInteger(new(big.Int).Rsh(left, uint(right.Uint64())))
+	case ">":
+		return nativeBoolToBoolean(left.Cmp(right) > 0)
+	case ">=":
+		return nativeBoolToBoolean(left.Cmp(right) >= 0)
+	case "<":
+		return nativeBoolToBoolean(left.Cmp(right) < 0)
+	case "<=":
+		return nativeBoolToBoolean(left.Cmp(right) <= 0)
+	case "==":
+		return nativeBoolToBoolean(left.Cmp(right) == 0)
+	case "!=":
+		return nativeBoolToBoolean(left.Cmp(right) != 0)
+	}
 
-		if (left.Type() == right.Type()) && left.Type() == object.STRING_OBJ {
-			return &object.Boolean{Value: left.(*object.String).Value != right.(*object.String).Value}
+	return newError("unknown operator: %s %s %s", object.BIG_INTEGER_OBJ, operator, object.BIG_INTEGER_OBJ)
+}
+
+func evalFloatInfix(operator string, left, right float64) object.Object {
+	switch operator {
+	case "+":
+		return &object.Float{Value: left + right}
+	case "-":
+		return &object.Float{Value: left - right}
+	case "*":
+		return &object.Float{Value: left * right}
+	case "/":
+		if right == 0 {
+			return newError("division by zero")
 		}
+		return &object.Float{Value: left / right}
+	case "%":
+		if right == 0 {
+			return newError("division by zero")
+		}
+		return &object.Float{Value: math.Mod(left, right)}
+	case "**":
+		return &object.Float{Value: math.Pow(left, right)}
+	case ">":
+		return nativeBoolToBoolean(left > right)
+	case ">=":
+		return nativeBoolToBoolean(left >= right)
+	case "<":
+		return nativeBoolToBoolean(left < right)
+	case "<=":
+		return nativeBoolToBoolean(left <= right)
+	case "==":
+		return nativeBoolToBoolean(left == right)
+	case "!=":
+		return nativeBoolToBoolean(left != right)
 	}
 
-	return nil
+	return newError("unknown operator: %s %s %s", object.FLOAT_OBJ, operator, object.FLOAT_OBJ)
+}
+
+func normalizeBigInteger(value *big.Int) object.Object {
+	if value.IsInt64() {
+		return &object.Integer{Value: value.Int64()}
+	}
+	return &object.BigInteger{Value: value}
+}
+
+func isInteger(obj object.Object) bool {
+	return obj.Type() == object.INTEGER_OBJ || obj.Type() == object.BIG_INTEGER_OBJ
+}
+
+func isNumber(obj object.Object) bool {
+	return isInteger(obj) || obj.Type() == object.FLOAT_OBJ
+}
+
+// floatValue returns the value of a number as a float.
+func floatValue(obj object.Object) float64 {
+	switch obj := obj.(type) {
+	case *object.Float:
+		return obj.Value
+	case *object.BigInteger:
+		value, _ := new(big.Float).SetInt(obj.Value).Float64()
+		return value
+	}
+	return float64(obj.(*object.Integer).Value)
+}
+
+// bigValue returns the value of an integer of any size as a big integer.
+func bigValue(obj object.Object) *big.Int {
+	if obj, ok := obj.(*object.BigInteger); ok {
+		return obj.Value
+	}
+	return big.NewInt(obj.(*object.Integer).Value)
+}
+
+func evalStringInfix(operator string, left, right string) object.Object {
+	switch operator {
+	case "+":
+		return &object.String{Value: left + right}
+	case "==":
+		return nativeBoolToBoolean(left == right)
+	case "!=":
+		return nativeBoolToBoolean(left != right)
+	}
+
+	return newError("unknown operator: %s %s %s", object.STRING_OBJ, operator, object.STRING_OBJ)
+}
+
+func evalBooleanInfix(operator string, left, right bool) object.Object {
+	switch operator {
+	case "==":
+		return nativeBoolToBoolean(left == right)
+	case "!=":
+		return nativeBoolToBoolean(left != right)
+	}
+
+	return newError("unknown operator: %s %s %s", object.BOOLEAN_OBJ, operator, object.BOOLEAN_OBJ)
+}
+
+// objectsEqual compares objects which have no dedicated equality,
+// values of different types are never equal.
+func objectsEqual(left, right object.Object) bool {
+	if left.Type() != right.Type() {
+		return false
+	}
+	if left.Type() == object.NULL_OBJ {
+		return true
+	}
+
+	return left == right
 }
 
-func evalLetStatement(node ast.LetStatement, env *environment.Environment) object.Object {
-	value := Eval(node.Value, env)
-	if value.Type() == object.RETURN_OBJ {
-		value = value.(*object.Return).Value
+func nativeBoolToBoolean(value bool) *object.Boolean {
+	if value {
+		return &TRUE
 	}
+	return &FALSE
+}
+
+// isLoopSignal reports whether obj is a break or a continue.
+func isLoopSignal(obj object.Object) bool {
+	return obj.Type() == object.BREAK_OBJ || obj.Type() == object.CONTINUE_OBJ
+}
+
+func isError(obj object.Object) bool {
+	return obj != nil && obj.Type() == object.ERROR_OBJ
+}
 
-	env.Set(node.Ident, value)
+// isTerminal reports whether obj stops the evaluation, which is what errors
+// and exit() do.
+func isTerminal(obj object.Object) bool {
+	return isError(obj) || (obj != nil && obj.Type() == object.EXIT_OBJ)
+}
+
+func (e *Evaluator) evalLetStatement(node ast.LetStatement, env *environment.Environment) object.Object {
+	value := unwrapReturn(e.Eval(node.Value, env))
+	if isTerminal(value) {
+		return value
+	}
+
+	env.Define(node.Slot, value)
 
 	return &object.Boolean{Value: true}
 }
 
-func evalIdentifier(node ast.Identifier, env *environment.Environment) object.Object {
-	res := env.Get(node.Name)
+func (e *Evaluator) evalIdentifier(node ast.Identifier, env *environment.Environment) object.Object {
+	res := variable(&node, env)
 	if res != nil {
 		return res
 	}
 
+	if builtin, ok := e.builtins[node.Name]; ok {
+		return builtin
+	}
+
 	return &NULL
 }
 
-func evalIfStatement(node ast.IfStatement, env *environment.Environment) object.Object {
-	mainCondition := Eval(node.MainCondition, env)
+func (e *Evaluator) evalIfStatement(node ast.IfStatement, env *environment.Environment) object.Object {
+	mainCondition, errObj := e.evalCondition(node.MainCondition, env)
+	if errObj != nil {
+		return errObj
+	}
 
-	switch mainCondition := mainCondition.(type) {
-	case *object.Boolean:
-		if mainCondition.Value {
-			currentEnv := environment.New()
-			currentEnv.Extend(env)
-			return evalBlockStatement(node.MainStatements, &currentEnv)
-		}
-	default:
-		return &object.Error{Value: fmt.Errorf("expected boolean, instead got '%s'\n", mainCondition.Inspect())}
+	if mainCondition {
+		return e.evalBlockStatement(node.MainStatements, environment.NewEnclosed(env, 0))
 	}
 
 	for _, elseIf := range node.ElseIfs {
-		currentEnv := environment.New()
-		currentEnv.Extend(env)
-		res := evalElseIf(elseIf, &currentEnv)
+		res := e.evalElseIf(elseIf, environment.NewEnclosed(env, 0))
 		if res != nil {
 			return res
 		}
 	}
 
 	if len(node.ElseStatements) != 0 {
-		currentEnv := environment.New()
-		currentEnv.Extend(env)
-		return evalBlockStatement(node.ElseStatements, &currentEnv)
+		return e.evalBlockStatement(node.ElseStatements, environment.NewEnclosed(env, 0))
 	}
 
 	return &NULL
 }
 
-func evalElseIf(node ast.ElseIf, env *environment.Environment) object.Object {
-	condition := Eval(node.Condition, env)
+func (e *Evaluator) evalElseIf(node ast.ElseIf, env *environment.Environment) object.Object {
+	condition, errObj := e.evalCondition(node.Condition, env)
+	if errObj != nil {
+		return errObj
+	}
 
-	switch condition := condition.(type) {
-	case *object.Boolean:
-		if condition.Value {
-			return evalBlockStatement(node.Statements, env)
+	if condition {
+		return e.evalBlockStatement(node.Statements, env)
+	}
+
+	return nil
+}
+
+func (e *Evaluator) evalWhileStatement(node ast.WhileStatement, env *environment.Environment) object.Object {
+	for {
+		condition, errObj := e.evalCondition(node.Condition, env)
+		if errObj != nil {
+			return errObj
+		}
+		if !condition {
+			return &NULL
+		}
+
+		if res, done := e.evalLoopBody(node.Statements, env, nil); done {
+			return res
+		}
+	}
+}
+
+func (e *Evaluator) evalForStatement(node ast.ForStatement, env *environment.Environment) object.Object {
+	iterable := unwrapReturn(e.Eval(node.Iterable, env))
+	if isTerminal(iterable) {
+		return iterable
+	}
+
+	switch iterable := iterable.(type) {
+	case *object.Array:
+		for _, elem := range iterable.Elements {
+			if res, done := e.evalLoopBody(node.Statements, env, elem); done {
+				return res
+			}
+		}
+	case *object.String:
+		for _, char := range iterable.Value {
+			if res, done := e.evalLoopBody(node.Statements, env, &object.String{Value: string(char)}); done {
+				return res
+			}
+		}
+	case *object.Range:
+		for i := iterable.Start; (iterable.Step > 0 && i < iterable.End) || (iterable.Step < 0 && i > iterable.End); i += iterable.Step {
+			if res, done := e.evalLoopBody(node.Statements, env, &object.Integer{Value: i}); done {
+				return res
+			}
+
+			// Stop before i wraps around
+			if (iterable.Step > 0 && i > math.MaxInt64-iterable.Step) || (iterable.Step < 0 && i < math.MinInt64-iterable.Step) {
+				break
+			}
+		}
+	case *object.Hash:
+		// Keys are iterated in insertion order, the ones deleted by the loop body are skipped
+		for _, key := range slices.Clone(iterable.Order) {
+			pair, ok := iterable.Pairs[key]
+			if !ok {
+				continue
+			}
+
+			if res, done := e.evalLoopBody(node.Statements, env, pair.Key); done {
+				return res
+			}
 		}
 	default:
-		return &object.Error{Value: fmt.Errorf("expected boolean, instead got '%s'\n", condition.Inspect())}
+		return newError("cannot iterate over %s", iterable.Type())
 	}
 
-	return nil
+	return &NULL
 }
 
-func evalFunctionDef(node ast.FunctionDefinition, env *environment.Environment) object.Object {
-	if env.Get(node.Name) != nil {
-		return &object.Error{Value: fmt.Errorf("evaluation error: function with name '%s' already exists\n", node.Name)}
+// evalLoopBody runs an iteration of a loop in a new scope where the loop variable,
+// if any, is bound to value. done is true when the loop must stop and evaluate to res.
+func (e *Evaluator) evalLoopBody(statements []ast.Node, env *environment.Environment, value object.Object) (res object.Object, done bool) {
+	// An iteration is a step on its own, loops with an empty body
+	// must be stopped too.
+	if err := e.step(); err != nil {
+		return err, true
+	}
+	if err := e.Allocate(1); err != nil {
+		return err, true
+	}
+
+	// The resolver puts the loop variable in the first slot
+	loopEnv := environment.NewEnclosed(env, 0)
+	if value != nil {
+		loopEnv.Define(0, value)
+	}
+
+	res = e.evalBlockStatement(statements, loopEnv)
+	switch res.Type() {
+	case object.BREAK_OBJ:
+		return &NULL, true
+	case object.RETURN_OBJ, object.ERROR_OBJ, object.EXIT_OBJ:
+		return res, true
 	}
 
-	res := &object.FunctionDef{Fn: node}
-	env.Set(node.Name, res)
+	return nil, false
+}
+
+func (e *Evaluator) evalFunctionDef(node ast.FunctionDefinition, env *environment.Environment) object.Object {
+	res := &object.FunctionDef{Fn: node, Env: env}
+
+	// Anonymous functions are just values, named ones are also bound in the current scope
+	if node.Name == "" {
+		return res
+	}
+
+	if node.Shadowed != nil && env.GetAt(node.Shadowed.Depth, node.Shadowed.Slot) != nil {
+		return newError("evaluation error: function with name '%s' already exists", node.Name)
+	}
+	env.Define(node.Slot, res)
 
 	return res
 }
 
-func evalFunctionCall(node ast.FunctionCall, env *environment.Environment) object.Object {
-	fn, ok := env.Get(node.Name).(*object.FunctionDef)
+func (e *Evaluator) evalCallExpression(node ast.CallExpression, env *environment.Environment) object.Object {
+	callee := unwrapReturn(e.Eval(node.Callee, env))
+	if isTerminal(callee) {
+		return callee
+	}
 
+	args, errObj := e.evalExpressions(node.Arguments, env)
+	if errObj != nil {
+		return errObj
+	}
+
+	switch fn := callee.(type) {
+	case *object.FunctionDef:
+		return e.applyFunction(fn, args, node.Span().Start)
+	case *object.Builtin:
+		return fn.Fn(args...)
+	}
+
+	if ident, ok := node.Callee.(*ast.Identifier); ok && variable(ident, env) == nil {
+		return newError("invalid function call: no function with name '%s'", ident.Name)
+	}
+	return newError("'%s' cannot be called, it is not a function", strings.TrimSpace(node.Callee.String()))
+}
+
+// evalExpressions evaluates the nodes in order, stopping at the first error.
+func (e *Evaluator) evalExpressions(nodes []ast.Node, env *environment.Environment) ([]object.Object, object.Object) {
+	res := []object.Object{}
+
+	for _, node := range nodes {
+		obj := unwrapReturn(e.Eval(node, env))
+		if isTerminal(obj) {
+			return nil, obj
+		}
+		res = append(res, obj)
+	}
+
+	return res, nil
+}
+
+// unwrapReturn returns the value carried by a return object,
+// any other object is returned as is.
+func unwrapReturn(obj object.Object) object.Object {
+	if ret, ok := obj.(*object.Return); ok {
+		return ret.Value
+	}
+
+	return obj
+}
+
+// Apply calls fn, which must be a function or a builtin, with args.
+func (e *Evaluator) Apply(fn object.Object, args []object.Object) object.Object {
+	if e.run == nil {
+		return e.ApplyContext(context.Background(), fn, args)
+	}
+
+	switch fn := fn.(type) {
+	case *object.FunctionDef:
+		return e.applyFunction(fn, args, token.Position{})
+	case *object.Builtin:
+		return fn.Fn(args...)
+	}
+
+	return newError("%s cannot be called, it is not a function", fn.Type())
+}
+
+// applyFunction calls fn from callSite, which is not valid for calls
+// coming from Go.
+func (e *Evaluator) applyFunction(fn *object.FunctionDef, args []object.Object, callSite token.Position) object.Object {
+	if len(args) != len(fn.Fn.Parameters) {
+		return newError("expected %d arguments in function call, instead got %d", len(fn.Fn.Parameters), len(args))
+	}
+
+	name := fn.Fn.Name
+	if name == "" {
+		name = "<anonymous>"
+	}
+	if err := e.enter(object.Frame{Function: name, Loc: callSite}); err != nil {
+		return err
+	}
+	defer e.leave()
+
+	if err := e.Allocate(int64(fn.Fn.NumLocals)); err != nil {
+		return err
+	}
+
+	// Free variables are resolved in the scope the function was defined in
+	currentEnv := environment.NewEnclosed(fn.Env.(*environment.Environment), fn.Fn.NumLocals)
+	for i, arg := range args {
+		currentEnv.Define(i, arg)
+	}
+
+	// The value of a call is the returned value, the return must not
+	// propagate any further than the function it comes from.
+	res := e.evalBlockStatement(fn.Fn.Body, currentEnv)
+	if isLoopSignal(res) {
+		return newError("%s outside of a loop", res.Inspect())
+	}
+	if res.Type() == object.RETURN_OBJ {
+		return res.(*object.Return).Value
+	}
+
+	return res
+}
+
+func (e *Evaluator) evalMemberExpression(node ast.MemberExpression, env *environment.Environment) object.Object {
+	obj := unwrapReturn(e.Eval(node.Object, env))
+	if isTerminal(obj) {
+		return obj
+	}
+
+	return Member(obj, node.Property)
+}
+
+// Member returns the property of obj, 'hash.name' is a shorthand for 'hash["name"]'.
+func Member(obj object.Object, property string) object.Object {
+	if hash, ok := obj.(*object.Hash); ok {
+		return hashGet(hash, &object.String{Value: property})
+	}
+
+	return newError("cannot access property '%s' of %s", property, obj.Type())
+}
+
+func (e *Evaluator) evalArrayLiteral(node ast.ArrayLiteral, env *environment.Environment) object.Object {
+	elements, errObj := e.evalExpressions(node.Elements, env)
+	if errObj != nil {
+		return errObj
+	}
+	if err := e.Allocate(int64(len(elements))); err != nil {
+		return err
+	}
+
+	return &object.Array{Elements: elements}
+}
+
+func (e *Evaluator) evalIndexExpression(node ast.IndexExpression, env *environment.Environment) object.Object {
+	left := unwrapReturn(e.Eval(node.Left, env))
+	if isTerminal(left) {
+		return left
+	}
+
+	index := unwrapReturn(e.Eval(node.Index, env))
+	if isTerminal(index) {
+		return index
+	}
+
+	return evalIndex(left, index)
+}
+
+// evalIndex returns the element of an array or a hash at the given index.
+func evalIndex(left, index object.Object) object.Object {
+	switch left := left.(type) {
+	case *object.Array:
+		i, ok := index.(*object.Integer)
+		if !ok {
+			return newError("array index must be INT, instead got %s", index.Type())
+		}
+		if i.Value < 0 {
+			return newError("negative array index: %d", i.Value)
+		}
+		if i.Value >= int64(len(left.Elements)) {
+			return newError("array index out of range: %d with length %d", i.Value, len(left.Elements))
+		}
+
+		return left.Elements[i.Value]
+	case *object.Hash:
+		return hashGet(left, index)
+	}
+
+	return newError("index operator not supported: %s[%s]", left.Type(), index.Type())
+}
+
+// hashGet returns the value stored at key, or null if there is none.
+func hashGet(hash *object.Hash, key object.Object) object.Object {
+	hashable, ok := key.(object.Hashable)
 	if !ok {
-		return &object.Error{Value: fmt.Errorf("'%s' cannot be called, it is not a function\n", node.Name)}
+		return newError("unusable as hash key: %s", key.Type())
 	}
 
-	if fn == nil {
-		return &object.Error{Value: fmt.Errorf("invalid function call: no function with name '%s'\n", node.Name)}
+	if value, ok := hash.Get(hashable); ok {
+		return value
 	}
 
-	if len(node.Arguments) != len(fn.Fn.Parameters) {
-		return &object.Error{
-			Value: fmt.Errorf("expected %d arguments in function call, instead got %d\n", len(fn.Fn.Parameters), len(node.Arguments)),
+	return &NULL
+}
+
+func (e *Evaluator) evalHashLiteral(node ast.HashLiteral, env *environment.Environment) object.Object {
+	if err := e.Allocate(int64(len(node.Pairs))); err != nil {
+		return err
+	}
+	hash := object.NewHash()
+
+	for _, pair := range node.Pairs {
+		key := unwrapReturn(e.Eval(pair.Key, env))
+		if isTerminal(key) {
+			return key
+		}
+
+		hashable, ok := key.(object.Hashable)
+		if !ok {
+			return newError("unusable as hash key: %s", key.Type())
 		}
+
+		value := unwrapReturn(e.Eval(pair.Value, env))
+		if isTerminal(value) {
+			return value
+		}
+
+		hash.Set(hashable, value)
+	}
+
+	return hash
+}
+
+func (e *Evaluator) evalAssignExpression(node ast.AssignExpression, env *environment.Environment) object.Object {
+	var container, key ast.Node
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		return e.evalAssignVariable(target, node, env)
+	case *ast.IndexExpression:
+		container, key = target.Left, target.Index
+	case *ast.MemberExpression:
+		container, key = target.Object, &ast.StringLiteral{Value: target.Property, Loc: target.Loc}
+	default:
+		return newError("invalid assignment target: %s", strings.TrimSpace(node.Target.String()))
+	}
+
+	obj := unwrapReturn(e.Eval(container, env))
+	if isTerminal(obj) {
+		return obj
+	}
+
+	index := unwrapReturn(e.Eval(key, env))
+	if isTerminal(index) {
+		return index
+	}
+
+	value := unwrapReturn(e.Eval(node.Value, env))
+	if isTerminal(value) {
+		return value
+	}
+
+	if node.Operator.Type != token.ASSIGN {
+		current := evalIndex(obj, index)
+		if isError(current) {
+			return current
+		}
+
+		value = e.evalCompoundAssign(node.Operator, current, value)
+		if isError(value) {
+			return value
+		}
+	}
+
+	return setIndex(obj, index, value)
+}
+
+// evalAssignVariable updates an existing variable, which may belong to any of the enclosing scopes.
+func (e *Evaluator) evalAssignVariable(target *ast.Identifier, node ast.AssignExpression, env *environment.Environment) object.Object {
+	current := variable(target, env)
+	if current == nil {
+		return newError("cannot assign to undeclared variable '%s'", target.Name)
+	}
+
+	value := unwrapReturn(e.Eval(node.Value, env))
+	if isTerminal(value) {
+		return value
+	}
+
+	if node.Operator.Type != token.ASSIGN {
+		value = e.evalCompoundAssign(node.Operator, current, value)
+		if isError(value) {
+			return value
+		}
+	}
+	if target.Slot == ast.Unresolved {
+		env.Assign(target.Name, value)
+	} else {
+		env.SetAt(target.Depth, target.Slot, value)
+	}
+
+	return value
+}
+
+// variable returns the value of the variable id, or nil if it has none.
+// The names never declared in the program are globals of another one.
+func variable(id *ast.Identifier, env *environment.Environment) object.Object {
+	if id.Slot == ast.Unresolved {
+		return env.Get(id.Name)
 	}
 
-	currentEnv := environment.New()
-	currentEnv.Extend(env)
-	for i, node := range node.Arguments {
-		obj := Eval(node, &currentEnv)
-		ident := fn.Fn.Parameters[i].(*ast.Identifier)
-		currentEnv.Set(ident.Name, obj)
+	return env.GetAt(id.Depth, id.Slot)
+}
+
+// evalCompoundAssign computes the new value of 'target op= value', so '+=' behaves like '+'.
+func (e *Evaluator) evalCompoundAssign(operator token.Token, current, value object.Object) object.Object {
+	return e.evalInfix(strings.TrimSuffix(operator.Literal, "="), current, value)
+}
+
+// setIndex stores value at the given index of a hash or an array.
+func setIndex(obj, index, value object.Object) object.Object {
+	switch obj := obj.(type) {
+	case *object.Hash:
+		hashable, ok := index.(object.Hashable)
+		if !ok {
+			return newError("unusable as hash key: %s", index.Type())
+		}
+		obj.Set(hashable, value)
+
+		return value
+	case *object.Array:
+		i, ok := index.(*object.Integer)
+		if !ok {
+			return newError("array index must be INT, instead got %s", index.Type())
+		}
+		if i.Value < 0 {
+			return newError("negative array index: %d", i.Value)
+		}
+		if i.Value >= int64(len(obj.Elements)) {
+			return newError("array index out of range: %d with length %d", i.Value, len(obj.Elements))
+		}
+		obj.Elements[i.Value] = value
+
+		return value
 	}
 
-	return evalBlockStatement(fn.Fn.Body, &currentEnv)
+	return newError("index assignment not supported: %s[%s]", obj.Type(), index.Type())
 }
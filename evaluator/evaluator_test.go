@@ -1,13 +1,20 @@
 package evaluator
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"math"
+	"math/big"
 	"maz-lang/environment"
 	"maz-lang/lexer"
 	"maz-lang/object"
 	"maz-lang/parser"
 	"maz-lang/token"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 )
@@ -84,6 +91,18 @@ func TestEvalPrefixExpression(t *testing.T) {
 			Expression:  "-10",
 			ExpectedObj: &object.Integer{Value: -10},
 		},
+		{
+			Expression:  "!0",
+			ExpectedObj: &object.Boolean{Value: true},
+		},
+		{
+			Expression:  "!\"a\"",
+			ExpectedObj: &object.Boolean{Value: false},
+		},
+		{
+			Expression:  "!![]",
+			ExpectedObj: &object.Boolean{Value: false},
+		},
 	}
 
 	for _, tt := range tests {
@@ -208,6 +227,18 @@ func TestEvalInfixExpression(t *testing.T) {
 			Expression:  "\"foo\" + \" \" + \"bar\"",
 			ExpectedObj: &object.String{Value: "foo bar"},
 		},
+		{
+			Expression:  "10 % 3",
+			ExpectedObj: &object.Integer{Value: 1},
+		},
+		{
+			Expression:  "-7 % 3",
+			ExpectedObj: &object.Integer{Value: -1},
+		},
+		{
+			Expression:  "2 + 10 % 4 * 3",
+			ExpectedObj: &object.Integer{Value: 8},
+		},
 	}
 
 	for _, tt := range tests {
@@ -307,19 +338,27 @@ func TestEvalReturnStatement(t *testing.T) {
 	}{
 		{
 			Expression:  "foo(1,1)",
-			ExpectedObj: &object.Return{Value: &object.Integer{Value: -4}},
+			ExpectedObj: &object.Integer{Value: -4},
 		},
 		{
 			Expression:  "foo(10, 1)",
-			ExpectedObj: &object.Return{Value: &object.Integer{Value: -1}},
+			ExpectedObj: &object.Integer{Value: -1},
 		},
 		{
 			Expression:  "foo(1, 10)",
-			ExpectedObj: &object.Return{Value: &object.Integer{Value: -3}},
+			ExpectedObj: &object.Integer{Value: -3},
 		},
 		{
 			Expression:  "foo(5, 1)",
-			ExpectedObj: &object.Return{Value: &object.Integer{Value: -2}},
+			ExpectedObj: &object.Integer{Value: -2},
+		},
+		{
+			Expression:  "return 5;",
+			ExpectedObj: &object.Integer{Value: 5},
+		},
+		{
+			Expression:  "if true { return 6; }",
+			ExpectedObj: &object.Integer{Value: 6},
 		},
 	}
 
@@ -343,11 +382,11 @@ func TestEvalFunction(t *testing.T) {
 	}{
 		{
 			Expression:  "fn sum(a, b) {return a + b;} sum(1,2)",
-			ExpectedObj: &object.Return{Value: &object.Integer{Value: 3}},
+			ExpectedObj: &object.Integer{Value: 3},
 		},
 		{
 			Expression:  "fn fib(n) { if n == 0 { return 0; } else if n == 1 { return 1; } else if n == 2 { return 1; } else { return fib(n-1) + fib(n-2); } } fib(19)",
-			ExpectedObj: &object.Return{Value: &object.Integer{Value: 4181}},
+			ExpectedObj: &object.Integer{Value: 4181},
 		},
 	}
 
@@ -391,3 +430,1729 @@ func TestEvalStringLiteteral(t *testing.T) {
 		}
 	}
 }
+
+func TestErrorPosition(t *testing.T) {
+	input := "fn foo(a) {\n\treturn a;\n}\n  foo(1, 2)"
+
+	l := lexer.NewWithFilename("foo.mz", input)
+	program := parser.New(&l).Parse(token.EOF)
+	env := environment.New()
+	obj := Eval(&program, &env)
+
+	err, ok := obj.(*object.Error)
+	if !ok {
+		t.Fatalf("expected object.Error, instead got %+v\n", obj)
+	}
+
+	expected := "foo.mz:4:3: expected 1 arguments in function call, instead got 2\n  foo(1, 2)\n  ^"
+	if err.Describe(input) != expected {
+		t.Errorf("expected error to be:\n%s\ninstead got:\n%s\n", expected, err.Describe(input))
+	}
+}
+
+func TestEvalRuntimeErrors(t *testing.T) {
+	tests := []struct {
+		Expression    string
+		ExpectedError string
+	}{
+		{
+			Expression:    "1 + true",
+			ExpectedError: "type mismatch: INT + BOOL",
+		},
+		{
+			Expression:    "-\"a\"",
+			ExpectedError: "unknown operator: -STRING",
+		},
+		{
+			Expression:    "true + false",
+			ExpectedError: "unknown operator: BOOL + BOOL",
+		},
+		{
+			Expression:    "\"a\" - \"b\"",
+			ExpectedError: "unknown operator: STRING - STRING",
+		},
+		{
+			Expression:    "1 / 0",
+			ExpectedError: "division by zero",
+		},
+		{
+			Expression:    "let a = 0; 10 % a",
+			ExpectedError: "division by zero",
+		},
+		{
+			Expression:    "let a = 1 + true; 10",
+			ExpectedError: "type mismatch: INT + BOOL",
+		},
+		{
+			Expression:    "1 + true; 10",
+			ExpectedError: "type mismatch: INT + BOOL",
+		},
+		{
+			Expression:    "fn foo() { -true; return 10; } foo()",
+			ExpectedError: "unknown operator: -BOOL",
+		},
+		{
+			Expression:    "if 1 > 0 { if true { [] * 2; } return 1; }",
+			ExpectedError: "type mismatch: ARRAY * INT",
+		},
+		{
+			Expression:    "len([1] + 1)",
+			ExpectedError: "type mismatch: ARRAY + INT",
+		},
+		{
+			Expression:    "fn id(a) { return a; } id(-true)",
+			ExpectedError: "unknown operator: -BOOL",
+		},
+		{
+			Expression:    "[1, -\"a\", 3]",
+			ExpectedError: "unknown operator: -STRING",
+		},
+		{
+			Expression:    "if 1 + true == 2 { 1 }",
+			ExpectedError: "type mismatch: INT + BOOL",
+		},
+		{
+			Expression:    "fn foo() { return 1 + true; } foo() + 1",
+			ExpectedError: "type mismatch: INT + BOOL",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("evaluating: '%s'\n", tt.Expression)
+		l := lexer.New(tt.Expression)
+		program := parser.New(&l).Parse(token.EOF)
+		env := environment.New()
+		obj := Eval(&program, &env)
+
+		err, ok := obj.(*object.Error)
+		if !ok {
+			t.Errorf("expected object.Error, instead got %+v\n", obj)
+			continue
+		}
+
+		if err.Inspect() != tt.ExpectedError {
+			t.Errorf("expected error '%s', instead got '%s'\n", tt.ExpectedError, err.Inspect())
+		}
+	}
+}
+
+func TestEvalStaticErrors(t *testing.T) {
+	tests := []struct {
+		Expression    string
+		ExpectedError string
+		ExpectedLoc   token.Position
+	}{
+		{
+			Expression:    "print(1); print(x); let x = 2;",
+			ExpectedError: "variable 'x' is used before being declared",
+			ExpectedLoc:   token.Position{Offset: 16, Line: 1, Column: 17},
+		},
+		{
+			Expression:    "print(1); let a = 1;\nlet a = 2;",
+			ExpectedError: "variable 'a' is already declared in this scope",
+			ExpectedLoc:   token.Position{Offset: 21, Line: 2, Column: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("evaluating: '%s'\n", tt.Expression)
+		l := lexer.New(tt.Expression)
+		program := parser.New(&l).Parse(token.EOF)
+		env := environment.New()
+		var out bytes.Buffer
+		e := New()
+		e.Stdout = &out
+		obj := e.Eval(&program, &env)
+
+		err, ok := obj.(*object.Error)
+		if !ok {
+			t.Errorf("expected object.Error, instead got %+v\n", obj)
+			continue
+		}
+
+		if err.Inspect() != tt.ExpectedError {
+			t.Errorf("expected error '%s', instead got '%s'\n", tt.ExpectedError, err.Inspect())
+		}
+		if err.Loc.Start != tt.ExpectedLoc {
+			t.Errorf("expected error at %+v, instead got %+v\n", tt.ExpectedLoc, err.Loc.Start)
+		}
+		// The program is rejected before running any of it
+		if out.Len() != 0 {
+			t.Errorf("expected no output, instead got %q\n", out.String())
+		}
+	}
+}
+
+func TestEvalMixedEquality(t *testing.T) {
+	tests := []struct {
+		Expression  string
+		ExpectedObj object.Object
+	}{
+		{
+			Expression:  "1 == true",
+			ExpectedObj: &object.Boolean{Value: false},
+		},
+		{
+			Expression:  "\"1\" != 1",
+			ExpectedObj: &object.Boolean{Value: true},
+		},
+		{
+			Expression:  "fn foo() {} foo() == foo()",
+			ExpectedObj: &object.Boolean{Value: true},
+		},
+		{
+			Expression:  "",
+			ExpectedObj: &object.Null{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("evaluating: '%s'\n", tt.Expression)
+		l := lexer.New(tt.Expression)
+		program := parser.New(&l).Parse(token.EOF)
+		env := environment.New()
+		obj := Eval(&program, &env)
+
+		if !cmp.Equal(obj, tt.ExpectedObj) {
+			t.Errorf("expected object to be %+v, instead got %+v\n", tt.ExpectedObj, obj)
+		}
+	}
+}
+
+func TestEvalOverflow(t *testing.T) {
+	tests := []struct {
+		Expression  string
+		Overflow    OverflowPolicy
+		ExpectedObj object.Object
+	}{
+		{
+			Expression:  "9223372036854775807 + 1",
+			Overflow:    OverflowError,
+			ExpectedObj: newError("integer overflow: 9223372036854775807 + 1"),
+		},
+		{
+			Expression:  "-9223372036854775807 - 2",
+			Overflow:    OverflowError,
+			ExpectedObj: newError("integer overflow: -9223372036854775807 - 2"),
+		},
+		{
+			Expression:  "4611686018427387904 * 2",
+			Overflow:    OverflowError,
+			ExpectedObj: newError("integer overflow: 4611686018427387904 * 2"),
+		},
+		{
+			Expression:  "-(-9223372036854775807 - 1)",
+			Overflow:    OverflowError,
+			ExpectedObj: newError("integer overflow: -(-9223372036854775808)"),
+		},
+		{
+			Expression:  "(-9223372036854775807 - 1) / -1",
+			Overflow:    OverflowError,
+			ExpectedObj: newError("integer overflow: -9223372036854775808 / -1"),
+		},
+		{
+			Expression:  "(-9223372036854775807 - 1) % -1",
+			Overflow:    OverflowError,
+			ExpectedObj: &object.Integer{Value: 0},
+		},
+		{
+			Expression:  "9223372036854775807 + 1",
+			Overflow:    OverflowWrap,
+			ExpectedObj: &object.Integer{Value: math.MinInt64},
+		},
+		{
+			Expression:  "4611686018427387904 * 2",
+			Overflow:    OverflowWrap,
+			ExpectedObj: &object.Integer{Value: math.MinInt64},
+		},
+		{
+			Expression:  "9223372036854775807 + 1",
+			Overflow:    OverflowPromote,
+			ExpectedObj: &object.BigInteger{Value: new(big.Int).Lsh(big.NewInt(1), 63)},
+		},
+		{
+			Expression:  "9223372036854775807 + 1 - 1",
+			Overflow:    OverflowPromote,
+			ExpectedObj: &object.Integer{Value: math.MaxInt64},
+		},
+		{
+			Expression:  "let a = 9223372036854775807 * 2; a > 9223372036854775807",
+			Overflow:    OverflowPromote,
+			ExpectedObj: &object.Boolean{Value: true},
+		},
+		{
+			Expression:  "(9223372036854775807 + 1) % 0",
+			Overflow:    OverflowPromote,
+			ExpectedObj: newError("division by zero"),
+		},
+		{
+			Expression:  "1 << 63",
+			Overflow:    OverflowError,
+			ExpectedObj: newError("integer overflow: 1 << 63"),
+		},
+		{
+			Expression:  "3 ** 40",
+			Overflow:    OverflowError,
+			ExpectedObj: newError("integer overflow: 3 ** 40"),
+		},
+		{
+			Expression:  "1 << 63",
+			Overflow:    OverflowWrap,
+			ExpectedObj: &object.Integer{Value: math.MinInt64},
+		},
+		{
+			Expression:  "2 ** 64",
+			Overflow:    OverflowPromote,
+			ExpectedObj: &object.BigInteger{Value: new(big.Int).Lsh(big.NewInt(1), 64)},
+		},
+		{
+			Expression:  "(1 << 64) >> 60",
+			Overflow:    OverflowPromote,
+			ExpectedObj: &object.Integer{Value: 16},
+		},
+		{
+			Expression:  "~(1 << 64) & 255",
+			Overflow:    OverflowPromote,
+			ExpectedObj: &object.Integer{Value: 255},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("evaluating: '%s'\n", tt.Expression)
+		l := lexer.New(tt.Expression)
+		program := parser.New(&l).Parse(token.EOF)
+		env := environment.New()
+		e := New()
+		e.Overflow = tt.Overflow
+		obj := e.Eval(&program, &env)
+
+		if err, ok := obj.(*object.Error); ok {
+			expected, ok := tt.ExpectedObj.(*object.Error)
+			if !ok || err.Inspect() != expected.Inspect() {
+				t.Errorf("expected object to be %+v, instead got error '%s'\n", tt.ExpectedObj, err.Inspect())
+			}
+			continue
+		}
+
+		if !cmp.Equal(obj, tt.ExpectedObj, cmp.Comparer(func(a, b *big.Int) bool { return a.Cmp(b) == 0 })) {
+			t.Errorf("expected object to be %+v, instead got %+v\n", tt.ExpectedObj, obj)
+		}
+	}
+}
+
+func TestEvalClosure(t *testing.T) {
+	tests := []struct {
+		Expression  string
+		ExpectedObj object.Object
+	}{
+		{
+			Expression:  "let double = fn (x) { return x * 2; }; double(21)",
+			ExpectedObj: &object.Integer{Value: 42},
+		},
+		{
+			Expression:  "fn make_adder(x) { return fn (y) { return x + y; }; } let add_two = make_adder(2); add_two(3)",
+			ExpectedObj: &object.Integer{Value: 5},
+		},
+		{
+			Expression:  "let x = 1; fn get_x() { return x; } fn call(x) { return get_x(); } call(100)",
+			ExpectedObj: &object.Integer{Value: 1},
+		},
+		{
+			Expression:  "fn twice(f, x) { return f(f(x)); } let inc = fn (x) { return x + 1; }; twice(inc, 5)",
+			ExpectedObj: &object.Integer{Value: 7},
+		},
+		{
+			Expression:  "fn ignore() { return 1; } fn foo() { ignore(); return 2; } foo()",
+			ExpectedObj: &object.Integer{Value: 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("evaluating: '%s'\n", tt.Expression)
+		l := lexer.New(tt.Expression)
+		program := parser.New(&l).Parse(token.EOF)
+		env := environment.New()
+		obj := Eval(&program, &env)
+
+		if !cmp.Equal(obj, tt.ExpectedObj) {
+			t.Errorf("expected object to be %+v, instead got %+v\n", tt.ExpectedObj, obj)
+		}
+	}
+}
+
+func TestEvalCallExpression(t *testing.T) {
+	tests := []struct {
+		Expression  string
+		ExpectedObj object.Object
+	}{
+		{
+			Expression:  "fn make_adder(x) { return fn (y) { return x + y; }; } make_adder(1)(2)",
+			ExpectedObj: &object.Integer{Value: 3},
+		},
+		{
+			Expression:  "let f = fn (x) { return x * 2; }; let g = fn (x) { return x * 3; }; (if 1 > 2 { f } else { g })(5)",
+			ExpectedObj: &object.Integer{Value: 15},
+		},
+		{
+			Expression:  "fn (x) { return x; }(7)",
+			ExpectedObj: &object.Integer{Value: 7},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("evaluating: '%s'\n", tt.Expression)
+		l := lexer.New(tt.Expression)
+		program := parser.New(&l).Parse(token.EOF)
+		env := environment.New()
+		obj := Eval(&program, &env)
+
+		if !cmp.Equal(obj, tt.ExpectedObj) {
+			t.Errorf("expected object to be %+v, instead got %+v\n", tt.ExpectedObj, obj)
+		}
+	}
+}
+
+func TestEvalArray(t *testing.T) {
+	tests := []struct {
+		Expression  string
+		ExpectedObj object.Object
+	}{
+		{
+			Expression: "[1, 2 * 2, \"foo\"]",
+			ExpectedObj: &object.Array{Elements: []object.Object{
+				&object.Integer{Value: 1},
+				&object.Integer{Value: 4},
+				&object.String{Value: "foo"},
+			}},
+		},
+		{
+			Expression:  "[]",
+			ExpectedObj: &object.Array{Elements: []object.Object{}},
+		},
+		{
+			Expression:  "let a = [1, 2, 3]; a[1 + 1]",
+			ExpectedObj: &object.Integer{Value: 3},
+		},
+		{
+			Expression:  "let ops = [fn (a, b) { return a + b; }]; ops[0](1, 2)",
+			ExpectedObj: &object.Integer{Value: 3},
+		},
+		{
+			Expression:  "[[1, 2], [3, 4]][1][0]",
+			ExpectedObj: &object.Integer{Value: 3},
+		},
+		{
+			Expression:  "len([1, 2, 3]) + len(\"foo\")",
+			ExpectedObj: &object.Integer{Value: 6},
+		},
+		{
+			Expression: "let a = [1]; push(a, 2, 3); a",
+			ExpectedObj: &object.Array{Elements: []object.Object{
+				&object.Integer{Value: 1},
+				&object.Integer{Value: 2},
+				&object.Integer{Value: 3},
+			}},
+		},
+		{
+			Expression:  "first([1, 2, 3]) + last([1, 2, 3])",
+			ExpectedObj: &object.Integer{Value: 4},
+		},
+		{
+			Expression:  "first([])",
+			ExpectedObj: &object.Null{},
+		},
+		{
+			Expression: "rest([1, 2, 3])",
+			ExpectedObj: &object.Array{Elements: []object.Object{
+				&object.Integer{Value: 2},
+				&object.Integer{Value: 3},
+			}},
+		},
+		{
+			Expression: "slice([1, 2, 3, 4], 1, 3)",
+			ExpectedObj: &object.Array{Elements: []object.Object{
+				&object.Integer{Value: 2},
+				&object.Integer{Value: 3},
+			}},
+		},
+		{
+			Expression: "slice([1, 2, 3, 4], 3)",
+			ExpectedObj: &object.Array{Elements: []object.Object{
+				&object.Integer{Value: 4},
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("evaluating: '%s'\n", tt.Expression)
+		l := lexer.New(tt.Expression)
+		program := parser.New(&l).Parse(token.EOF)
+		env := environment.New()
+		obj := Eval(&program, &env)
+
+		if !cmp.Equal(obj, tt.ExpectedObj) {
+			t.Errorf("expected object to be %+v, instead got %+v\n", tt.ExpectedObj, obj)
+		}
+	}
+}
+
+func TestEvalArrayErrors(t *testing.T) {
+	tests := []struct {
+		Expression    string
+		ExpectedError string
+	}{
+		{
+			Expression:    "[1, 2, 3][3]",
+			ExpectedError: "array index out of range: 3 with length 3",
+		},
+		{
+			Expression:    "[1, 2, 3][-1]",
+			ExpectedError: "negative array index: -1",
+		},
+		{
+			Expression:    "[1][true]",
+			ExpectedError: "array index must be INT, instead got BOOL",
+		},
+		{
+			Expression:    "len(1)",
+			ExpectedError: "len() is not supported for INT",
+		},
+		{
+			Expression:    "push([])",
+			ExpectedError: "push() takes at least 2 arguments, instead got 1",
+		},
+		{
+			Expression:    "slice([1, 2], 1, 5)",
+			ExpectedError: "slice bounds out of range: [1:5] with length 2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("evaluating: '%s'\n", tt.Expression)
+		l := lexer.New(tt.Expression)
+		program := parser.New(&l).Parse(token.EOF)
+		env := environment.New()
+		obj := Eval(&program, &env)
+
+		err, ok := obj.(*object.Error)
+		if !ok {
+			t.Errorf("expected object.Error, instead got %+v\n", obj)
+			continue
+		}
+
+		if err.Inspect() != tt.ExpectedError {
+			t.Errorf("expected error '%s', instead got '%s'\n", tt.ExpectedError, err.Inspect())
+		}
+	}
+}
+
+func TestEvalHash(t *testing.T) {
+	tests := []struct {
+		Expression  string
+		ExpectedObj object.Object
+	}{
+		{
+			Expression:  "let h = {\"name\": \"x\", 1: true, false: 2}; h[\"name\"]",
+			ExpectedObj: &object.String{Value: "x"},
+		},
+		{
+			Expression:  "let h = {\"name\": \"x\", 1: true, false: 2}; h[1]",
+			ExpectedObj: &object.Boolean{Value: true},
+		},
+		{
+			Expression:  "let h = {\"name\": \"x\", 1: true, false: 2}; h[1 > 2]",
+			ExpectedObj: &object.Integer{Value: 2},
+		},
+		{
+			Expression:  "{}[\"missing\"]",
+			ExpectedObj: &object.Null{},
+		},
+		{
+			Expression:  "let h = {}; h[\"a\"] = 1; h[\"a\"] = h[\"a\"] + 1; h[\"a\"]",
+			ExpectedObj: &object.Integer{Value: 2},
+		},
+		{
+			Expression:  "let h = {\"greet\": fn (name) { return \"hi \" + name; }}; h.greet(\"bob\")",
+			ExpectedObj: &object.String{Value: "hi bob"},
+		},
+		{
+			Expression:  "let h = {}; h.count = 3; h[\"count\"]",
+			ExpectedObj: &object.Integer{Value: 3},
+		},
+		{
+			Expression:  "let a = [1, 2]; a[0] = 5; a[0] + a[1]",
+			ExpectedObj: &object.Integer{Value: 7},
+		},
+		{
+			Expression: "keys({\"b\": 1, \"a\": 2})",
+			ExpectedObj: &object.Array{Elements: []object.Object{
+				&object.String{Value: "b"},
+				&object.String{Value: "a"},
+			}},
+		},
+		{
+			Expression: "values({\"b\": 1, \"a\": 2})",
+			ExpectedObj: &object.Array{Elements: []object.Object{
+				&object.Integer{Value: 1},
+				&object.Integer{Value: 2},
+			}},
+		},
+		{
+			Expression:  "has({\"a\": 1}, \"a\")",
+			ExpectedObj: &object.Boolean{Value: true},
+		},
+		{
+			Expression:  "has({\"a\": 1}, \"b\")",
+			ExpectedObj: &object.Boolean{Value: false},
+		},
+		{
+			Expression:  "let h = {\"a\": 1, \"b\": 2}; delete(h, \"a\"); len(h)",
+			ExpectedObj: &object.Integer{Value: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("evaluating: '%s'\n", tt.Expression)
+		l := lexer.New(tt.Expression)
+		program := parser.New(&l).Parse(token.EOF)
+		env := environment.New()
+		obj := Eval(&program, &env)
+
+		if !cmp.Equal(obj, tt.ExpectedObj) {
+			t.Errorf("expected object to be %+v, instead got %+v\n", tt.ExpectedObj, obj)
+		}
+	}
+}
+
+func TestEvalLoops(t *testing.T) {
+	tests := []struct {
+		Expression  string
+		ExpectedObj object.Object
+	}{
+		{
+			Expression:  "let c = [0]; while c[0] < 5 { c[0] = c[0] + 1; } c[0]",
+			ExpectedObj: &object.Integer{Value: 5},
+		},
+		{
+			Expression:  "while false { 1 }",
+			ExpectedObj: &object.Null{},
+		},
+		{
+			Expression:  "let sum = [0]; for x in [1, 2, 3] { sum[0] = sum[0] + x; } sum[0]",
+			ExpectedObj: &object.Integer{Value: 6},
+		},
+		{
+			Expression:  "let res = []; for c in \"héllo\" { push(res, c); } res",
+			ExpectedObj: &object.Array{Elements: []object.Object{&object.String{Value: "h"}, &object.String{Value: "é"}, &object.String{Value: "l"}, &object.String{Value: "l"}, &object.String{Value: "o"}}},
+		},
+		{
+			Expression:  "let res = []; for i in range(3) { push(res, i); } res",
+			ExpectedObj: &object.Array{Elements: []object.Object{&object.Integer{Value: 0}, &object.Integer{Value: 1}, &object.Integer{Value: 2}}},
+		},
+		{
+			Expression:  "let res = []; for i in range(10, 0, -4) { push(res, i); } res",
+			ExpectedObj: &object.Array{Elements: []object.Object{&object.Integer{Value: 10}, &object.Integer{Value: 6}, &object.Integer{Value: 2}}},
+		},
+		{
+			Expression:  "let res = []; for i in range(9223372036854775806, 9223372036854775807, 2) { push(res, i); } len(res)",
+			ExpectedObj: &object.Integer{Value: 1},
+		},
+		{
+			Expression:  "let res = []; for k in {\"b\": 1, \"a\": 2} { push(res, k); } res",
+			ExpectedObj: &object.Array{Elements: []object.Object{&object.String{Value: "b"}, &object.String{Value: "a"}}},
+		},
+		{
+			Expression:  "let h = {1: 1, 2: 2, 3: 3}; let res = []; for k in h { delete(h, 2); push(res, k); } res",
+			ExpectedObj: &object.Array{Elements: []object.Object{&object.Integer{Value: 1}, &object.Integer{Value: 3}}},
+		},
+		{
+			Expression:  "let res = []; for i in range(10) { if i == 3 { break; } push(res, i); } res",
+			ExpectedObj: &object.Array{Elements: []object.Object{&object.Integer{Value: 0}, &object.Integer{Value: 1}, &object.Integer{Value: 2}}},
+		},
+		{
+			Expression:  "let res = []; for i in range(4) { if i % 2 == 0 { continue; } push(res, i); } res",
+			ExpectedObj: &object.Array{Elements: []object.Object{&object.Integer{Value: 1}, &object.Integer{Value: 3}}},
+		},
+		{
+			Expression:  "let res = []; for i in range(3) { for j in range(3) { if j == 1 { break; } push(res, j); } } len(res)",
+			ExpectedObj: &object.Integer{Value: 3},
+		},
+		{
+			Expression:  "fn find(arr, x) { for i in range(len(arr)) { if arr[i] == x { return i; } } return -1; } find([5, 6, 7], 7)",
+			ExpectedObj: &object.Integer{Value: 2},
+		},
+		{
+			Expression:  "let fns = []; for i in range(3) { push(fns, fn() { return i; }); } fns[1]()",
+			ExpectedObj: &object.Integer{Value: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("evaluating: '%s'\n", tt.Expression)
+		l := lexer.New(tt.Expression)
+		program := parser.New(&l).Parse(token.EOF)
+		env := environment.New()
+		obj := Eval(&program, &env)
+
+		if !cmp.Equal(obj, tt.ExpectedObj) {
+			t.Errorf("expected object to be %+v, instead got %+v\n", tt.ExpectedObj, obj)
+		}
+	}
+}
+
+func TestEvalLoopErrors(t *testing.T) {
+	tests := []struct {
+		Expression    string
+		ExpectedError string
+	}{
+		{
+			Expression:    "for x in 10 { x }",
+			ExpectedError: "cannot iterate over INT",
+		},
+		{
+			Expression:    "for i in range(3) { -true; }",
+			ExpectedError: "unknown operator: -BOOL",
+		},
+		{
+			Expression:    "range(0, 10, 0)",
+			ExpectedError: "range() step must not be zero",
+		},
+		{
+			Expression:    "if true { break; }",
+			ExpectedError: "break outside of a loop",
+		},
+		{
+			Expression:    "fn foo() { continue; } for i in range(3) { foo(); }",
+			ExpectedError: "continue outside of a loop",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("evaluating: '%s'\n", tt.Expression)
+		l := lexer.New(tt.Expression)
+		program := parser.New(&l).Parse(token.EOF)
+		env := environment.New()
+		obj := Eval(&program, &env)
+
+		err, ok := obj.(*object.Error)
+		if !ok {
+			t.Errorf("expected object.Error, instead got %+v\n", obj)
+			continue
+		}
+
+		if err.Inspect() != tt.ExpectedError {
+			t.Errorf("expected error '%s', instead got '%s'\n", tt.ExpectedError, err.Inspect())
+		}
+	}
+}
+
+func TestEvalAssignment(t *testing.T) {
+	tests := []struct {
+		Expression  string
+		ExpectedObj object.Object
+	}{
+		{
+			Expression:  "let x = 1; x = 2; x",
+			ExpectedObj: &object.Integer{Value: 2},
+		},
+		{
+			Expression:  "let x = 1; x = 5",
+			ExpectedObj: &object.Integer{Value: 5},
+		},
+		{
+			Expression:  "let x = 1; if true { x = 2; } x",
+			ExpectedObj: &object.Integer{Value: 2},
+		},
+		{
+			Expression:  "let x = 1; if true { let x = 10; x = 2; } x",
+			ExpectedObj: &object.Integer{Value: 1},
+		},
+		{
+			Expression:  "let i = 0; let sum = 0; while i < 5 { i += 1; sum += i; } sum",
+			ExpectedObj: &object.Integer{Value: 15},
+		},
+		{
+			Expression:  "let x = 10; x -= 3; x *= 2; x /= 7; x",
+			ExpectedObj: &object.Integer{Value: 2},
+		},
+		{
+			Expression:  "let x = 10; x %= 4; x",
+			ExpectedObj: &object.Integer{Value: 2},
+		},
+		{
+			Expression:  "let s = \"foo\"; s += \"bar\"; s",
+			ExpectedObj: &object.String{Value: "foobar"},
+		},
+		{
+			Expression:  "let a = [1, 2]; a[1] += 10; a",
+			ExpectedObj: &object.Array{Elements: []object.Object{&object.Integer{Value: 1}, &object.Integer{Value: 12}}},
+		},
+		{
+			Expression:  "let h = {\"n\": 1}; h.n *= 3; h[\"n\"]",
+			ExpectedObj: &object.Integer{Value: 3},
+		},
+		{
+			Expression:  "let x = 1; let y = 2; x = y = 3; x + y",
+			ExpectedObj: &object.Integer{Value: 6},
+		},
+		{
+			Expression:  "fn counter() { let n = 0; return fn() { n += 1; return n; }; } let c = counter(); c(); c(); c()",
+			ExpectedObj: &object.Integer{Value: 3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("evaluating: '%s'\n", tt.Expression)
+		l := lexer.New(tt.Expression)
+		program := parser.New(&l).Parse(token.EOF)
+		env := environment.New()
+		obj := Eval(&program, &env)
+
+		if !cmp.Equal(obj, tt.ExpectedObj) {
+			t.Errorf("expected object to be %+v, instead got %+v\n", tt.ExpectedObj, obj)
+		}
+	}
+}
+
+func TestEvalAssignmentErrors(t *testing.T) {
+	tests := []struct {
+		Expression    string
+		ExpectedError string
+	}{
+		{
+			Expression:    "x = 1",
+			ExpectedError: "cannot assign to undeclared variable 'x'",
+		},
+		{
+			Expression:    "if true { let x = 1; } x += 1",
+			ExpectedError: "cannot assign to undeclared variable 'x'",
+		},
+		{
+			Expression:    "let x = 1; x += true",
+			ExpectedError: "type mismatch: INT + BOOL",
+		},
+		{
+			Expression:    "let x = 1; x /= 0",
+			ExpectedError: "division by zero",
+		},
+		{
+			Expression:    "let a = [1]; a[1] += 1",
+			ExpectedError: "array index out of range: 1 with length 1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("evaluating: '%s'\n", tt.Expression)
+		l := lexer.New(tt.Expression)
+		program := parser.New(&l).Parse(token.EOF)
+		env := environment.New()
+		obj := Eval(&program, &env)
+
+		err, ok := obj.(*object.Error)
+		if !ok {
+			t.Errorf("expected object.Error, instead got %+v\n", obj)
+			continue
+		}
+
+		if err.Inspect() != tt.ExpectedError {
+			t.Errorf("expected error '%s', instead got '%s'\n", tt.ExpectedError, err.Inspect())
+		}
+	}
+}
+
+func TestEvalLogicalExpression(t *testing.T) {
+	tests := []struct {
+		Expression  string
+		ExpectedObj object.Object
+	}{
+		{
+			Expression:  "true && false",
+			ExpectedObj: &object.Boolean{Value: false},
+		},
+		{
+			Expression:  "false || true",
+			ExpectedObj: &object.Boolean{Value: true},
+		},
+		{
+			Expression:  "1 < 2 && 2 < 3",
+			ExpectedObj: &object.Boolean{Value: true},
+		},
+		{
+			Expression:  "true || false && false",
+			ExpectedObj: &object.Boolean{Value: true},
+		},
+		{
+			Expression:  "(true || false) && false",
+			ExpectedObj: &object.Boolean{Value: false},
+		},
+		{
+			Expression:  "1 && \"a\"",
+			ExpectedObj: &object.Boolean{Value: true},
+		},
+		{
+			Expression:  "0 || \"\"",
+			ExpectedObj: &object.Boolean{Value: false},
+		},
+		{
+			Expression:  "false && 1 + true",
+			ExpectedObj: &object.Boolean{Value: false},
+		},
+		{
+			Expression:  "true || undefined()",
+			ExpectedObj: &object.Boolean{Value: true},
+		},
+		{
+			Expression:  "let calls = 0; fn f() { calls += 1; return true; } f() || f(); calls",
+			ExpectedObj: &object.Integer{Value: 1},
+		},
+		{
+			Expression:  "let x = false || true; x",
+			ExpectedObj: &object.Boolean{Value: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("evaluating: '%s'\n", tt.Expression)
+		l := lexer.New(tt.Expression)
+		program := parser.New(&l).Parse(token.EOF)
+		env := environment.New()
+		obj := Eval(&program, &env)
+
+		if !cmp.Equal(obj, tt.ExpectedObj) {
+			t.Errorf("expected object to be %+v, instead got %+v\n", tt.ExpectedObj, obj)
+		}
+	}
+}
+
+func TestEvalTruthiness(t *testing.T) {
+	tests := []struct {
+		Expression  string
+		ExpectedObj object.Object
+	}{
+		{
+			Expression:  "if 0 { 1 } else { 2 }",
+			ExpectedObj: &object.Integer{Value: 2},
+		},
+		{
+			Expression:  "if -1 { 1 } else { 2 }",
+			ExpectedObj: &object.Integer{Value: 1},
+		},
+		{
+			Expression:  "if \"\" { 1 } else if [0] { 2 }",
+			ExpectedObj: &object.Integer{Value: 2},
+		},
+		{
+			Expression:  "if {} { 1 } else if range(0) { 2 } else { 3 }",
+			ExpectedObj: &object.Integer{Value: 3},
+		},
+		{
+			Expression:  "fn nothing() {} if nothing() { 1 } else { 2 }",
+			ExpectedObj: &object.Integer{Value: 2},
+		},
+		{
+			Expression:  "if fn() {} { 1 }",
+			ExpectedObj: &object.Integer{Value: 1},
+		},
+		{
+			Expression:  "let n = 3; let res = 0; while n { res += n; n -= 1; } res",
+			ExpectedObj: &object.Integer{Value: 6},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("evaluating: '%s'\n", tt.Expression)
+		l := lexer.New(tt.Expression)
+		program := parser.New(&l).Parse(token.EOF)
+		env := environment.New()
+		obj := Eval(&program, &env)
+
+		if !cmp.Equal(obj, tt.ExpectedObj) {
+			t.Errorf("expected object to be %+v, instead got %+v\n", tt.ExpectedObj, obj)
+		}
+	}
+}
+
+func TestEvalStrict(t *testing.T) {
+	tests := []struct {
+		Expression    string
+		ExpectedError string
+	}{
+		{
+			Expression:    "!1",
+			ExpectedError: "expected boolean, instead got '1'",
+		},
+		{
+			Expression:    "if 1 { 1 }",
+			ExpectedError: "expected boolean, instead got '1'",
+		},
+		{
+			Expression:    "if false { 1 } else if \"a\" { 2 }",
+			ExpectedError: "expected boolean, instead got 'a'",
+		},
+		{
+			Expression:    "while 1 { 1 }",
+			ExpectedError: "expected boolean, instead got '1'",
+		},
+		{
+			Expression:    "true && 1",
+			ExpectedError: "expected boolean, instead got '1'",
+		},
+		{
+			Expression:    "[] || true",
+			ExpectedError: "expected boolean, instead got '[]'",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("evaluating: '%s'\n", tt.Expression)
+		l := lexer.New(tt.Expression)
+		program := parser.New(&l).Parse(token.EOF)
+		env := environment.New()
+		e := New()
+		e.Strict = true
+		obj := e.Eval(&program, &env)
+
+		err, ok := obj.(*object.Error)
+		if !ok {
+			t.Errorf("expected object.Error, instead got %+v\n", obj)
+			continue
+		}
+
+		if err.Inspect() != tt.ExpectedError {
+			t.Errorf("expected error '%s', instead got '%s'\n", tt.ExpectedError, err.Inspect())
+		}
+	}
+}
+
+func TestEvalFloat(t *testing.T) {
+	tests := []struct {
+		Expression  string
+		ExpectedObj object.Object
+	}{
+		{
+			Expression:  "1.5 + 2.25",
+			ExpectedObj: &object.Float{Value: 3.75},
+		},
+		{
+			Expression:  "1 + 0.5",
+			ExpectedObj: &object.Float{Value: 1.5},
+		},
+		{
+			Expression:  "7 / 2.0",
+			ExpectedObj: &object.Float{Value: 3.5},
+		},
+		{
+			Expression:  "7 / 2",
+			ExpectedObj: &object.Integer{Value: 3},
+		},
+		{
+			Expression:  "-2.5 * 2",
+			ExpectedObj: &object.Float{Value: -5},
+		},
+		{
+			Expression:  "5.5 % 2",
+			ExpectedObj: &object.Float{Value: 1.5},
+		},
+		{
+			Expression:  "1 == 1.0",
+			ExpectedObj: &object.Boolean{Value: true},
+		},
+		{
+			Expression:  "2 > 1.5",
+			ExpectedObj: &object.Boolean{Value: true},
+		},
+		{
+			Expression:  "0.1 + 0.2 != 0.3",
+			ExpectedObj: &object.Boolean{Value: true},
+		},
+		{
+			Expression:  "if 0.0 { 1 } else { 2 }",
+			ExpectedObj: &object.Integer{Value: 2},
+		},
+		{
+			Expression:  "let x = 1; x += 0.5; x",
+			ExpectedObj: &object.Float{Value: 1.5},
+		},
+		{
+			Expression:  "int(3.99)",
+			ExpectedObj: &object.Integer{Value: 3},
+		},
+		{
+			Expression:  "int(-3.99)",
+			ExpectedObj: &object.Integer{Value: -3},
+		},
+		{
+			Expression:  "int(\" 42 \")",
+			ExpectedObj: &object.Integer{Value: 42},
+		},
+		{
+			Expression:  "float(2)",
+			ExpectedObj: &object.Float{Value: 2},
+		},
+		{
+			Expression:  "float(\"1e-3\")",
+			ExpectedObj: &object.Float{Value: 0.001},
+		},
+		{
+			Expression:  "{1.5: \"a\"}[1.5]",
+			ExpectedObj: &object.String{Value: "a"},
+		},
+		{
+			Expression:  "{1: \"a\"}[1.0]",
+			ExpectedObj: &object.String{Value: "a"},
+		},
+		{
+			Expression:  "{2.0: \"b\"}[2]",
+			ExpectedObj: &object.String{Value: "b"},
+		},
+		{
+			Expression:  "{0: \"z\"}[-0.0]",
+			ExpectedObj: &object.String{Value: "z"},
+		},
+		{
+			Expression:  "len({1: \"a\", 1.0: \"b\"})",
+			ExpectedObj: &object.Integer{Value: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("evaluating: '%s'\n", tt.Expression)
+		l := lexer.New(tt.Expression)
+		program := parser.New(&l).Parse(token.EOF)
+		env := environment.New()
+		obj := Eval(&program, &env)
+
+		if !cmp.Equal(obj, tt.ExpectedObj) {
+			t.Errorf("expected object to be %+v, instead got %+v\n", tt.ExpectedObj, obj)
+		}
+	}
+}
+
+func TestEvalFloatErrors(t *testing.T) {
+	tests := []struct {
+		Expression    string
+		ExpectedError string
+	}{
+		{
+			Expression:    "1.5 / 0",
+			ExpectedError: "division by zero",
+		},
+		{
+			Expression:    "1.5 + \"a\"",
+			ExpectedError: "type mismatch: FLOAT + STRING",
+		},
+		{
+			Expression:    "int(\"abc\")",
+			ExpectedError: "cannot convert \"abc\" to INT",
+		},
+		{
+			Expression:    "int(1e30)",
+			ExpectedError: "cannot convert 1e+30 to INT",
+		},
+		{
+			Expression:    "float([])",
+			ExpectedError: "float() is not supported for ARRAY",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("evaluating: '%s'\n", tt.Expression)
+		l := lexer.New(tt.Expression)
+		program := parser.New(&l).Parse(token.EOF)
+		env := environment.New()
+		obj := Eval(&program, &env)
+
+		err, ok := obj.(*object.Error)
+		if !ok {
+			t.Errorf("expected object.Error, instead got %+v\n", obj)
+			continue
+		}
+
+		if err.Inspect() != tt.ExpectedError {
+			t.Errorf("expected error '%s', instead got '%s'\n", tt.ExpectedError, err.Inspect())
+		}
+	}
+}
+
+func TestEvalBitwise(t *testing.T) {
+	tests := []struct {
+		Expression  string
+		ExpectedObj object.Object
+	}{
+		{
+			Expression:  "12 & 10",
+			ExpectedObj: &object.Integer{Value: 8},
+		},
+		{
+			Expression:  "12 | 10",
+			ExpectedObj: &object.Integer{Value: 14},
+		},
+		{
+			Expression:  "12 ^ 10",
+			ExpectedObj: &object.Integer{Value: 6},
+		},
+		{
+			Expression:  "~0",
+			ExpectedObj: &object.Integer{Value: -1},
+		},
+		{
+			Expression:  "1 << 10",
+			ExpectedObj: &object.Integer{Value: 1024},
+		},
+		{
+			Expression:  "-16 >> 2",
+			ExpectedObj: &object.Integer{Value: -4},
+		},
+		{
+			Expression:  "1 >> 64",
+			ExpectedObj: &object.Integer{Value: 0},
+		},
+		{
+			Expression:  "0 << 100",
+			ExpectedObj: &object.Integer{Value: 0},
+		},
+		{
+			Expression:  "let flags = 0; flags = flags | 1 << 3; flags & 8 != 0",
+			ExpectedObj: &object.Boolean{Value: true},
+		},
+		{
+			Expression:  "2 ** 10",
+			ExpectedObj: &object.Integer{Value: 1024},
+		},
+		{
+			Expression:  "2 ** 3 ** 2",
+			ExpectedObj: &object.Integer{Value: 512},
+		},
+		{
+			Expression:  "-2 ** 2",
+			ExpectedObj: &object.Integer{Value: -4},
+		},
+		{
+			Expression:  "(-2) ** 63",
+			ExpectedObj: &object.Integer{Value: math.MinInt64},
+		},
+		{
+			Expression:  "5 ** 0",
+			ExpectedObj: &object.Integer{Value: 1},
+		},
+		{
+			Expression:  "2 ** -1",
+			ExpectedObj: &object.Float{Value: 0.5},
+		},
+		{
+			Expression:  "2.0 ** 0.5 == float(2) ** 0.5",
+			ExpectedObj: &object.Boolean{Value: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("evaluating: '%s'\n", tt.Expression)
+		l := lexer.New(tt.Expression)
+		program := parser.New(&l).Parse(token.EOF)
+		env := environment.New()
+		obj := Eval(&program, &env)
+
+		if !cmp.Equal(obj, tt.ExpectedObj) {
+			t.Errorf("expected object to be %+v, instead got %+v\n", tt.ExpectedObj, obj)
+		}
+	}
+}
+
+func TestEvalBitwiseErrors(t *testing.T) {
+	tests := []struct {
+		Expression    string
+		ExpectedError string
+	}{
+		{
+			Expression:    "1 << -1",
+			ExpectedError: "negative shift count: -1",
+		},
+		{
+			Expression:    "8 >> -2",
+			ExpectedError: "negative shift count: -2",
+		},
+		{
+			Expression:    "1.5 & 1",
+			ExpectedError: "unknown operator: FLOAT & FLOAT",
+		},
+		{
+			Expression:    "~true",
+			ExpectedError: "unknown operator: ~BOOL",
+		},
+		{
+			Expression:    "\"a\" ** 2",
+			ExpectedError: "type mismatch: STRING ** INT",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("evaluating: '%s'\n", tt.Expression)
+		l := lexer.New(tt.Expression)
+		program := parser.New(&l).Parse(token.EOF)
+		env := environment.New()
+		obj := Eval(&program, &env)
+
+		err, ok := obj.(*object.Error)
+		if !ok {
+			t.Errorf("expected object.Error, instead got %+v\n", obj)
+			continue
+		}
+
+		if err.Inspect() != tt.ExpectedError {
+			t.Errorf("expected error '%s', instead got '%s'\n", tt.ExpectedError, err.Inspect())
+		}
+	}
+}
+
+func TestInspectFloat(t *testing.T) {
+	tests := []struct {
+		Value    float64
+		Expected string
+	}{
+		{3.14, "3.14"},
+		{2, "2.0"},
+		{-0.5, "-0.5"},
+		{1e-9, "1e-09"},
+		{1e21, "1e+21"},
+		{123456789, "123456789.0"},
+		{math.Nextafter(0.3, 1), "0.30000000000000004"},
+		{0.000001, "0.000001"},
+	}
+
+	for _, tt := range tests {
+		obj := &object.Float{Value: tt.Value}
+		if obj.Inspect() != tt.Expected {
+			t.Errorf("expected %v to be inspected as '%s', instead got '%s'\n", tt.Value, tt.Expected, obj.Inspect())
+		}
+
+		// What Inspect prints must evaluate back to the same float
+		l := lexer.New(obj.Inspect())
+		program := parser.New(&l).Parse(token.EOF)
+		env := environment.New()
+		res := Eval(&program, &env)
+		if !cmp.Equal(res, obj) {
+			t.Errorf("expected '%s' to evaluate to %+v, instead got %+v\n", obj.Inspect(), obj, res)
+		}
+	}
+}
+
+func TestInspectHash(t *testing.T) {
+	l := lexer.New("let h = {\"name\": \"x\", 1: [true, \"y\"]}; h[2] = {}; h")
+	program := parser.New(&l).Parse(token.EOF)
+	env := environment.New()
+	obj := Eval(&program, &env)
+
+	expected := "{\"name\": \"x\", 1: [true, \"y\"], 2: {}}"
+	if obj.Inspect() != expected {
+		t.Errorf("expected %s, instead got %s\n", expected, obj.Inspect())
+	}
+}
+
+func TestEvalBuiltins(t *testing.T) {
+	tests := []struct {
+		Expression  string
+		ExpectedObj object.Object
+	}{
+		{
+			Expression:  "type(1)",
+			ExpectedObj: &object.String{Value: "INT"},
+		},
+		{
+			Expression:  "type(\"a\") == type(str(1.5))",
+			ExpectedObj: &object.Boolean{Value: true},
+		},
+		{
+			Expression:  "type(len)",
+			ExpectedObj: &object.String{Value: "BUILTIN"},
+		},
+		{
+			Expression:  "str([1, \"a\"])",
+			ExpectedObj: &object.String{Value: "[1, \"a\"]"},
+		},
+		{
+			Expression:  "str(\"a\")",
+			ExpectedObj: &object.String{Value: "a"},
+		},
+		{
+			Expression:  "int(str(42)) + 1",
+			ExpectedObj: &object.Integer{Value: 43},
+		},
+		{
+			Expression:  "let type = 1; type",
+			ExpectedObj: &object.Integer{Value: 1},
+		},
+		{
+			Expression:  "exit(2)",
+			ExpectedObj: &object.Exit{Code: 2},
+		},
+		{
+			Expression:  "fn f() { for x in range(10) { if x == 3 { exit(x); } } return 0; } f(); 1",
+			ExpectedObj: &object.Exit{Code: 3},
+		},
+		{
+			Expression:  "let a = [exit()]; 1",
+			ExpectedObj: &object.Exit{Code: 0},
+		},
+		{
+			Expression:  "let x = 1; x += 1 + exit(4); x",
+			ExpectedObj: &object.Exit{Code: 4},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("evaluating: '%s'\n", tt.Expression)
+		l := lexer.New(tt.Expression)
+		program := parser.New(&l).Parse(token.EOF)
+		env := environment.New()
+		obj := Eval(&program, &env)
+
+		if !cmp.Equal(obj, tt.ExpectedObj) {
+			t.Errorf("expected object to be %+v, instead got %+v\n", tt.ExpectedObj, obj)
+		}
+	}
+}
+
+func TestEvalInputOutput(t *testing.T) {
+	tests := []struct {
+		Expression     string
+		Input          string
+		ExpectedOutput string
+		ExpectedObj    object.Object
+	}{
+		{
+			Expression:     "print(1, \"a\", [\"b\"]); print(true)",
+			ExpectedOutput: "1 a [\"b\"]true",
+			ExpectedObj:    &NULL,
+		},
+		{
+			Expression:     "println(\"a\"); println(); println(1, 2)",
+			ExpectedOutput: "a\n\n1 2\n",
+			ExpectedObj:    &NULL,
+		},
+		{
+			Expression:     "let name = input(\"name: \"); println(\"hi \" + name)",
+			Input:          "bob\r\n",
+			ExpectedOutput: "name: hi bob\n",
+			ExpectedObj:    &NULL,
+		},
+		{
+			Expression:     "[input(), input(), input()]",
+			Input:          "a\nb",
+			ExpectedOutput: "",
+			ExpectedObj: &object.Array{Elements: []object.Object{
+				&object.String{Value: "a"},
+				&object.String{Value: "b"},
+				&NULL,
+			}},
+		},
+		{
+			Expression:     "println(\"before\"); exit(1); println(\"after\")",
+			ExpectedOutput: "before\n",
+			ExpectedObj:    &object.Exit{Code: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("evaluating: '%s'\n", tt.Expression)
+		l := lexer.New(tt.Expression)
+		program := parser.New(&l).Parse(token.EOF)
+		env := environment.New()
+		var out bytes.Buffer
+		e := New()
+		e.Stdout = &out
+		e.Stdin = strings.NewReader(tt.Input)
+		obj := e.Eval(&program, &env)
+
+		if out.String() != tt.ExpectedOutput {
+			t.Errorf("expected output %q, instead got %q\n", tt.ExpectedOutput, out.String())
+		}
+
+		if !cmp.Equal(obj, tt.ExpectedObj) {
+			t.Errorf("expected object to be %+v, instead got %+v\n", tt.ExpectedObj, obj)
+		}
+	}
+}
+
+func TestEvalBuiltinErrors(t *testing.T) {
+	tests := []struct {
+		Expression    string
+		ExpectedError string
+	}{
+		{
+			Expression:    "type()",
+			ExpectedError: "type() takes 1 arguments, instead got 0",
+		},
+		{
+			Expression:    "str(1, 2)",
+			ExpectedError: "str() takes 1 arguments, instead got 2",
+		},
+		{
+			Expression:    "exit(\"a\")",
+			ExpectedError: "argument 1 of exit() must be INT, instead got STRING",
+		},
+		{
+			Expression:    "input(1, 2)",
+			ExpectedError: "input() takes from 0 to 1 arguments, instead got 2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("evaluating: '%s'\n", tt.Expression)
+		l := lexer.New(tt.Expression)
+		program := parser.New(&l).Parse(token.EOF)
+		env := environment.New()
+		obj := Eval(&program, &env)
+
+		err, ok := obj.(*object.Error)
+		if !ok {
+			t.Errorf("expected object.Error, instead got %+v\n", obj)
+			continue
+		}
+
+		if err.Inspect() != tt.ExpectedError {
+			t.Errorf("expected error '%s', instead got '%s'\n", tt.ExpectedError, err.Inspect())
+		}
+	}
+}
+
+func TestRegisterBuiltin(t *testing.T) {
+	e := New()
+	e.RegisterBuiltin("double", func(args ...object.Object) object.Object {
+		return &object.Integer{Value: args[0].(*object.Integer).Value * 2}
+	})
+
+	l := lexer.New("double(21)")
+	program := parser.New(&l).Parse(token.EOF)
+	env := environment.New()
+
+	if obj := e.Eval(&program, &env); !cmp.Equal(obj, &object.Integer{Value: 42}) {
+		t.Errorf("expected object to be %+v, instead got %+v\n", &object.Integer{Value: 42}, obj)
+	}
+
+	// Builtins are registered per evaluator
+	if obj := Eval(&program, &env); !isError(obj) {
+		t.Errorf("expected an error, instead got %+v\n", obj)
+	}
+}
+
+func TestEvalLimits(t *testing.T) {
+	tests := []struct {
+		Expression     string
+		MaxSteps       int64
+		MaxDepth       int
+		MaxAllocations int64
+		Overflow       OverflowPolicy
+		ExpectedKind   object.ErrorKind
+		ExpectedErr    error
+	}{
+		{
+			Expression:   "while true {}",
+			MaxSteps:     1000,
+			ExpectedKind: object.StepLimitError,
+			ExpectedErr:  ErrStepLimit,
+		},
+		{
+			Expression:   "for x in range(1000000) {}",
+			MaxSteps:     1000,
+			ExpectedKind: object.StepLimitError,
+			ExpectedErr:  ErrStepLimit,
+		},
+		{
+			Expression:   "fn f(n) { return f(n + 1); } f(0)",
+			MaxDepth:     100,
+			ExpectedKind: object.DepthLimitError,
+			ExpectedErr:  ErrDepthLimit,
+		},
+		{
+			Expression:   "fn f(n) { return f(n + 1); } f(0)",
+			ExpectedKind: object.DepthLimitError,
+			ExpectedErr:  ErrDepthLimit,
+		},
+		{
+			Expression:     "let a = []; while true { push(a, [1, 2]); }",
+			MaxAllocations: 1000,
+			ExpectedKind:   object.AllocationLimitError,
+			ExpectedErr:    ErrAllocationLimit,
+		},
+		{
+			Expression:     "let s = \"ab\"; while true { s = s + s; }",
+			MaxAllocations: 1000000,
+			ExpectedKind:   object.AllocationLimitError,
+			ExpectedErr:    ErrAllocationLimit,
+		},
+		{
+			Expression:     "let a = [0, 0, 0, 0, 0, 0, 0, 0, 0, 0]; for x in a { push(a, x, x, x, x, x, x, x, x, x, x); }",
+			MaxAllocations: 100,
+			ExpectedKind:   object.AllocationLimitError,
+			ExpectedErr:    ErrAllocationLimit,
+		},
+		{
+			Expression:     "2 ** 100000000",
+			Overflow:       OverflowPromote,
+			MaxAllocations: 1000,
+			ExpectedKind:   object.AllocationLimitError,
+			ExpectedErr:    ErrAllocationLimit,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("evaluating: '%s'\n", tt.Expression)
+		l := lexer.New(tt.Expression)
+		program := parser.New(&l).Parse(token.EOF)
+		env := environment.New()
+		e := New()
+		e.MaxSteps = tt.MaxSteps
+		if tt.MaxDepth > 0 {
+			e.MaxDepth = tt.MaxDepth
+		}
+		e.MaxAllocations = tt.MaxAllocations
+		e.Overflow = tt.Overflow
+		obj := e.Eval(&program, &env)
+
+		err, ok := obj.(*object.Error)
+		if !ok {
+			t.Errorf("expected object.Error, instead got %+v\n", obj)
+			continue
+		}
+
+		if err.Kind != tt.ExpectedKind || !errors.Is(err.Value, tt.ExpectedErr) {
+			t.Errorf("expected a %s error, instead got a %s error '%s'\n", tt.ExpectedKind, err.Kind, err.Inspect())
+		}
+	}
+}
+
+func TestEvalLimitsReset(t *testing.T) {
+	l := lexer.New("let total = 0; for x in range(10) { total += x; } total")
+	program := parser.New(&l).Parse(token.EOF)
+	e := New()
+	e.MaxSteps = 200
+
+	// The budget applies to each evaluation on its own
+	for range 5 {
+		env := environment.New()
+		if obj := e.Eval(&program, &env); !cmp.Equal(obj, &object.Integer{Value: 45}) {
+			t.Fatalf("expected object to be %+v, instead got %+v\n", &object.Integer{Value: 45}, obj)
+		}
+	}
+}
+
+// TestEvalPrograms evaluates programs one after the other in the same
+// environment, like the REPL does.
+func TestEvalPrograms(t *testing.T) {
+	tests := []struct {
+		Source      string
+		ExpectedObj object.Object
+	}{
+		{
+			Source:      "fn f() { return g(); } fn h() { z = 2; } x",
+			ExpectedObj: &NULL,
+		},
+		{
+			Source:      "fn g() { return 7; } let z = 0; f()",
+			ExpectedObj: &object.Integer{Value: 7},
+		},
+		{
+			Source:      "h(); z",
+			ExpectedObj: &object.Integer{Value: 2},
+		},
+		{
+			Source:      "let y = x; let x = 1;",
+			ExpectedObj: newError("variable 'x' is used before being declared"),
+		},
+	}
+
+	env := environment.New()
+	for _, tt := range tests {
+		t.Logf("evaluating: '%s'\n", tt.Source)
+		l := lexer.New(tt.Source)
+		program := parser.New(&l).Parse(token.EOF)
+		obj := Eval(&program, &env)
+
+		if obj.Type() != tt.ExpectedObj.Type() || obj.Inspect() != tt.ExpectedObj.Inspect() {
+			t.Errorf("expected object to be %s, instead got %s\n", tt.ExpectedObj.Inspect(), obj.Inspect())
+		}
+	}
+}
+
+func TestEvalContext(t *testing.T) {
+	l := lexer.New("while true { 1 + 1; }")
+	program := parser.New(&l).Parse(token.EOF)
+	env := environment.New()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	obj := New().EvalContext(ctx, &program, &env)
+
+	err, ok := obj.(*object.Error)
+	if !ok {
+		t.Fatalf("expected object.Error, instead got %+v\n", obj)
+	}
+
+	if err.Kind != object.CanceledError || !errors.Is(err.Value, context.DeadlineExceeded) {
+		t.Errorf("expected a %s error, instead got a %s error '%s'\n", object.CanceledError, err.Kind, err.Inspect())
+	}
+}
+
+func TestErrorStackTrace(t *testing.T) {
+	src := `fn fib(n) {
+	if n == 0 {
+		return 1 / 0;
+	}
+	return fib(n - 1) + 1;
+}
+let f = fn(x) { return fib(x); };
+f(2)`
+	l := lexer.NewWithFilename("fib.mz", src)
+	program := parser.New(&l).Parse(token.EOF)
+	env := environment.New()
+	obj := Eval(&program, &env)
+
+	err, ok := obj.(*object.Error)
+	if !ok {
+		t.Fatalf("expected object.Error, instead got %+v\n", obj)
+	}
+
+	expected := strings.Join([]string{
+		"\tat fib (fib.mz:5:9)",
+		"\tat fib (fib.mz:5:9)",
+		"\tat fib (fib.mz:7:24)",
+		"\tat <anonymous> (fib.mz:8:1)",
+	}, "\n")
+	if err.StackTrace() != expected {
+		t.Errorf("expected stack trace\n%s\ninstead got\n%s\n", expected, err.StackTrace())
+	}
+
+	if !strings.HasSuffix(err.Describe(src), expected) {
+		t.Errorf("expected the description to end with the stack trace, instead got\n%s\n", err.Describe(src))
+	}
+
+	// Errors outside of functions have no stack
+	l = lexer.New("1 / 0")
+	program = parser.New(&l).Parse(token.EOF)
+	if err := Eval(&program, &env).(*object.Error); len(err.Stack) != 0 {
+		t.Errorf("expected an empty stack, instead got %+v\n", err.Stack)
+	}
+}
+
+func TestErrorStackTraceDepth(t *testing.T) {
+	l := lexer.New("fn f(n) { return f(n + 1); }\nf(0)")
+	program := parser.New(&l).Parse(token.EOF)
+	env := environment.New()
+	e := New()
+	e.MaxDepth = 50
+	obj := e.Eval(&program, &env)
+
+	err, ok := obj.(*object.Error)
+	if !ok {
+		t.Fatalf("expected object.Error, instead got %+v\n", obj)
+	}
+
+	if len(err.Stack) != 50 {
+		t.Errorf("expected 50 frames, instead got %d\n", len(err.Stack))
+	}
+
+	lines := strings.Split(err.StackTrace(), "\n")
+	if len(lines) != 21 || lines[10] != "\t... 30 more frames" || lines[20] != "\tat f (2:1)" {
+		t.Errorf("expected the middle frames to be left out, instead got\n%s\n", err.StackTrace())
+	}
+}
@@ -0,0 +1,163 @@
+package evaluator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"maz-lang/ast"
+	"maz-lang/environment"
+	"maz-lang/object"
+	"slices"
+)
+
+// DefaultMaxDepth is the call depth allowed by New, it stops runaway
+// recursion long before it exhausts the Go stack.
+const DefaultMaxDepth = 10000
+
+// The errors wrapped by the errors returned when a limit is hit.
+var (
+	ErrStepLimit       = errors.New("step limit exceeded")
+	ErrDepthLimit      = errors.New("maximum call depth exceeded")
+	ErrAllocationLimit = errors.New("allocation limit exceeded")
+)
+
+// How many steps are taken between checks of the context, checking it at
+// every step would slow down the evaluation.
+const contextCheckInterval = 1024
+
+// run is the state of an evaluation that the limits are checked against.
+type run struct {
+	ctx         context.Context
+	steps       int64
+	stack       []object.Frame
+	allocations int64
+}
+
+// EvalContext is like Eval, but the evaluation stops with an error of kind
+// object.CanceledError as soon as ctx is done.
+func (e *Evaluator) EvalContext(ctx context.Context, node ast.Node, env *environment.Environment) object.Object {
+	defer e.Begin(ctx)()
+	return e.Eval(node, env)
+}
+
+// ApplyContext is like Apply, but the call stops as soon as ctx is done.
+func (e *Evaluator) ApplyContext(ctx context.Context, fn object.Object, args []object.Object) object.Object {
+	defer e.Begin(ctx)()
+	return e.Apply(fn, args)
+}
+
+// Begin starts a new evaluation and returns the function which ends it,
+// nested evaluations are part of the one already running. Eval starts one
+// by itself, a virtual machine running a compiled program starts one so
+// that the builtins and operators it borrows account for what they create.
+func (e *Evaluator) Begin(ctx context.Context) (end func()) {
+	if e.run != nil {
+		return func() {}
+	}
+
+	e.run = &run{ctx: ctx}
+	return func() { e.run = nil }
+}
+
+// step accounts for a step of the evaluation, it returns an error if the
+// step budget is exhausted or the context is done.
+func (e *Evaluator) step() *object.Error {
+	e.run.steps++
+	if e.MaxSteps > 0 && e.run.steps > e.MaxSteps {
+		return &object.Error{Value: fmt.Errorf("%w: %d", ErrStepLimit, e.MaxSteps), Kind: object.StepLimitError}
+	}
+
+	if e.run.steps%contextCheckInterval == 0 {
+		if err := e.run.ctx.Err(); err != nil {
+			return &object.Error{Value: fmt.Errorf("evaluation stopped: %w", err), Kind: object.CanceledError}
+		}
+	}
+
+	return nil
+}
+
+// enter pushes the frame of a function call on the stack, leave must be
+// called when the function returns.
+func (e *Evaluator) enter(frame object.Frame) *object.Error {
+	if e.MaxDepth > 0 && len(e.run.stack) >= e.MaxDepth {
+		return &object.Error{Value: fmt.Errorf("%w: %d", ErrDepthLimit, e.MaxDepth), Kind: object.DepthLimitError}
+	}
+	e.run.stack = append(e.run.stack, frame)
+
+	return nil
+}
+
+func (e *Evaluator) leave() {
+	e.run.stack = e.run.stack[:len(e.run.stack)-1]
+}
+
+// stackTrace returns a copy of the stack with the innermost call first.
+func (e *Evaluator) stackTrace() []object.Frame {
+	if len(e.run.stack) == 0 {
+		return nil
+	}
+
+	res := slices.Clone(e.run.stack)
+	slices.Reverse(res)
+	return res
+}
+
+// alloc accounts for the creation of an object by node, the size of
+// the objects which can be large is accounted for where they are made.
+func (e *Evaluator) alloc(node ast.Node) *object.Error {
+	switch node.(type) {
+	case *ast.IntegerLiteral, *ast.FloatLiteral, *ast.StringLiteral, *ast.ArrayLiteral, *ast.HashLiteral,
+		*ast.FunctionDefinition, *ast.PrefixExpression, *ast.InfixExpression, *ast.CallExpression:
+	default:
+		return nil
+	}
+
+	return e.Allocate(1)
+}
+
+// Allocate accounts for the creation of objects of the given size, see
+// MaxAllocations, before they are made. It returns an error once the
+// budget is exhausted and does nothing outside of an evaluation.
+func (e *Evaluator) Allocate(size int64) *object.Error {
+	if e.run == nil || e.MaxAllocations <= 0 {
+		return nil
+	}
+
+	if size > e.MaxAllocations-e.run.allocations {
+		return &object.Error{Value: fmt.Errorf("%w: %d", ErrAllocationLimit, e.MaxAllocations), Kind: object.AllocationLimitError}
+	}
+	e.run.allocations += size
+
+	return nil
+}
+
+// bytesSize returns the size of n bytes of a string or a big integer.
+func bytesSize(n int) int64 {
+	return int64(n) / 8
+}
+
+// bigIntegerSize returns the size of the result of an operation on
+// integers of arbitrary size, without computing it.
+func bigIntegerSize(operator string, left, right *big.Int) int64 {
+	bits := int64(max(left.BitLen(), right.BitLen()) + 1)
+
+	switch operator {
+	case "*":
+		bits = int64(left.BitLen() + right.BitLen())
+	case "**":
+		if right.Sign() > 0 && right.IsInt64() && left.CmpAbs(big.NewInt(1)) > 0 {
+			bits = math.MaxInt64
+			if exp := right.Int64(); exp <= math.MaxInt64/int64(left.BitLen()) {
+				bits = int64(left.BitLen()) * exp
+			}
+		}
+	case "<<":
+		if right.IsUint64() && right.Uint64() <= math.MaxUint32 {
+			bits = int64(left.BitLen()) + right.Int64()
+		}
+	}
+
+	return bits / 64
+}
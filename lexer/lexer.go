@@ -2,25 +2,57 @@ package lexer
 
 import (
 	"maz-lang/token"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 type Lexer struct {
-	Text    string
-	pos     int
-	readPos int
-	char    byte
+	Text     string
+	Filename string
+	pos      int
+	readPos  int
+	char     rune
+
+	// line and col are the position of char, both starting at 1.
+	line int
+	col  int
+
+	// doc holds the lines of the doc comments found since the last token.
+	doc []string
 }
 
 func New(text string) Lexer {
-	l := Lexer{Text: text}
+	return NewWithFilename("", text)
+}
+
+// NewWithFilename returns a lexer whose token positions refer to filename.
+func NewWithFilename(filename, text string) Lexer {
+	l := Lexer{Text: text, Filename: filename, line: 1}
 	l.readChar()
 	return l
 }
 
 func (l *Lexer) NextToken() token.Token {
-	var res token.Token
+	if commentStart, ok := l.skipWhitespace(); !ok {
+		return token.Token{
+			Type:    token.UNTERMINATED_COMMENT,
+			Literal: "/*",
+			Loc:     token.Span{Start: commentStart, End: l.position()},
+		}
+	}
 
-	l.skipWhitespace()
+	start := l.position()
+	res := l.nextToken()
+	res.Loc = token.Span{Start: start, End: l.position()}
+	res.Doc = strings.Join(l.doc, "\n")
+	l.doc = nil
+
+	return res
+}
+
+func (l *Lexer) nextToken() token.Token {
+	var res token.Token
 
 	switch l.char {
 	case 0:
@@ -33,13 +65,43 @@ func (l *Lexer) NextToken() token.Token {
 			res = newToken(token.BANG, string(l.char))
 		}
 	case '+':
-		res = newToken(token.PLUS, string(l.char))
+		if l.peekChar() == '=' {
+			res = newToken(token.PLUS_ASSIGN, string(l.char)+string(l.peekChar()))
+			l.readChar()
+		} else {
+			res = newToken(token.PLUS, string(l.char))
+		}
 	case '-':
-		res = newToken(token.MINUS, string(l.char))
+		if l.peekChar() == '=' {
+			res = newToken(token.MINUS_ASSIGN, string(l.char)+string(l.peekChar()))
+			l.readChar()
+		} else {
+			res = newToken(token.MINUS, string(l.char))
+		}
 	case '*':
-		res = newToken(token.ASTERISK, string(l.char))
+		if l.peekChar() == '=' {
+			res = newToken(token.ASTERISK_ASSIGN, string(l.char)+string(l.peekChar()))
+			l.readChar()
+		} else if l.peekChar() == '*' {
+			res = newToken(token.POWER, string(l.char)+string(l.peekChar()))
+			l.readChar()
+		} else {
+			res = newToken(token.ASTERISK, string(l.char))
+		}
 	case '/':
-		res = newToken(token.SLASH, string(l.char))
+		if l.peekChar() == '=' {
+			res = newToken(token.SLASH_ASSIGN, string(l.char)+string(l.peekChar()))
+			l.readChar()
+		} else {
+			res = newToken(token.SLASH, string(l.char))
+		}
+	case '%':
+		if l.peekChar() == '=' {
+			res = newToken(token.PERCENT_ASSIGN, string(l.char)+string(l.peekChar()))
+			l.readChar()
+		} else {
+			res = newToken(token.PERCENT, string(l.char))
+		}
 	case '=':
 		if l.peekChar() == '=' {
 			res = newToken(token.EQ, string(l.char)+string(l.peekChar()))
@@ -47,14 +109,40 @@ func (l *Lexer) NextToken() token.Token {
 		} else {
 			res = newToken(token.ASSIGN, string(l.char))
 		}
+	case '&':
+		if l.peekChar() == '&' {
+			res = newToken(token.AND, string(l.char)+string(l.peekChar()))
+			l.readChar()
+		} else {
+			res = newToken(token.BIT_AND, string(l.char))
+		}
+	case '|':
+		if l.peekChar() == '|' {
+			res = newToken(token.OR, string(l.char)+string(l.peekChar()))
+			l.readChar()
+		} else {
+			res = newToken(token.BIT_OR, string(l.char))
+		}
+	case '^':
+		res = newToken(token.BIT_XOR, string(l.char))
+	case '~':
+		res = newToken(token.BIT_NOT, string(l.char))
 	case ';':
 		res = newToken(token.SEMICOLON, string(l.char))
+	case ':':
+		res = newToken(token.COLON, string(l.char))
 	case ',':
 		res = newToken(token.COMMA, string(l.char))
+	case '.':
+		res = newToken(token.DOT, string(l.char))
 	case '(':
 		res = newToken(token.LPAREN, string(l.char))
 	case ')':
 		res = newToken(token.RPAREN, string(l.char))
+	case '[':
+		res = newToken(token.LBRACKET, string(l.char))
+	case ']':
+		res = newToken(token.RBRACKET, string(l.char))
 	case '{':
 		res = newToken(token.LBRACE, string(l.char))
 	case '}':
@@ -63,6 +151,9 @@ func (l *Lexer) NextToken() token.Token {
 		if l.peekChar() == '=' {
 			res = newToken(token.GTEQ, string(l.char)+string(l.peekChar()))
 			l.readChar()
+		} else if l.peekChar() == '>' {
+			res = newToken(token.SHIFT_RIGHT, string(l.char)+string(l.peekChar()))
+			l.readChar()
 		} else {
 			res = newToken(token.GT, string(l.char))
 		}
@@ -70,16 +161,25 @@ func (l *Lexer) NextToken() token.Token {
 		if l.peekChar() == '=' {
 			res = newToken(token.LTEQ, string(l.char)+string(l.peekChar()))
 			l.readChar()
+		} else if l.peekChar() == '<' {
+			res = newToken(token.SHIFT_LEFT, string(l.char)+string(l.peekChar()))
+			l.readChar()
 		} else {
 			res = newToken(token.LT, string(l.char))
 		}
 	case '"':
-		str := l.readString()
-		res = newToken(token.STRING, str)
+		str, illegal := l.readString()
+		if illegal == `"` {
+			res = newToken(token.UNTERMINATED_STRING, illegal)
+		} else if illegal != "" {
+			res = newToken(token.INVALID_ESCAPE, illegal)
+		} else {
+			res = newToken(token.STRING, str)
+		}
 	default:
 		// Check if it is a digit
 		if isDigit(l.char) {
-			return newToken(token.INT, l.readNumber())
+			return l.readNumber()
 		}
 		// Check if it is an identifier or keyword
 		word := l.readWord()
@@ -89,9 +189,10 @@ func (l *Lexer) NextToken() token.Token {
 			return newToken(keyword, word)
 		} else if word != "" {
 			return newToken(token.IDENT, word)
-		} else {
-			return newToken(token.ILLEGAL, string(l.char))
 		}
+
+		// Skip the illegal char, so that lexing can go on
+		res = newToken(token.ILLEGAL, string(l.char))
 	}
 
 	l.readChar()
@@ -103,46 +204,225 @@ func newToken(tokenType token.TokenType, literal string) token.Token {
 }
 
 func (l *Lexer) readChar() {
+	if l.char == '\n' {
+		l.line++
+		l.col = 0
+	}
+
+	size := 1
 	if l.readPos >= len(l.Text) {
 		l.char = 0
 	} else {
-		l.char = l.Text[l.readPos]
+		l.char, size = utf8.DecodeRuneInString(l.Text[l.readPos:])
 	}
 
-	l.pos = l.readPos
-	l.readPos++
+	if l.readPos <= len(l.Text) {
+		l.col++
+	}
+	l.pos = min(l.readPos, len(l.Text))
+	l.readPos += size
 }
 
-func (l *Lexer) peekChar() byte {
+// position returns the position of the current char.
+func (l *Lexer) position() token.Position {
+	return token.Position{Filename: l.Filename, Offset: l.pos, Line: l.line, Column: l.col}
+}
+
+func (l *Lexer) peekChar() rune {
 	if l.readPos >= len(l.Text) {
 		return 0
 	}
-	return l.Text[l.readPos]
+
+	char, _ := utf8.DecodeRuneInString(l.Text[l.readPos:])
+	return char
 }
 
-func (l *Lexer) skipWhitespace() {
-	for l.char == ' ' || l.char == '\t' || l.char == '\n' || l.char == '\r' {
+// skipWhitespace skips whitespace and comments. If a block comment is never closed
+// it returns false, along with the position where the comment starts.
+func (l *Lexer) skipWhitespace() (token.Position, bool) {
+	for {
+		switch {
+		case l.char == ' ' || l.char == '\t' || l.char == '\n' || l.char == '\r':
+			l.readChar()
+		case l.char == '/' && l.peekChar() == '/':
+			l.skipLineComment()
+		case l.char == '/' && l.peekChar() == '*':
+			start := l.position()
+			if !l.skipBlockComment() {
+				return start, false
+			}
+		default:
+			return token.Position{}, true
+		}
+	}
+}
+
+// skipLineComment skips a comment up to the end of the line,
+// the text of '///' doc comments is kept for the next token.
+func (l *Lexer) skipLineComment() {
+	start := l.pos
+	for l.char != '\n' && l.char != 0 {
+		l.readChar()
+	}
+
+	text := strings.TrimSuffix(l.Text[start:l.pos], "\r")
+	if strings.HasPrefix(text, "///") && !strings.HasPrefix(text, "////") {
+		l.doc = append(l.doc, strings.TrimPrefix(text[3:], " "))
+	}
+}
+
+// skipBlockComment skips a '/* */' comment, which may contain other block comments.
+// It returns false if the end of the text is reached before the comment is closed.
+func (l *Lexer) skipBlockComment() bool {
+	depth := 0
+	for {
+		switch {
+		case l.char == 0:
+			return false
+		case l.char == '/' && l.peekChar() == '*':
+			depth++
+			l.readChar()
+		case l.char == '*' && l.peekChar() == '/':
+			depth--
+			l.readChar()
+			if depth == 0 {
+				l.readChar()
+				return true
+			}
+		}
 		l.readChar()
 	}
 }
 
-func (l *Lexer) readString() string {
-	start := l.readPos
-	for l.peekChar() != '"' {
+// readString reads a string literal, replacing its escape sequences.
+// If the string is not valid, illegal is the literal of the token to return instead:
+// '"' if the string is never closed, otherwise the first invalid escape sequence.
+func (l *Lexer) readString() (str string, illegal string) {
+	var out strings.Builder
+
+	for {
 		l.readChar()
+
+		switch l.char {
+		case 0:
+			if l.pos >= len(l.Text) {
+				return "", `"`
+			}
+			out.WriteRune(l.char)
+		case '"':
+			return out.String(), illegal
+		case '\\':
+			start := l.pos
+			char, ok := l.readEscape()
+			if !ok && illegal == "" {
+				illegal = l.Text[start:min(l.readPos, len(l.Text))]
+			}
+			out.WriteRune(char)
+		default:
+			out.WriteRune(l.char)
+		}
 	}
+}
+
+// readEscape reads the escape sequence starting at the current backslash and returns
+// the character it stands for. It stops at the last char of the sequence,
+// or at the first char that makes it invalid.
+func (l *Lexer) readEscape() (rune, bool) {
 	l.readChar()
-	end := l.pos
 
-	return l.Text[start:end]
+	switch l.char {
+	case 'n':
+		return '\n', true
+	case 't':
+		return '\t', true
+	case 'r':
+		return '\r', true
+	case '0':
+		return 0, true
+	case '\\', '"':
+		return l.char, true
+	case 'u':
+		// \u{X} up to \u{10FFFF}
+		if l.peekChar() != '{' {
+			return 0, false
+		}
+		l.readChar()
+
+		var value rune
+		digits := 0
+		for l.peekChar() != '}' {
+			digit, ok := hexValue(l.peekChar())
+			if !ok || digits == 6 {
+				return 0, false
+			}
+			l.readChar()
+			value = value*16 + digit
+			digits++
+		}
+		l.readChar()
+
+		if digits == 0 || !utf8.ValidRune(value) {
+			return 0, false
+		}
+		return value, true
+	}
+
+	return 0, false
 }
 
-func (l *Lexer) readNumber() string {
+// readNumber reads an integer literal like '42', '1_000', '0xFF', '0o755' or '0b1010',
+// or a float literal like '3.14' or '1e-9'. The digits are checked by the parser.
+func (l *Lexer) readNumber() token.Token {
 	pos := l.pos
-	for isDigit(l.char) {
+	tokenType := token.TokenType(token.INT)
+
+	if l.char == '0' && strings.ContainsRune("xXoObB", l.peekChar()) {
+		l.readChar()
+		l.readChar()
+		for isLetter(l.char) || isDigit(l.char) {
+			l.readChar()
+		}
+		return newToken(tokenType, l.Text[pos:l.pos])
+	}
+
+	l.skipDigits()
+
+	// A fraction must have digits after the dot
+	if l.char == '.' && isDigit(l.peekChar()) {
+		tokenType = token.FLOAT
+		l.readChar()
+		l.skipDigits()
+	}
+
+	if l.char == 'e' || l.char == 'E' {
+		if l.isExponent() {
+			tokenType = token.FLOAT
+			l.readChar()
+			if l.char == '+' || l.char == '-' {
+				l.readChar()
+			}
+			l.skipDigits()
+		}
+	}
+
+	return newToken(tokenType, l.Text[pos:l.pos])
+}
+
+// isExponent reports whether the current 'e' starts the exponent of a float.
+func (l *Lexer) isExponent() bool {
+	next := l.Text[min(l.readPos, len(l.Text)):]
+	if len(next) > 0 && (next[0] == '+' || next[0] == '-') {
+		next = next[1:]
+	}
+
+	return len(next) > 0 && isDigit(rune(next[0]))
+}
+
+// skipDigits skips decimal digits and the '_' separating them.
+func (l *Lexer) skipDigits() {
+	for isDigit(l.char) || l.char == '_' {
 		l.readChar()
 	}
-	return l.Text[pos:l.pos]
 }
 
 func (l *Lexer) readWord() string {
@@ -153,10 +433,23 @@ func (l *Lexer) readWord() string {
 	return l.Text[pos:l.pos]
 }
 
-func isDigit(char byte) bool {
+func isDigit(char rune) bool {
 	return char >= '0' && char <= '9'
 }
 
-func isLetter(char byte) bool {
-	return (char >= 'a' && char <= 'z') || (char >= 'A' && char <= 'Z') || char == '_'
+func isLetter(char rune) bool {
+	return unicode.IsLetter(char) || char == '_'
+}
+
+func hexValue(char rune) (rune, bool) {
+	switch {
+	case char >= '0' && char <= '9':
+		return char - '0', true
+	case char >= 'a' && char <= 'f':
+		return char - 'a' + 10, true
+	case char >= 'A' && char <= 'F':
+		return char - 'A' + 10, true
+	}
+
+	return 0, false
 }
@@ -7,7 +7,7 @@ import (
 
 func TestNextToken(t *testing.T) {
 	input := `
-	+-*/=;,(){}
+	+-*/% =;,(){}
 	10 1
 	a foo fizz_buzz
 	let a = 10;
@@ -27,6 +27,14 @@ func TestNextToken(t *testing.T) {
 	"foo"
 	"bar"
 	""
+	obj.method
+	[1]
+	{"a": 1}
+	while for x in break continue
+	+= -= *= /= %=
+	&& ||
+	& | ^ ~ << >> **
+	@a
 	`
 
 	tests := []struct {
@@ -37,6 +45,7 @@ func TestNextToken(t *testing.T) {
 		{ExpectedType: token.MINUS, ExpectedLiteral: "-"},
 		{ExpectedType: token.ASTERISK, ExpectedLiteral: "*"},
 		{ExpectedType: token.SLASH, ExpectedLiteral: "/"},
+		{ExpectedType: token.PERCENT, ExpectedLiteral: "%"},
 		{ExpectedType: token.ASSIGN, ExpectedLiteral: "="},
 		{ExpectedType: token.SEMICOLON, ExpectedLiteral: ";"},
 		{ExpectedType: token.COMMA, ExpectedLiteral: ","},
@@ -104,6 +113,40 @@ func TestNextToken(t *testing.T) {
 		{ExpectedType: token.STRING, ExpectedLiteral: "foo"},
 		{ExpectedType: token.STRING, ExpectedLiteral: "bar"},
 		{ExpectedType: token.STRING, ExpectedLiteral: ""},
+		{ExpectedType: token.IDENT, ExpectedLiteral: "obj"},
+		{ExpectedType: token.DOT, ExpectedLiteral: "."},
+		{ExpectedType: token.IDENT, ExpectedLiteral: "method"},
+		{ExpectedType: token.LBRACKET, ExpectedLiteral: "["},
+		{ExpectedType: token.INT, ExpectedLiteral: "1"},
+		{ExpectedType: token.RBRACKET, ExpectedLiteral: "]"},
+		{ExpectedType: token.LBRACE, ExpectedLiteral: "{"},
+		{ExpectedType: token.STRING, ExpectedLiteral: "a"},
+		{ExpectedType: token.COLON, ExpectedLiteral: ":"},
+		{ExpectedType: token.INT, ExpectedLiteral: "1"},
+		{ExpectedType: token.RBRACE, ExpectedLiteral: "}"},
+		{ExpectedType: token.WHILE, ExpectedLiteral: "while"},
+		{ExpectedType: token.FOR, ExpectedLiteral: "for"},
+		{ExpectedType: token.IDENT, ExpectedLiteral: "x"},
+		{ExpectedType: token.IN, ExpectedLiteral: "in"},
+		{ExpectedType: token.BREAK, ExpectedLiteral: "break"},
+		{ExpectedType: token.CONTINUE, ExpectedLiteral: "continue"},
+		{ExpectedType: token.PLUS_ASSIGN, ExpectedLiteral: "+="},
+		{ExpectedType: token.MINUS_ASSIGN, ExpectedLiteral: "-="},
+		{ExpectedType: token.ASTERISK_ASSIGN, ExpectedLiteral: "*="},
+		{ExpectedType: token.SLASH_ASSIGN, ExpectedLiteral: "/="},
+		{ExpectedType: token.PERCENT_ASSIGN, ExpectedLiteral: "%="},
+		{ExpectedType: token.AND, ExpectedLiteral: "&&"},
+		{ExpectedType: token.OR, ExpectedLiteral: "||"},
+		{ExpectedType: token.BIT_AND, ExpectedLiteral: "&"},
+		{ExpectedType: token.BIT_OR, ExpectedLiteral: "|"},
+		{ExpectedType: token.BIT_XOR, ExpectedLiteral: "^"},
+		{ExpectedType: token.BIT_NOT, ExpectedLiteral: "~"},
+		{ExpectedType: token.SHIFT_LEFT, ExpectedLiteral: "<<"},
+		{ExpectedType: token.SHIFT_RIGHT, ExpectedLiteral: ">>"},
+		{ExpectedType: token.POWER, ExpectedLiteral: "**"},
+		{ExpectedType: token.ILLEGAL, ExpectedLiteral: "@"},
+		{ExpectedType: token.IDENT, ExpectedLiteral: "a"},
+		{ExpectedType: token.EOF, ExpectedLiteral: ""},
 	}
 
 	l := New(input)
@@ -122,3 +165,226 @@ func TestNextToken(t *testing.T) {
 	}
 
 }
+
+func TestTokenPosition(t *testing.T) {
+	input := "let a = 10;\n\tfoo(\"bar\")"
+
+	tests := []struct {
+		ExpectedLiteral string
+		ExpectedStart   token.Position
+		ExpectedEnd     token.Position
+	}{
+		{"let", token.Position{Filename: "a.mz", Offset: 0, Line: 1, Column: 1}, token.Position{Filename: "a.mz", Offset: 3, Line: 1, Column: 4}},
+		{"a", token.Position{Filename: "a.mz", Offset: 4, Line: 1, Column: 5}, token.Position{Filename: "a.mz", Offset: 5, Line: 1, Column: 6}},
+		{"=", token.Position{Filename: "a.mz", Offset: 6, Line: 1, Column: 7}, token.Position{Filename: "a.mz", Offset: 7, Line: 1, Column: 8}},
+		{"10", token.Position{Filename: "a.mz", Offset: 8, Line: 1, Column: 9}, token.Position{Filename: "a.mz", Offset: 10, Line: 1, Column: 11}},
+		{";", token.Position{Filename: "a.mz", Offset: 10, Line: 1, Column: 11}, token.Position{Filename: "a.mz", Offset: 11, Line: 1, Column: 12}},
+		{"foo", token.Position{Filename: "a.mz", Offset: 13, Line: 2, Column: 2}, token.Position{Filename: "a.mz", Offset: 16, Line: 2, Column: 5}},
+		{"(", token.Position{Filename: "a.mz", Offset: 16, Line: 2, Column: 5}, token.Position{Filename: "a.mz", Offset: 17, Line: 2, Column: 6}},
+		{"bar", token.Position{Filename: "a.mz", Offset: 17, Line: 2, Column: 6}, token.Position{Filename: "a.mz", Offset: 22, Line: 2, Column: 11}},
+		{")", token.Position{Filename: "a.mz", Offset: 22, Line: 2, Column: 11}, token.Position{Filename: "a.mz", Offset: 23, Line: 2, Column: 12}},
+		{"", token.Position{Filename: "a.mz", Offset: 23, Line: 2, Column: 12}, token.Position{Filename: "a.mz", Offset: 23, Line: 2, Column: 12}},
+	}
+
+	l := NewWithFilename("a.mz", input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Literal != tt.ExpectedLiteral {
+			t.Fatalf("#%d invalid token literal, expected='%s' got='%s'", i, tt.ExpectedLiteral, tok.Literal)
+		}
+
+		if tok.Loc.Start != tt.ExpectedStart {
+			t.Errorf("#%d invalid start position, expected=%+v got=%+v", i, tt.ExpectedStart, tok.Loc.Start)
+		}
+
+		if tok.Loc.End != tt.ExpectedEnd {
+			t.Errorf("#%d invalid end position, expected=%+v got=%+v", i, tt.ExpectedEnd, tok.Loc.End)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `
+	// a line comment
+	let a = 1; // trailing comment
+	/* a block
+	   comment */ a /* /* nested */ still a comment */ +
+	/// Adds two numbers.
+	///
+	/// Returns their sum.
+	fn add
+	//// not a doc comment
+	b / c
+	`
+
+	tests := []struct {
+		ExpectedType    token.TokenType
+		ExpectedLiteral string
+		ExpectedDoc     string
+	}{
+		{ExpectedType: token.LET, ExpectedLiteral: "let"},
+		{ExpectedType: token.IDENT, ExpectedLiteral: "a"},
+		{ExpectedType: token.ASSIGN, ExpectedLiteral: "="},
+		{ExpectedType: token.INT, ExpectedLiteral: "1"},
+		{ExpectedType: token.SEMICOLON, ExpectedLiteral: ";"},
+		{ExpectedType: token.IDENT, ExpectedLiteral: "a"},
+		{ExpectedType: token.PLUS, ExpectedLiteral: "+"},
+		{ExpectedType: token.FUNCTION, ExpectedLiteral: "fn", ExpectedDoc: "Adds two numbers.\n\nReturns their sum."},
+		{ExpectedType: token.IDENT, ExpectedLiteral: "add"},
+		{ExpectedType: token.IDENT, ExpectedLiteral: "b"},
+		{ExpectedType: token.SLASH, ExpectedLiteral: "/"},
+		{ExpectedType: token.IDENT, ExpectedLiteral: "c"},
+		{ExpectedType: token.EOF, ExpectedLiteral: ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		t.Logf("lexer on char: %s\n", tt.ExpectedLiteral)
+		tok := l.NextToken()
+
+		if tok.Type != tt.ExpectedType {
+			t.Errorf("#%d invalid token type, expected='%s' got='%s'", i, tt.ExpectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.ExpectedLiteral {
+			t.Errorf("#%d invalid token literal, expected='%s' got='%s'", i, tt.ExpectedLiteral, tok.Literal)
+		}
+
+		if tok.Doc != tt.ExpectedDoc {
+			t.Errorf("#%d invalid token doc, expected='%s' got='%s'", i, tt.ExpectedDoc, tok.Doc)
+		}
+	}
+}
+
+func TestUnterminatedComment(t *testing.T) {
+	input := "a\n  /* one /* two */\n"
+
+	l := NewWithFilename("a.mz", input)
+	l.NextToken()
+	tok := l.NextToken()
+
+	if tok.Type != token.UNTERMINATED_COMMENT || tok.Literal != "/*" {
+		t.Fatalf("expected UNTERMINATED_COMMENT '/*', instead got %s '%s'", tok.Type, tok.Literal)
+	}
+
+	expected := token.Position{Filename: "a.mz", Offset: 4, Line: 2, Column: 3}
+	if tok.Loc.Start != expected {
+		t.Errorf("invalid start position, expected=%+v got=%+v", expected, tok.Loc.Start)
+	}
+
+	if tok := l.NextToken(); tok.Type != token.EOF {
+		t.Errorf("expected EOF after the comment, instead got %s", tok.Type)
+	}
+}
+
+func TestStringEscapes(t *testing.T) {
+	tests := []struct {
+		Input           string
+		ExpectedType    token.TokenType
+		ExpectedLiteral string
+	}{
+		{`"a\"b"`, token.STRING, `a"b`},
+		{`"line\nnext\ttab\r"`, token.STRING, "line\nnext\ttab\r"},
+		{`"back\\slash"`, token.STRING, `back\slash`},
+		{`"nul\0"`, token.STRING, "nul\x00"},
+		{`"\u{1F600} \u{e9}"`, token.STRING, "😀 é"},
+		{`"héllo wörld"`, token.STRING, "héllo wörld"},
+		{`"bad \q escape"`, token.INVALID_ESCAPE, `\q`},
+		{`"\u{110000}"`, token.INVALID_ESCAPE, `\u{110000}`},
+		{`"\u{1F60"`, token.INVALID_ESCAPE, `\u{1F60`},
+		{`"\u41"`, token.INVALID_ESCAPE, `\u`},
+		{`"\u{}"`, token.INVALID_ESCAPE, `\u{}`},
+		{`"never closed`, token.UNTERMINATED_STRING, `"`},
+		{`"ends with \`, token.UNTERMINATED_STRING, `"`},
+	}
+
+	for i, tt := range tests {
+		t.Logf("lexer on: %s\n", tt.Input)
+		l := New(tt.Input)
+		tok := l.NextToken()
+
+		if tok.Type != tt.ExpectedType {
+			t.Errorf("#%d invalid token type, expected='%s' got='%s'", i, tt.ExpectedType, tok.Type)
+		}
+
+		if tok.Literal != tt.ExpectedLiteral {
+			t.Errorf("#%d invalid token literal, expected='%s' got='%s'", i, tt.ExpectedLiteral, tok.Literal)
+		}
+
+		if tok := l.NextToken(); tok.Type != token.EOF {
+			t.Errorf("#%d expected EOF after the string, instead got %s '%s'", i, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestUnicode(t *testing.T) {
+	input := "let café = \"☕\"; naïve"
+
+	tests := []struct {
+		ExpectedType    token.TokenType
+		ExpectedLiteral string
+		ExpectedColumn  int
+	}{
+		{token.LET, "let", 1},
+		{token.IDENT, "café", 5},
+		{token.ASSIGN, "=", 10},
+		{token.STRING, "☕", 12},
+		{token.SEMICOLON, ";", 15},
+		{token.IDENT, "naïve", 17},
+		{token.EOF, "", 22},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.ExpectedType || tok.Literal != tt.ExpectedLiteral {
+			t.Errorf("#%d invalid token, expected=%s '%s' got=%s '%s'", i, tt.ExpectedType, tt.ExpectedLiteral, tok.Type, tok.Literal)
+		}
+
+		if tok.Loc.Start.Column != tt.ExpectedColumn {
+			t.Errorf("#%d invalid column, expected=%d got=%d", i, tt.ExpectedColumn, tok.Loc.Start.Column)
+		}
+	}
+}
+
+func TestNumbers(t *testing.T) {
+	input := "3.14 1e-9 2.5E+3 10 1.x 1e 7e5 0xFF 0o755 0B1010 1_000_000 1_000.5 0xZ"
+
+	tests := []struct {
+		ExpectedType    token.TokenType
+		ExpectedLiteral string
+	}{
+		{token.FLOAT, "3.14"},
+		{token.FLOAT, "1e-9"},
+		{token.FLOAT, "2.5E+3"},
+		{token.INT, "10"},
+		{token.INT, "1"},
+		{token.DOT, "."},
+		{token.IDENT, "x"},
+		{token.INT, "1"},
+		{token.IDENT, "e"},
+		{token.FLOAT, "7e5"},
+		{token.INT, "0xFF"},
+		{token.INT, "0o755"},
+		{token.INT, "0B1010"},
+		{token.INT, "1_000_000"},
+		{token.FLOAT, "1_000.5"},
+		{token.INT, "0xZ"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+
+	for i, tt := range tests {
+		tok := l.NextToken()
+
+		if tok.Type != tt.ExpectedType || tok.Literal != tt.ExpectedLiteral {
+			t.Errorf("#%d invalid token, expected=%s '%s' got=%s '%s'", i, tt.ExpectedType, tt.ExpectedLiteral, tok.Type, tok.Literal)
+		}
+	}
+}
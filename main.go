@@ -1,35 +1,178 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
+	"maz-lang/ast"
+	"maz-lang/compiler"
 	"maz-lang/environment"
 	"maz-lang/evaluator"
 	"maz-lang/lexer"
+	"maz-lang/object"
+	"maz-lang/optimizer"
 	"maz-lang/parser"
 	"maz-lang/repl"
 	"maz-lang/token"
+	"maz-lang/vm"
 	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	useVM    = flag.Bool("vm", false, "compile the program and run it on the virtual machine")
+	optimize = flag.Bool("O", false, "optimize the program before running or compiling it")
+	inline   = flag.Bool("inline", false, "with -O, also inline the calls to small functions")
+	dumpAST  = flag.Bool("dump-ast", false, "print the program, after the optimizations, instead of running it")
 )
 
 func main() {
-	if len(os.Args) > 1 {
-		readAndEvalFromFile(os.Args[1])
-	} else {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage:\n  maz [-vm] [-O [-inline]] [-dump-ast] [file.mz]\trun a file, or start the REPL\n  maz file.mzc\t\trun a compiled file on the virtual machine\n  maz build [-o file.mzc] file.mz\tcompile a file\n  maz disasm file.mz\tshow the bytecode a file compiles to\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	switch {
+	case flag.NArg() == 0:
 		repl.Run()
+	case flag.Arg(0) == "disasm":
+		if flag.NArg() != 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		disassembleFile(flag.Arg(1))
+	case flag.Arg(0) == "build":
+		buildFile(flag.Args()[1:])
+	case filepath.Ext(flag.Arg(0)) == ".mzc":
+		runCompiledFile(flag.Arg(0))
+	default:
+		readAndEvalFromFile(flag.Arg(0))
 	}
 }
 
-func readAndEvalFromFile(path string) {
+// parseFile reads and parses the program at path, optimizing it with -O,
+// it exits if the file cannot be read or has syntax errors.
+func parseFile(path string) (string, *ast.Program) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		log.Fatalf("unable to read file: %s\n", err)
 	}
 
-	env := environment.New()
-	l := lexer.New(string(data))
+	src := string(data)
+	l := lexer.NewWithFilename(path, src)
 	p := parser.New(&l)
 	program := p.Parse(token.EOF)
-	obj := evaluator.Eval(&program, &env)
-	fmt.Printf("%s\n", obj.Inspect())
+	if len(p.Errors()) > 0 {
+		for _, err := range p.Errors() {
+			fmt.Fprintf(os.Stderr, "%s\n", err.Describe(src))
+		}
+		os.Exit(1)
+	}
+
+	if *optimize {
+		optimizer.Optimize(&program, optimizer.Options{Inline: *inline})
+	}
+
+	return src, &program
+}
+
+func compile(program *ast.Program) *compiler.Bytecode {
+	c := compiler.New()
+	if err := c.Compile(program); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err)
+		os.Exit(1)
+	}
+
+	return c.Bytecode()
+}
+
+func disassembleFile(path string) {
+	_, program := parseFile(path)
+	if err := compile(program).Disassemble(os.Stdout); err != nil {
+		log.Fatalf("unable to write the disassembly: %s\n", err)
+	}
+}
+
+// buildFile compiles a program and writes it next to the source, or to
+// the path given with -o.
+func buildFile(args []string) {
+	fs := flag.NewFlagSet("build", flag.ExitOnError)
+	fs.Usage = flag.Usage
+	out := fs.String("o", "", "where to write the compiled program")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	path := fs.Arg(0)
+	if *out == "" {
+		*out = strings.TrimSuffix(path, filepath.Ext(path)) + ".mzc"
+	}
+
+	_, program := parseFile(path)
+	f, err := os.Create(*out)
+	if err != nil {
+		log.Fatalf("unable to create file: %s\n", err)
+	}
+	if err := compile(program).Encode(f); err != nil {
+		f.Close()
+		log.Fatalf("unable to write the compiled program: %s\n", err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatalf("unable to write the compiled program: %s\n", err)
+	}
+}
+
+// runCompiledFile runs a program written by maz build, the source is not
+// available so errors are reported without an excerpt.
+func runCompiledFile(path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatalf("unable to read file: %s\n", err)
+	}
+	bytecode, err := compiler.Decode(f)
+	f.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+		os.Exit(1)
+	}
+
+	report("", vm.New(bytecode, evaluator.New()).Run())
+}
+
+func readAndEvalFromFile(path string) {
+	src, program := parseFile(path)
+	if *dumpAST {
+		fmt.Print(program.String())
+		return
+	}
+
+	var obj object.Object
+	if *useVM {
+		obj = vm.New(compile(program), evaluator.New()).Run()
+	} else {
+		env := environment.New()
+		obj = evaluator.Eval(program, &env)
+	}
+
+	report(src, obj)
+}
+
+// report shows the result of running a program and exits with the
+// appropriate code.
+func report(src string, obj object.Object) {
+	switch obj := obj.(type) {
+	case *object.Error:
+		fmt.Fprintf(os.Stderr, "%s\n", obj.Describe(src))
+		os.Exit(1)
+	case *object.Exit:
+		os.Exit(obj.Code)
+	case *object.Null:
+		// Nothing to show, the program printed what it wanted to
+	default:
+		fmt.Printf("%s\n", obj.Inspect())
+	}
 }
@@ -0,0 +1,250 @@
+package maz
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"maz-lang/evaluator"
+	"maz-lang/object"
+	"reflect"
+)
+
+var bigIntType = reflect.TypeOf((*big.Int)(nil))
+
+// ToObject converts a Go value to a maz object:
+//   - nil is null, booleans, strings, floats and integers become the maz equivalent,
+//     integers which do not fit in 64 bits and *big.Int values become big integers
+//   - slices and arrays become arrays, maps become hashes
+//   - functions become builtins, see Func
+//   - objects are returned as they are
+func ToObject(value any) (object.Object, error) {
+	if value == nil {
+		return &evaluator.NULL, nil
+	}
+
+	switch value := value.(type) {
+	case object.Object:
+		return value, nil
+	case *big.Int:
+		return bigIntObject(value), nil
+	}
+
+	return toObject(reflect.ValueOf(value))
+}
+
+func toObject(v reflect.Value) (object.Object, error) {
+	switch v.Kind() {
+	case reflect.Bool:
+		return &object.Boolean{Value: v.Bool()}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &object.Integer{Value: v.Int()}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return &object.BigInteger{Value: new(big.Int).SetUint64(v.Uint())}, nil
+		}
+		return &object.Integer{Value: int64(v.Uint())}, nil
+	case reflect.Float32, reflect.Float64:
+		return &object.Float{Value: v.Float()}, nil
+	case reflect.String:
+		return &object.String{Value: v.String()}, nil
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return &evaluator.NULL, nil
+		}
+		arr := &object.Array{Elements: make([]object.Object, v.Len())}
+		for i := range v.Len() {
+			el, err := ToObject(v.Index(i).Interface())
+			if err != nil {
+				return nil, err
+			}
+			arr.Elements[i] = el
+		}
+		return arr, nil
+	case reflect.Map:
+		if v.IsNil() {
+			return &evaluator.NULL, nil
+		}
+		hash := object.NewHash()
+		iter := v.MapRange()
+		for iter.Next() {
+			key, err := ToObject(iter.Key().Interface())
+			if err != nil {
+				return nil, err
+			}
+			hashable, ok := key.(object.Hashable)
+			if !ok {
+				return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
+			}
+			value, err := ToObject(iter.Value().Interface())
+			if err != nil {
+				return nil, err
+			}
+			hash.Set(hashable, value)
+		}
+		return hash, nil
+	case reflect.Func:
+		return Func("<go func>", v.Interface())
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return &evaluator.NULL, nil
+		}
+		return ToObject(v.Elem().Interface())
+	}
+
+	return nil, fmt.Errorf("cannot convert %s to a maz value", v.Type())
+}
+
+func bigIntObject(value *big.Int) object.Object {
+	if value.IsInt64() {
+		return &object.Integer{Value: value.Int64()}
+	}
+	return &object.BigInteger{Value: new(big.Int).Set(value)}
+}
+
+// FromObject converts a maz object to a Go value:
+//   - null is nil, booleans, strings and floats become the Go equivalent
+//   - integers become int64 and big integers *big.Int
+//   - arrays become []any and hashes map[any]any
+//   - any other object, like functions, is returned as it is
+func FromObject(obj object.Object) any {
+	switch obj := obj.(type) {
+	case *object.Null:
+		return nil
+	case *object.Boolean:
+		return obj.Value
+	case *object.Integer:
+		return obj.Value
+	case *object.BigInteger:
+		return new(big.Int).Set(obj.Value)
+	case *object.Float:
+		return obj.Value
+	case *object.String:
+		return obj.Value
+	case *object.Array:
+		res := make([]any, len(obj.Elements))
+		for i, el := range obj.Elements {
+			res[i] = FromObject(el)
+		}
+		return res
+	case *object.Hash:
+		res := make(map[any]any, len(obj.Pairs))
+		for _, key := range obj.Order {
+			pair := obj.Pairs[key]
+			res[FromObject(pair.Key)] = FromObject(pair.Value)
+		}
+		return res
+	}
+
+	return obj
+}
+
+// fromObject converts obj to a Go value of type typ.
+func fromObject(obj object.Object, typ reflect.Type) (reflect.Value, error) {
+	// Parameters of type any get Go values, other interfaces like
+	// object.Object and the object types get the object itself
+	isAny := typ.Kind() == reflect.Interface && typ.NumMethod() == 0
+	if !isAny && reflect.TypeOf(obj).AssignableTo(typ) {
+		return reflect.ValueOf(obj), nil
+	}
+
+	fail := func() (reflect.Value, error) {
+		return reflect.Value{}, fmt.Errorf("cannot use %s as %s", obj.Type(), typ)
+	}
+
+	if typ == bigIntType {
+		switch obj := obj.(type) {
+		case *object.Integer:
+			return reflect.ValueOf(big.NewInt(obj.Value)), nil
+		case *object.BigInteger:
+			return reflect.ValueOf(new(big.Int).Set(obj.Value)), nil
+		}
+		return fail()
+	}
+
+	res := reflect.New(typ).Elem()
+	switch typ.Kind() {
+	case reflect.Bool:
+		b, ok := obj.(*object.Boolean)
+		if !ok {
+			return fail()
+		}
+		res.SetBool(b.Value)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, ok := obj.(*object.Integer)
+		if !ok {
+			return fail()
+		}
+		if res.OverflowInt(i.Value) {
+			return reflect.Value{}, fmt.Errorf("%d overflows %s", i.Value, typ)
+		}
+		res.SetInt(i.Value)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		i, ok := obj.(*object.Integer)
+		if !ok {
+			return fail()
+		}
+		if i.Value < 0 || res.OverflowUint(uint64(i.Value)) {
+			return reflect.Value{}, fmt.Errorf("%d overflows %s", i.Value, typ)
+		}
+		res.SetUint(uint64(i.Value))
+	case reflect.Float32, reflect.Float64:
+		switch num := obj.(type) {
+		case *object.Float:
+			res.SetFloat(num.Value)
+		case *object.Integer:
+			res.SetFloat(float64(num.Value))
+		default:
+			return fail()
+		}
+	case reflect.String:
+		s, ok := obj.(*object.String)
+		if !ok {
+			return fail()
+		}
+		res.SetString(s.Value)
+	case reflect.Slice:
+		arr, ok := obj.(*object.Array)
+		if !ok {
+			return fail()
+		}
+		res = reflect.MakeSlice(typ, len(arr.Elements), len(arr.Elements))
+		for i, el := range arr.Elements {
+			v, err := fromObject(el, typ.Elem())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			res.Index(i).Set(v)
+		}
+	case reflect.Map:
+		hash, ok := obj.(*object.Hash)
+		if !ok {
+			return fail()
+		}
+		res = reflect.MakeMapWithSize(typ, len(hash.Pairs))
+		for _, key := range hash.Order {
+			pair := hash.Pairs[key]
+			k, err := fromObject(pair.Key, typ.Key())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			v, err := fromObject(pair.Value, typ.Elem())
+			if err != nil {
+				return reflect.Value{}, err
+			}
+			res.SetMapIndex(k, v)
+		}
+	case reflect.Interface:
+		value := FromObject(obj)
+		if value == nil {
+			return res, nil
+		}
+		if !reflect.TypeOf(value).AssignableTo(typ) {
+			return fail()
+		}
+		res.Set(reflect.ValueOf(value))
+	default:
+		return fail()
+	}
+
+	return res, nil
+}
@@ -0,0 +1,67 @@
+package maz
+
+import (
+	"fmt"
+	"maz-lang/ast"
+	"maz-lang/object"
+	"strings"
+)
+
+// SyntaxError is returned when the source cannot be parsed.
+type SyntaxError struct {
+	Errors []*ast.SyntaxError
+	src    string
+}
+
+func (e *SyntaxError) Error() string {
+	var msgs []string
+	for _, err := range e.Errors {
+		msgs = append(msgs, err.Error())
+	}
+
+	return strings.Join(msgs, "\n")
+}
+
+// Describe is like Error, but every error is followed by the offending line
+// of the source.
+func (e *SyntaxError) Describe() string {
+	var msgs []string
+	for _, err := range e.Errors {
+		msgs = append(msgs, err.Describe(e.src))
+	}
+
+	return strings.Join(msgs, "\n")
+}
+
+// RuntimeError is returned when the evaluation fails.
+type RuntimeError struct {
+	Err *object.Error
+	src string
+}
+
+func (e *RuntimeError) Error() string {
+	if !e.Err.Loc.Start.IsValid() {
+		return e.Err.Inspect()
+	}
+
+	return fmt.Sprintf("%s: %s", e.Err.Loc.Start, e.Err.Inspect())
+}
+
+// Describe is like Error, but it is followed by the offending line of the source.
+func (e *RuntimeError) Describe() string {
+	return e.Err.Describe(e.src)
+}
+
+// Unwrap returns the error of a failed Go function called by the program.
+func (e *RuntimeError) Unwrap() error {
+	return e.Err.Value
+}
+
+// ExitError is returned when the program calls exit().
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
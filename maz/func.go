@@ -0,0 +1,85 @@
+package maz
+
+import (
+	"fmt"
+	"maz-lang/evaluator"
+	"maz-lang/object"
+	"reflect"
+)
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// Func wraps the Go function fn in a builtin called name.
+//
+// The arguments of a call are converted to the types of the parameters of fn,
+// variadic functions are supported. fn can return nothing, a value, an error
+// or a value followed by an error: the value is converted with ToObject and
+// a non nil error makes the call fail.
+func Func(name string, fn any) (*object.Builtin, error) {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return nil, fmt.Errorf("maz: %s is not a function, instead got %T", name, fn)
+	}
+
+	typ := v.Type()
+	switch {
+	case typ.NumOut() > 2:
+		return nil, fmt.Errorf("maz: %s returns more than 2 values", name)
+	case typ.NumOut() == 2 && typ.Out(1) != errorType:
+		return nil, fmt.Errorf("maz: the second result of %s must be an error", name)
+	}
+
+	call := func(args ...object.Object) object.Object {
+		in, errObj := funcArgs(name, typ, args)
+		if errObj != nil {
+			return errObj
+		}
+
+		out := v.Call(in)
+		if len(out) > 0 && out[len(out)-1].Type() == errorType {
+			if err := out[len(out)-1]; !err.IsNil() {
+				return &object.Error{Value: err.Interface().(error)}
+			}
+			out = out[:len(out)-1]
+		}
+		if len(out) == 0 {
+			return &evaluator.NULL
+		}
+
+		res, err := ToObject(out[0].Interface())
+		if err != nil {
+			return &object.Error{Value: fmt.Errorf("%s(): %w", name, err)}
+		}
+		return res
+	}
+
+	return &object.Builtin{Name: name, Fn: call}, nil
+}
+
+// funcArgs converts the arguments of a call to the parameters of typ.
+func funcArgs(name string, typ reflect.Type, args []object.Object) ([]reflect.Value, *object.Error) {
+	params := typ.NumIn()
+	if typ.IsVariadic() {
+		if len(args) < params-1 {
+			return nil, &object.Error{Value: fmt.Errorf("%s() takes at least %d arguments, instead got %d", name, params-1, len(args))}
+		}
+	} else if len(args) != params {
+		return nil, &object.Error{Value: fmt.Errorf("%s() takes %d arguments, instead got %d", name, params, len(args))}
+	}
+
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		paramType := typ.In(min(i, params-1))
+		if typ.IsVariadic() && i >= params-1 {
+			paramType = paramType.Elem()
+		}
+
+		v, err := fromObject(arg, paramType)
+		if err != nil {
+			return nil, &object.Error{Value: fmt.Errorf("argument %d of %s(): %w", i+1, name, err)}
+		}
+		in[i] = v
+	}
+
+	return in, nil
+}
@@ -0,0 +1,146 @@
+// Package maz embeds the maz interpreter in Go programs.
+//
+//	interp := maz.New()
+//	interp.RegisterFunc("double", func(n int) int { return n * 2 })
+//	res, err := interp.Run("double(21)")
+package maz
+
+import (
+	"context"
+	"fmt"
+	"maz-lang/environment"
+	"maz-lang/evaluator"
+	"maz-lang/lexer"
+	"maz-lang/object"
+	"maz-lang/parser"
+	"maz-lang/token"
+	"reflect"
+)
+
+// Interpreter evaluates maz programs, the globals they define are kept
+// between runs.
+type Interpreter struct {
+	env       environment.Environment
+	evaluator *evaluator.Evaluator
+}
+
+func New() *Interpreter {
+	return &Interpreter{
+		env:       environment.New(),
+		evaluator: evaluator.New(),
+	}
+}
+
+// Evaluator returns the evaluator used by the interpreter, it can be used
+// to change its settings, like the output of print().
+func (i *Interpreter) Evaluator() *evaluator.Evaluator {
+	return i.evaluator
+}
+
+// Run evaluates src and returns the value of its last statement
+// converted with FromObject.
+func (i *Interpreter) Run(src string) (any, error) {
+	return i.RunContext(context.Background(), src)
+}
+
+// RunContext is like Run, but the evaluation stops as soon as ctx is done.
+func (i *Interpreter) RunContext(ctx context.Context, src string) (any, error) {
+	return i.run(ctx, lexer.New(src), src)
+}
+
+// RunFile is like Run, but errors refer to the source as filename.
+func (i *Interpreter) RunFile(filename, src string) (any, error) {
+	return i.run(context.Background(), lexer.NewWithFilename(filename, src), src)
+}
+
+func (i *Interpreter) run(ctx context.Context, l lexer.Lexer, src string) (any, error) {
+	p := parser.New(&l)
+	program := p.Parse(token.EOF)
+	if len(p.Errors()) > 0 {
+		return nil, &SyntaxError{Errors: p.Errors(), src: src}
+	}
+
+	return i.result(i.evaluator.EvalContext(ctx, &program, &i.env), src)
+}
+
+// Call calls the global function name with args, which are converted
+// with ToObject.
+func (i *Interpreter) Call(name string, args ...any) (any, error) {
+	return i.CallContext(context.Background(), name, args...)
+}
+
+// CallContext is like Call, but the evaluation stops as soon as ctx is done.
+func (i *Interpreter) CallContext(ctx context.Context, name string, args ...any) (any, error) {
+	fn := i.env.Get(name)
+	if builtin := i.evaluator.Builtin(name); fn == nil && builtin != nil {
+		fn = builtin
+	}
+	switch fn.(type) {
+	case *object.FunctionDef, *object.Builtin:
+	default:
+		return nil, fmt.Errorf("maz: no function with name '%s'", name)
+	}
+
+	objs := make([]object.Object, len(args))
+	for n, arg := range args {
+		obj, err := ToObject(arg)
+		if err != nil {
+			return nil, fmt.Errorf("maz: argument %d of %s(): %w", n+1, name, err)
+		}
+		objs[n] = obj
+	}
+
+	return i.result(i.evaluator.ApplyContext(ctx, fn, objs), "")
+}
+
+// SetGlobal binds name to value, converted with ToObject.
+func (i *Interpreter) SetGlobal(name string, value any) error {
+	var obj object.Object
+	var err error
+	if reflect.ValueOf(value).Kind() == reflect.Func {
+		obj, err = Func(name, value)
+	} else {
+		obj, err = ToObject(value)
+	}
+	if err != nil {
+		return fmt.Errorf("maz: global '%s': %w", name, err)
+	}
+	i.env.Set(name, obj)
+
+	return nil
+}
+
+// Global returns the value bound to name converted with FromObject,
+// ok is false if name is not defined.
+func (i *Interpreter) Global(name string) (value any, ok bool) {
+	obj := i.env.Get(name)
+	if obj == nil {
+		return nil, false
+	}
+
+	return FromObject(obj), true
+}
+
+// RegisterFunc makes the Go function fn callable as the builtin name,
+// see Func for how arguments and results are converted.
+func (i *Interpreter) RegisterFunc(name string, fn any) error {
+	builtin, err := Func(name, fn)
+	if err != nil {
+		return err
+	}
+	i.evaluator.RegisterBuiltin(name, builtin.Fn)
+
+	return nil
+}
+
+// result turns the outcome of an evaluation into Go values.
+func (i *Interpreter) result(obj object.Object, src string) (any, error) {
+	switch obj := obj.(type) {
+	case *object.Error:
+		return nil, &RuntimeError{Err: obj, src: src}
+	case *object.Exit:
+		return nil, &ExitError{Code: obj.Code}
+	}
+
+	return FromObject(obj), nil
+}
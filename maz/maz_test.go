@@ -0,0 +1,307 @@
+package maz
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/big"
+	"maz-lang/evaluator"
+	"maz-lang/object"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var bigIntComparer = cmp.Comparer(func(a, b *big.Int) bool { return a.Cmp(b) == 0 })
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		Source   string
+		Expected any
+	}{
+		{
+			Source:   "1 + 2",
+			Expected: int64(3),
+		},
+		{
+			Source:   "\"a\" + \"b\"",
+			Expected: "ab",
+		},
+		{
+			Source:   "1.5 * 2",
+			Expected: 3.0,
+		},
+		{
+			Source:   "[1, true, null]",
+			Expected: []any{int64(1), true, nil},
+		},
+		{
+			Source:   "{\"a\": [1], 2: \"b\"}",
+			Expected: map[any]any{"a": []any{int64(1)}, int64(2): "b"},
+		},
+		{
+			Source:   "let a = 1;",
+			Expected: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("running: '%s'\n", tt.Source)
+		res, err := New().Run(tt.Source)
+		if err != nil {
+			t.Errorf("unexpected error: %s\n", err)
+			continue
+		}
+
+		if !cmp.Equal(res, tt.Expected) {
+			t.Errorf("expected %#v, instead got %#v\n", tt.Expected, res)
+		}
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	interp := New()
+
+	_, err := interp.Run("let a = ;")
+	var syntaxErr *SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		t.Fatalf("expected a syntax error, instead got %v\n", err)
+	}
+	if len(syntaxErr.Errors) != 1 {
+		t.Errorf("expected 1 syntax error, instead got %d\n", len(syntaxErr.Errors))
+	}
+
+	_, err = interp.RunFile("main.mz", "let a = 1;\na / 0")
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) {
+		t.Fatalf("expected a runtime error, instead got %v\n", err)
+	}
+	if err.Error() != "main.mz:2:1: division by zero" {
+		t.Errorf("expected error 'main.mz:2:1: division by zero', instead got '%s'\n", err)
+	}
+	if !strings.HasSuffix(runtimeErr.Describe(), "a / 0\n^") {
+		t.Errorf("expected the description to show the source, instead got\n%s\n", runtimeErr.Describe())
+	}
+
+	_, err = interp.Run("exit(4)")
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) || exitErr.Code != 4 {
+		t.Errorf("expected exit status 4, instead got %v\n", err)
+	}
+}
+
+func TestGlobals(t *testing.T) {
+	interp := New()
+
+	globals := map[string]any{
+		"num":   42,
+		"big":   uint64(1 << 63),
+		"names": []string{"a", "b"},
+		"ports": map[string]int{"http": 80},
+		"none":  nil,
+	}
+	for name, value := range globals {
+		if err := interp.SetGlobal(name, value); err != nil {
+			t.Fatalf("unexpected error: %s\n", err)
+		}
+	}
+
+	res, err := interp.Run("[num + 1, big + 1, names[1], ports.http, none]")
+	if err != nil {
+		t.Fatalf("unexpected error: %s\n", err)
+	}
+
+	expected := []any{int64(43), new(big.Int).SetUint64(1<<63 + 1), "b", int64(80), nil}
+	if !cmp.Equal(res, expected, bigIntComparer) {
+		t.Errorf("expected %#v, instead got %#v\n", expected, res)
+	}
+
+	if _, err := interp.Run("let total = num * 2;"); err != nil {
+		t.Fatalf("unexpected error: %s\n", err)
+	}
+	if total, ok := interp.Global("total"); !ok || total != int64(84) {
+		t.Errorf("expected total to be 84, instead got %v\n", total)
+	}
+
+	if err := interp.SetGlobal("ch", make(chan int)); err == nil {
+		t.Errorf("expected an error converting a channel\n")
+	}
+}
+
+func TestCall(t *testing.T) {
+	interp := New()
+	_, err := interp.Run("fn add(a, b) { return a + b; }")
+	if err != nil {
+		t.Fatalf("unexpected error: %s\n", err)
+	}
+
+	res, err := interp.Call("add", 1, 2)
+	if err != nil || res != int64(3) {
+		t.Errorf("expected 3, instead got %v (%v)\n", res, err)
+	}
+
+	res, err = interp.Call("len", []int{1, 2, 3})
+	if err != nil || res != int64(3) {
+		t.Errorf("expected 3, instead got %v (%v)\n", res, err)
+	}
+
+	if _, err := interp.Call("add", 1, "a"); err == nil || !strings.Contains(err.Error(), "type mismatch: INT + STRING") {
+		t.Errorf("expected a type mismatch, instead got %v\n", err)
+	}
+
+	if _, err := interp.Call("missing"); err == nil {
+		t.Errorf("expected an error calling an undefined function\n")
+	}
+}
+
+func TestRegisterFunc(t *testing.T) {
+	errNegative := errors.New("negative number")
+	var out bytes.Buffer
+
+	interp := New()
+	interp.Evaluator().Stdout = &out
+
+	funcs := map[string]any{
+		"double": func(n int) int { return n * 2 },
+		"join":   func(sep string, parts ...string) string { return strings.Join(parts, sep) },
+		"sqrt": func(n float64) (float64, error) {
+			if n < 0 {
+				return 0, errNegative
+			}
+			return n / 2, nil
+		},
+		"log": func(v any) { out.WriteString("log: ") },
+		"sum": func(nums []int64) (total int64) {
+			for _, n := range nums {
+				total += n
+			}
+			return
+		},
+		"kind":  func(obj object.Object) string { return string(obj.Type()) },
+		"small": func(n int8) int8 { return n },
+	}
+	for name, fn := range funcs {
+		if err := interp.RegisterFunc(name, fn); err != nil {
+			t.Fatalf("unexpected error: %s\n", err)
+		}
+	}
+
+	tests := []struct {
+		Source        string
+		Expected      any
+		ExpectedError string
+	}{
+		{
+			Source:   "double(21)",
+			Expected: int64(42),
+		},
+		{
+			Source:   "join(\"-\", \"a\", \"b\", \"c\")",
+			Expected: "a-b-c",
+		},
+		{
+			Source:   "join(\",\")",
+			Expected: "",
+		},
+		{
+			Source:   "sqrt(8)",
+			Expected: 4.0,
+		},
+		{
+			Source:   "log({\"a\": 1})",
+			Expected: nil,
+		},
+		{
+			Source:   "sum([1, 2, 3])",
+			Expected: int64(6),
+		},
+		{
+			Source:   "kind([])",
+			Expected: "ARRAY",
+		},
+		{
+			Source:        "sqrt(-1)",
+			ExpectedError: "1:1: negative number",
+		},
+		{
+			Source:        "double(\"a\")",
+			ExpectedError: "1:1: argument 1 of double(): cannot use STRING as int",
+		},
+		{
+			Source:        "double()",
+			ExpectedError: "1:1: double() takes 1 arguments, instead got 0",
+		},
+		{
+			Source:        "join()",
+			ExpectedError: "1:1: join() takes at least 1 arguments, instead got 0",
+		},
+		{
+			Source:        "small(200)",
+			ExpectedError: "1:1: argument 1 of small(): 200 overflows int8",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("running: '%s'\n", tt.Source)
+		res, err := interp.Run(tt.Source)
+		if tt.ExpectedError != "" {
+			if err == nil || err.Error() != tt.ExpectedError {
+				t.Errorf("expected error '%s', instead got %v\n", tt.ExpectedError, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error: %s\n", err)
+			continue
+		}
+
+		if !cmp.Equal(res, tt.Expected) {
+			t.Errorf("expected %#v, instead got %#v\n", tt.Expected, res)
+		}
+	}
+
+	if _, err := interp.Run("sqrt(-4)"); !errors.Is(err, errNegative) {
+		t.Errorf("expected the error of the Go function, instead got %v\n", err)
+	}
+
+	if out.String() != "log: " {
+		t.Errorf("expected output 'log: ', instead got '%s'\n", out.String())
+	}
+
+	if err := interp.RegisterFunc("bad", 1); err == nil {
+		t.Errorf("expected an error registering a non function\n")
+	}
+	if err := interp.RegisterFunc("bad", func() (int, int) { return 0, 0 }); err == nil {
+		t.Errorf("expected an error registering a function with 2 results\n")
+	}
+}
+
+func TestLimits(t *testing.T) {
+	interp := New()
+	interp.Evaluator().MaxSteps = 10000
+
+	_, err := interp.Run("fn loop() { while true {} } loop()")
+	if !errors.Is(err, evaluator.ErrStepLimit) {
+		t.Errorf("expected the step limit to be exceeded, instead got %v\n", err)
+	}
+
+	// The interpreter is still usable once a limit is hit
+	if res, err := interp.Run("1 + 1"); err != nil || res != int64(2) {
+		t.Errorf("expected 2, instead got %v (%v)\n", res, err)
+	}
+
+	interp.Evaluator().MaxSteps = 0
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := interp.CallContext(ctx, "loop"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the call to be canceled, instead got %v\n", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := interp.RunContext(ctx, "loop()"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the run to time out, instead got %v\n", err)
+	}
+}
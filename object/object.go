@@ -2,19 +2,38 @@ package object
 
 import (
 	"fmt"
+	"math"
+	"math/big"
 	"maz-lang/ast"
+	"maz-lang/code"
+	"maz-lang/token"
+	"slices"
+	"strconv"
+	"strings"
 )
 
 type ObjectType string
 
 const (
-	INTEGER_OBJ = "INT"
-	BOOLEAN_OBJ = "BOOL"
-	NULL_OBJ    = "NULL"
-	ERROR_OBJ   = "ERROR"
-	FUNCDEF_OBJ = "FUNCDEF"
-	RETURN_OBJ  = "RETURN"
-	STRING_OBJ  = "STRING"
+	INTEGER_OBJ     = "INT"
+	BIG_INTEGER_OBJ = "BIGINT"
+	FLOAT_OBJ       = "FLOAT"
+	BOOLEAN_OBJ     = "BOOL"
+	NULL_OBJ        = "NULL"
+	ERROR_OBJ       = "ERROR"
+	FUNCDEF_OBJ     = "FUNCDEF"
+	RETURN_OBJ      = "RETURN"
+	STRING_OBJ      = "STRING"
+	ARRAY_OBJ       = "ARRAY"
+	HASH_OBJ        = "HASH"
+	BUILTIN_OBJ     = "BUILTIN"
+	BREAK_OBJ       = "BREAK"
+	CONTINUE_OBJ    = "CONTINUE"
+	RANGE_OBJ       = "RANGE"
+	EXIT_OBJ        = "EXIT"
+
+	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION"
+	CELL_OBJ              = "CELL"
 )
 
 type Object interface {
@@ -33,6 +52,50 @@ type Integer struct {
 
 func (i *Integer) Type() ObjectType { return INTEGER_OBJ }
 func (i *Integer) Inspect() string  { return fmt.Sprintf("%d", i.Value) }
+func (i *Integer) HashKey() HashKey { return HashKey{Type: i.Type(), Value: uint64(i.Value)} }
+
+// BigInteger is an integer which does not fit in 64 bits.
+type BigInteger struct {
+	Value *big.Int
+}
+
+func (i *BigInteger) Type() ObjectType { return BIG_INTEGER_OBJ }
+func (i *BigInteger) Inspect() string  { return i.Value.String() }
+func (i *BigInteger) HashKey() HashKey { return HashKey{Type: i.Type(), Text: i.Value.String()} }
+
+type Float struct {
+	Value float64
+}
+
+func (f *Float) Type() ObjectType { return FLOAT_OBJ }
+
+// Inspect formats the float so that reading it back gives the same value,
+// whole numbers keep a '.0' to tell them apart from integers.
+func (f *Float) Inspect() string {
+	format := byte('f')
+	if abs := math.Abs(f.Value); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'g'
+	}
+
+	res := strconv.FormatFloat(f.Value, format, -1, 64)
+	if strings.ContainsAny(res, ".eIN") {
+		return res
+	}
+	return res + ".0"
+}
+
+// HashKey returns the key of the equal integer for whole numbers, since
+// 1.0 == 1, which also makes 0.0 and -0.0 the same key.
+func (f *Float) HashKey() HashKey {
+	if f.Value == math.Trunc(f.Value) && !math.IsInf(f.Value, 0) {
+		if f.Value >= math.MinInt64 && f.Value < math.MaxInt64 {
+			return (&Integer{Value: int64(f.Value)}).HashKey()
+		}
+		value, _ := big.NewFloat(f.Value).Int(nil)
+		return (&BigInteger{Value: value}).HashKey()
+	}
+	return HashKey{Type: f.Type(), Value: math.Float64bits(f.Value)}
+}
 
 type Boolean struct {
 	Value bool
@@ -40,6 +103,12 @@ type Boolean struct {
 
 func (b *Boolean) Type() ObjectType { return BOOLEAN_OBJ }
 func (b *Boolean) Inspect() string  { return fmt.Sprintf("%v", b.Value) }
+func (b *Boolean) HashKey() HashKey {
+	if b.Value {
+		return HashKey{Type: b.Type(), Value: 1}
+	}
+	return HashKey{Type: b.Type(), Value: 0}
+}
 
 type String struct {
 	Value string
@@ -47,14 +116,103 @@ type String struct {
 
 func (s *String) Type() ObjectType { return STRING_OBJ }
 func (s *String) Inspect() string  { return fmt.Sprintf("%v", s.Value) }
+func (s *String) HashKey() HashKey { return HashKey{Type: s.Type(), Text: s.Value} }
+
+// ErrorKind tells runtime errors apart from the evaluation being stopped
+// because it hit one of its limits or was canceled.
+type ErrorKind int
+
+const (
+	RuntimeError ErrorKind = iota
+	StepLimitError
+	DepthLimitError
+	AllocationLimitError
+	CanceledError
+)
+
+var errorKinds = [...]string{
+	RuntimeError:         "runtime error",
+	StepLimitError:       "step limit",
+	DepthLimitError:      "depth limit",
+	AllocationLimitError: "allocation limit",
+	CanceledError:        "canceled",
+}
+
+func (k ErrorKind) String() string {
+	if int(k) < len(errorKinds) {
+		return errorKinds[k]
+	}
+	return fmt.Sprintf("ErrorKind(%d)", int(k))
+}
 
 type Error struct {
 	Value error
+	Kind  ErrorKind
+	// Loc is the span of the innermost node that caused the error.
+	Loc token.Span
+	// Stack holds the function calls which led to the error,
+	// the innermost comes first.
+	Stack []Frame
+}
+
+// Frame is a function call, Loc is where the function was called from
+// and it is not valid for calls coming from Go.
+type Frame struct {
+	Function string
+	Loc      token.Position
+}
+
+func (f Frame) String() string {
+	if !f.Loc.IsValid() {
+		return fmt.Sprintf("at %s", f.Function)
+	}
+	return fmt.Sprintf("at %s (%s)", f.Function, f.Loc)
+}
+
+// maxTraceFrames is how many frames StackTrace shows, the ones in the
+// middle of deeper stacks are left out.
+const maxTraceFrames = 20
+
+// StackTrace returns the frames of the stack, one per line.
+func (e *Error) StackTrace() string {
+	var lines []string
+	for _, frame := range e.Stack {
+		lines = append(lines, "\t"+frame.String())
+	}
+
+	if len(lines) > maxTraceFrames {
+		omitted := fmt.Sprintf("\t... %d more frames", len(lines)-maxTraceFrames)
+		lines = slices.Concat(lines[:maxTraceFrames/2], []string{omitted}, lines[len(lines)-maxTraceFrames/2:])
+	}
+
+	return strings.Join(lines, "\n")
 }
 
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
 func (e *Error) Inspect() string  { return e.Value.Error() }
 
+// Describe returns the error prefixed by its position and followed by the
+// offending line of src with a caret pointing at the error.
+func (e *Error) Describe(src string) string {
+	if se, ok := e.Value.(*ast.SyntaxError); ok {
+		return se.Describe(src)
+	}
+
+	msg := strings.TrimSpace(e.Value.Error())
+	if !e.Loc.Start.IsValid() {
+		return msg
+	}
+
+	res := fmt.Sprintf("%s: %s", e.Loc.Start, msg)
+	if excerpt := token.Excerpt(src, e.Loc.Start); excerpt != "" {
+		res += "\n" + excerpt
+	}
+	if len(e.Stack) > 0 {
+		res += "\n" + e.StackTrace()
+	}
+	return res
+}
+
 type Return struct {
 	Value Object
 }
@@ -62,9 +220,203 @@ type Return struct {
 func (r *Return) Type() ObjectType { return RETURN_OBJ }
 func (r *Return) Inspect() string  { return r.Value.Inspect() }
 
+// Break and Continue unwind the statements of a loop body, like Return
+// does for the body of a function.
+type Break struct{}
+
+func (b *Break) Type() ObjectType { return BREAK_OBJ }
+func (b *Break) Inspect() string  { return "break" }
+
+type Continue struct{}
+
+func (c *Continue) Type() ObjectType { return CONTINUE_OBJ }
+func (c *Continue) Inspect() string  { return "continue" }
+
+// Exit is returned by exit(), it unwinds the whole program which then
+// terminates with Code.
+type Exit struct {
+	Code int
+}
+
+func (e *Exit) Type() ObjectType { return EXIT_OBJ }
+func (e *Exit) Inspect() string  { return fmt.Sprintf("exit(%d)", e.Code) }
+
+// Range is the sequence of integers going from Start up to End (excluded) by Step.
+type Range struct {
+	Start int64
+	End   int64
+	Step  int64
+}
+
+func (r *Range) Type() ObjectType { return RANGE_OBJ }
+func (r *Range) Inspect() string {
+	if r.Step == 1 {
+		return fmt.Sprintf("range(%d, %d)", r.Start, r.End)
+	}
+	return fmt.Sprintf("range(%d, %d, %d)", r.Start, r.End, r.Step)
+}
+
+// Environment is the scope a function was defined in.
+// It is implemented by environment.Environment and declared here
+// since the environment package depends on this one.
+type Environment interface {
+	Get(name string) Object
+	Set(name string, value Object)
+}
+
+// FunctionDef is a closure: a function definition along with
+// the environment it was defined in.
 type FunctionDef struct {
-	Fn ast.FunctionDefinition
+	Fn  ast.FunctionDefinition
+	Env Environment
 }
 
 func (f *FunctionDef) Type() ObjectType { return FUNCDEF_OBJ }
-func (f *FunctionDef) Inspect() string  { return fmt.Sprintf("<fn %s>", f.Fn.Name) }
+func (f *FunctionDef) Inspect() string {
+	if f.Fn.Name == "" {
+		return "<fn>"
+	}
+	return fmt.Sprintf("<fn %s>", f.Fn.Name)
+}
+
+// CompiledFunction is a function compiled to bytecode.
+type CompiledFunction struct {
+	Instructions  code.Instructions
+	NumLocals     int
+	NumParameters int
+	Name          string
+	Debug         code.DebugInfo
+}
+
+func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_OBJ }
+func (cf *CompiledFunction) Inspect() string {
+	return fmt.Sprintf("<compiled fn %s>", cf.Name)
+}
+
+// Closure is a compiled function along with the variables it captured,
+// for the program it is the same as FunctionDef.
+type Closure struct {
+	Fn   *CompiledFunction
+	Free []*Cell
+}
+
+func (c *Closure) Type() ObjectType { return FUNCDEF_OBJ }
+func (c *Closure) Inspect() string {
+	if c.Fn.Name == "" {
+		return "<fn>"
+	}
+	return fmt.Sprintf("<fn %s>", c.Fn.Name)
+}
+
+// Cell holds a variable captured by a closure, so that the closure and
+// the function which declared the variable share its value.
+type Cell struct {
+	Value Object
+}
+
+func (c *Cell) Type() ObjectType { return CELL_OBJ }
+func (c *Cell) Inspect() string  { return "<cell>" }
+
+type Array struct {
+	Elements []Object
+}
+
+func (a *Array) Type() ObjectType { return ARRAY_OBJ }
+func (a *Array) Inspect() string {
+	var elements []string
+	for _, el := range a.Elements {
+		elements = append(elements, inspectElement(el))
+	}
+
+	return fmt.Sprintf("[%s]", strings.Join(elements, ", "))
+}
+
+// inspectElement is like Inspect but quotes strings,
+// it is used to print the content of collections.
+func inspectElement(obj Object) string {
+	if str, ok := obj.(*String); ok {
+		return strconv.Quote(str.Value)
+	}
+	return obj.Inspect()
+}
+
+// BuiltinFunction is the signature of functions implemented in Go.
+type BuiltinFunction func(args ...Object) Object
+
+type Builtin struct {
+	Name string
+	Fn   BuiltinFunction
+}
+
+func (b *Builtin) Type() ObjectType { return BUILTIN_OBJ }
+func (b *Builtin) Inspect() string  { return fmt.Sprintf("<builtin %s>", b.Name) }
+
+// HashKey identifies a key of a hash, two objects with the same HashKey are the same key.
+type HashKey struct {
+	Type  ObjectType
+	Value uint64
+	Text  string
+}
+
+// Hashable is implemented by the objects that can be used as keys of a hash.
+type Hashable interface {
+	Object
+	HashKey() HashKey
+}
+
+type HashPair struct {
+	Key   Object
+	Value Object
+}
+
+// Hash is a dictionary that remembers the order in which keys were inserted.
+type Hash struct {
+	Pairs map[HashKey]HashPair
+	Order []HashKey
+}
+
+func NewHash() *Hash {
+	return &Hash{Pairs: make(map[HashKey]HashPair)}
+}
+
+func (h *Hash) Type() ObjectType { return HASH_OBJ }
+func (h *Hash) Inspect() string {
+	var pairs []string
+	for _, key := range h.Order {
+		pair := h.Pairs[key]
+		pairs = append(pairs, fmt.Sprintf("%s: %s", inspectElement(pair.Key), inspectElement(pair.Value)))
+	}
+
+	return fmt.Sprintf("{%s}", strings.Join(pairs, ", "))
+}
+
+func (h *Hash) Get(key Hashable) (Object, bool) {
+	pair, ok := h.Pairs[key.HashKey()]
+	return pair.Value, ok
+}
+
+func (h *Hash) Set(key Hashable, value Object) {
+	hashKey := key.HashKey()
+	if _, ok := h.Pairs[hashKey]; !ok {
+		h.Order = append(h.Order, hashKey)
+	}
+	h.Pairs[hashKey] = HashPair{Key: key, Value: value}
+}
+
+// Delete removes key from the hash and reports whether it was present.
+func (h *Hash) Delete(key Hashable) bool {
+	hashKey := key.HashKey()
+	if _, ok := h.Pairs[hashKey]; !ok {
+		return false
+	}
+
+	delete(h.Pairs, hashKey)
+	for i, k := range h.Order {
+		if k == hashKey {
+			h.Order = append(h.Order[:i], h.Order[i+1:]...)
+			break
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,171 @@
+package optimizer
+
+import (
+	"maz-lang/ast"
+	"maz-lang/token"
+)
+
+// maxInlineNodes is the size of the largest expression which is inlined.
+const maxInlineNodes = 16
+
+// inlinableFunctions returns the functions defined at the top level of
+// program whose body is a single return of an expression made only of
+// literals, operators and parameters. Such functions cannot be recursive
+// and always compute the same value from the same arguments.
+//
+// Their names must not be bound anywhere else, so that every call with
+// that name refers to them.
+func inlinableFunctions(program *ast.Program) map[string]*ast.FunctionDefinition {
+	bindings := map[string]int{}
+	ast.Inspect(program, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.LetStatement:
+			bindings[node.Ident]++
+		case *ast.ForStatement:
+			bindings[node.Variable]++
+		case *ast.FunctionDefinition:
+			if node.Name != "" {
+				bindings[node.Name]++
+			}
+			for _, param := range node.Parameters {
+				if param, ok := param.(*ast.Identifier); ok {
+					bindings[param.Name]++
+				}
+			}
+		case *ast.AssignExpression:
+			if target, ok := node.Target.(*ast.Identifier); ok {
+				bindings[target.Name] += 2
+			}
+		}
+		return true
+	})
+
+	res := map[string]*ast.FunctionDefinition{}
+	for _, stmt := range program.Statements {
+		fn, ok := stmt.(*ast.FunctionDefinition)
+		if !ok || fn.Name == "" || bindings[fn.Name] != 1 || len(fn.Body) != 1 {
+			continue
+		}
+		ret, ok := fn.Body[0].(*ast.ReturnStatement)
+		if !ok || ret.Expression == nil {
+			continue
+		}
+
+		params := map[string]bool{}
+		for _, param := range fn.Parameters {
+			if param, ok := param.(*ast.Identifier); ok {
+				params[param.Name] = true
+			}
+		}
+		if len(params) == len(fn.Parameters) && isPure(ret.Expression, params) {
+			res[fn.Name] = fn
+		}
+	}
+
+	return res
+}
+
+// isPure reports whether node is a small expression which only uses
+// literals, operators and the given parameters.
+func isPure(node ast.Node, params map[string]bool) bool {
+	pure, size := true, 0
+	ast.Inspect(node, func(node ast.Node) bool {
+		size++
+		switch node := node.(type) {
+		case *ast.Identifier:
+			pure = pure && params[node.Name]
+		case *ast.IntegerLiteral, *ast.FloatLiteral, *ast.StringLiteral, *ast.BooleanLiteral,
+			*ast.PrefixExpression, *ast.InfixExpression, *ast.IndexExpression, *ast.ArrayLiteral:
+		default:
+			pure = false
+		}
+		return pure
+	})
+
+	return pure && size <= maxInlineNodes
+}
+
+// inline returns the expression computed by a call to an inlinable
+// function, or nil if the call must be kept. The arguments must be
+// literals or variables, which can be used more than once and in any order.
+func (o *optimizer) inline(call *ast.CallExpression) ast.Node {
+	callee, ok := call.Callee.(*ast.Identifier)
+	if !ok {
+		return nil
+	}
+	fn, ok := o.inlinable[callee.Name]
+	// Calls which come before the definition may run before the function exists
+	if !ok || call.Loc.Start.Offset < fn.Loc.End.Offset || len(call.Arguments) != len(fn.Parameters) {
+		return nil
+	}
+
+	args := map[string]ast.Node{}
+	for i, arg := range call.Arguments {
+		switch arg.(type) {
+		case *ast.Identifier, *ast.IntegerLiteral, *ast.FloatLiteral, *ast.StringLiteral, *ast.BooleanLiteral:
+		default:
+			return nil
+		}
+		args[fn.Parameters[i].(*ast.Identifier).Name] = arg
+	}
+
+	return substitute(fn.Body[0].(*ast.ReturnStatement).Expression, args, call.Loc)
+}
+
+// substitute returns a copy of node where the parameters are replaced by
+// copies of the arguments. The copies are located at loc, the span of the
+// call, so that the errors they raise point at the call.
+func substitute(node ast.Node, args map[string]ast.Node, loc token.Span) ast.Node {
+	switch node := node.(type) {
+	case *ast.Identifier:
+		if arg, ok := args[node.Name]; ok {
+			return substitute(arg, nil, loc)
+		}
+		res := *node
+		res.Loc = loc
+		return &res
+	case *ast.IntegerLiteral:
+		res := *node
+		res.Loc = loc
+		return &res
+	case *ast.FloatLiteral:
+		res := *node
+		res.Loc = loc
+		return &res
+	case *ast.StringLiteral:
+		res := *node
+		res.Loc = loc
+		return &res
+	case *ast.BooleanLiteral:
+		res := *node
+		res.Loc = loc
+		return &res
+	case *ast.PrefixExpression:
+		res := *node
+		res.Loc = loc
+		res.Value = substitute(node.Value, args, loc)
+		return &res
+	case *ast.InfixExpression:
+		res := *node
+		res.Loc = loc
+		res.Left = substitute(node.Left, args, loc)
+		res.Right = substitute(node.Right, args, loc)
+		return &res
+	case *ast.IndexExpression:
+		res := *node
+		res.Loc = loc
+		res.Left = substitute(node.Left, args, loc)
+		res.Index = substitute(node.Index, args, loc)
+		return &res
+	case *ast.ArrayLiteral:
+		res := *node
+		res.Loc = loc
+		res.Elements = make([]ast.Node, len(node.Elements))
+		for i, elem := range node.Elements {
+			res.Elements[i] = substitute(elem, args, loc)
+		}
+		return &res
+	}
+
+	return node
+}
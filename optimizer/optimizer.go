@@ -0,0 +1,330 @@
+// Package optimizer rewrites programs into equivalent ones that do less
+// work: operations on literals are computed once, branches whose condition
+// is known are removed or made unconditional, nothing is kept after a
+// return, break or continue, and small functions can be inlined.
+package optimizer
+
+import (
+	"math"
+	"maz-lang/ast"
+	"maz-lang/environment"
+	"maz-lang/evaluator"
+	"maz-lang/object"
+	"maz-lang/resolver"
+	"maz-lang/token"
+)
+
+// Options selects the optimizations which are not always applied.
+type Options struct {
+	// Inline replaces the calls to small functions, whose body only returns
+	// an expression of their parameters, with that expression. Inlined
+	// calls do not show up in the stack trace of errors.
+	Inline bool
+}
+
+type optimizer struct {
+	e *evaluator.Evaluator
+	// inlinable holds the functions whose calls can be inlined.
+	inlinable map[string]*ast.FunctionDefinition
+}
+
+// Optimize rewrites program in place. Programs with mistakes found before
+// running them are left as they are, the code removed as dead could hide
+// the mistakes from the evaluator and the compiler which report them.
+func Optimize(program *ast.Program, opts Options) {
+	env := environment.New()
+	if err := resolver.Resolve(program, &env); err != nil {
+		return
+	}
+
+	o := &optimizer{e: evaluator.New()}
+	if opts.Inline {
+		o.inlinable = inlinableFunctions(program)
+	}
+
+	// A return only leaves the top level statement it is in, so the
+	// statements of the program are all kept.
+	program.Statements = o.statements(program.Statements, false)
+}
+
+// statements optimizes a list of statements, those of a block stop at the
+// first return, break or continue.
+func (o *optimizer) statements(nodes []ast.Node, block bool) []ast.Node {
+	var res []ast.Node
+
+	for i, node := range nodes {
+		node = o.optimize(node)
+
+		if stmt, ok := node.(*ast.IfStatement); ok {
+			if branch, ok := o.splice(stmt, i == len(nodes)-1, block); ok {
+				res = append(res, branch...)
+			} else {
+				res = append(res, stmt)
+			}
+		} else {
+			res = append(res, node)
+		}
+
+		if block && len(res) > 0 && isJump(res[len(res)-1]) {
+			break
+		}
+	}
+
+	return res
+}
+
+func isJump(node ast.Node) bool {
+	switch node.(type) {
+	case *ast.ReturnStatement, *ast.BreakStatement, *ast.ContinueStatement:
+		return true
+	}
+
+	return false
+}
+
+func (o *optimizer) optimize(node ast.Node) ast.Node {
+	switch node := node.(type) {
+	case *ast.PrefixExpression:
+		node.Value = o.optimize(node.Value)
+		return o.foldPrefix(node)
+	case *ast.InfixExpression:
+		node.Left = o.optimize(node.Left)
+		node.Right = o.optimize(node.Right)
+		return o.foldInfix(node)
+	case *ast.LetStatement:
+		node.Value = o.optimize(node.Value)
+	case *ast.IfStatement:
+		node.MainCondition = o.optimize(node.MainCondition)
+		node.MainStatements = o.statements(node.MainStatements, true)
+		for i := range node.ElseIfs {
+			node.ElseIfs[i].Condition = o.optimize(node.ElseIfs[i].Condition)
+			node.ElseIfs[i].Statements = o.statements(node.ElseIfs[i].Statements, true)
+		}
+		node.ElseStatements = o.statements(node.ElseStatements, true)
+		prune(node)
+	case *ast.WhileStatement:
+		node.Condition = o.optimize(node.Condition)
+		node.Statements = o.statements(node.Statements, true)
+	case *ast.ForStatement:
+		node.Iterable = o.optimize(node.Iterable)
+		node.Statements = o.statements(node.Statements, true)
+	case *ast.ReturnStatement:
+		node.Expression = o.optimize(node.Expression)
+	case *ast.FunctionDefinition:
+		node.Body = o.statements(node.Body, true)
+	case *ast.CallExpression:
+		node.Callee = o.optimize(node.Callee)
+		for i := range node.Arguments {
+			node.Arguments[i] = o.optimize(node.Arguments[i])
+		}
+		if expr := o.inline(node); expr != nil {
+			return o.optimize(expr)
+		}
+	case *ast.MemberExpression:
+		node.Object = o.optimize(node.Object)
+	case *ast.ArrayLiteral:
+		for i := range node.Elements {
+			node.Elements[i] = o.optimize(node.Elements[i])
+		}
+	case *ast.IndexExpression:
+		node.Left = o.optimize(node.Left)
+		node.Index = o.optimize(node.Index)
+	case *ast.HashLiteral:
+		for i := range node.Pairs {
+			node.Pairs[i].Key = o.optimize(node.Pairs[i].Key)
+			node.Pairs[i].Value = o.optimize(node.Pairs[i].Value)
+		}
+	case *ast.AssignExpression:
+		node.Target = o.optimize(node.Target)
+		node.Value = o.optimize(node.Value)
+	}
+
+	return node
+}
+
+// literal returns the value of a literal node.
+func literal(node ast.Node) (object.Object, bool) {
+	switch node := node.(type) {
+	case *ast.IntegerLiteral:
+		return &object.Integer{Value: node.Value}, true
+	case *ast.FloatLiteral:
+		return &object.Float{Value: node.Value}, true
+	case *ast.StringLiteral:
+		return &object.String{Value: node.Value}, true
+	case *ast.BooleanLiteral:
+		if node.Value {
+			return &evaluator.TRUE, true
+		}
+		return &evaluator.FALSE, true
+	}
+
+	return nil, false
+}
+
+// toLiteral returns a literal node for obj in place of node, or node itself
+// if obj cannot be written as a literal, like errors.
+func toLiteral(obj object.Object, node ast.Node) ast.Node {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return &ast.IntegerLiteral{Value: obj.Value, Loc: node.Span()}
+	case *object.Float:
+		if !math.IsInf(obj.Value, 0) && !math.IsNaN(obj.Value) {
+			return &ast.FloatLiteral{Value: obj.Value, Loc: node.Span()}
+		}
+	case *object.String:
+		return &ast.StringLiteral{Value: obj.Value, Loc: node.Span()}
+	case *object.Boolean:
+		return &ast.BooleanLiteral{Value: obj.Value, Loc: node.Span()}
+	}
+
+	return node
+}
+
+func (o *optimizer) foldPrefix(node *ast.PrefixExpression) ast.Node {
+	value, ok := literal(node.Value)
+	if !ok {
+		return node
+	}
+	// Like for && and ||, the negation of other values depends on whether
+	// the evaluator is strict
+	if _, ok := value.(*object.Boolean); !ok && node.Prefix.Type == token.BANG {
+		return node
+	}
+
+	return toLiteral(o.e.Prefix(node.Prefix.Literal, value), node)
+}
+
+// foldInfix computes operations on literals, those which fail are left to
+// the runtime, which reports the error where and when it happens.
+func (o *optimizer) foldInfix(node *ast.InfixExpression) ast.Node {
+	left, ok := literal(node.Left)
+	if !ok {
+		return node
+	}
+	right, ok := literal(node.Right)
+	if !ok {
+		return node
+	}
+
+	switch {
+	case node.Operator.Type == token.AND || node.Operator.Type == token.OR:
+		// Other values depend on whether the evaluator is strict
+		l, lok := left.(*object.Boolean)
+		r, rok := right.(*object.Boolean)
+		if !lok || !rok {
+			return node
+		}
+		if node.Operator.Type == token.AND {
+			return &ast.BooleanLiteral{Value: l.Value && r.Value, Loc: node.Loc}
+		}
+		return &ast.BooleanLiteral{Value: l.Value || r.Value, Loc: node.Loc}
+	case node.Operator.Type == token.ASTERISK && (left.Type() == object.STRING_OBJ || right.Type() == object.STRING_OBJ):
+		// A repeated string can be arbitrarily large
+		return node
+	}
+
+	return toLiteral(o.e.Infix(node.Operator.Literal, left, right), node)
+}
+
+// prune removes the branches of an if statement which can never run. When
+// the branch that runs is known it becomes the main one, with a true
+// condition, and when none can run the condition is false.
+func prune(node *ast.IfStatement) {
+	for {
+		cond, ok := node.MainCondition.(*ast.BooleanLiteral)
+		if !ok {
+			break
+		}
+		if cond.Value {
+			node.ElseIfs, node.ElseStatements = nil, nil
+			return
+		}
+
+		switch {
+		case len(node.ElseIfs) > 0:
+			node.MainCondition, node.MainStatements = node.ElseIfs[0].Condition, node.ElseIfs[0].Statements
+			node.ElseIfs = node.ElseIfs[1:]
+		case len(node.ElseStatements) > 0:
+			node.MainCondition = &ast.BooleanLiteral{Value: true, Loc: cond.Loc}
+			node.MainStatements, node.ElseStatements = node.ElseStatements, nil
+			return
+		default:
+			node.MainStatements = nil
+			return
+		}
+	}
+
+	var elseIfs []ast.ElseIf
+	for _, elseIf := range node.ElseIfs {
+		cond, ok := elseIf.Condition.(*ast.BooleanLiteral)
+		if !ok {
+			elseIfs = append(elseIfs, elseIf)
+			continue
+		}
+		if cond.Value {
+			// The branches after this one can never run
+			node.ElseStatements = elseIf.Statements
+			break
+		}
+	}
+	node.ElseIfs = elseIfs
+}
+
+// splice returns the statements which can replace an if statement whose
+// branch is known, ok is false if the statement must be kept. The branch
+// runs in its own scope, so it is kept if it declares any variable, and
+// the value of the statement matters when it is the last of a block. At
+// the top level a return only leaves the statement it is in, so a branch
+// which returns is kept too.
+func (o *optimizer) splice(node *ast.IfStatement, last, block bool) (stmts []ast.Node, ok bool) {
+	cond, ok := node.MainCondition.(*ast.BooleanLiteral)
+	if !ok || len(node.ElseIfs) > 0 || len(node.ElseStatements) > 0 {
+		return nil, false
+	}
+
+	if !cond.Value || len(node.MainStatements) == 0 {
+		return nil, !last
+	}
+	if declares(node.MainStatements) || !block && returns(node.MainStatements) {
+		return nil, false
+	}
+
+	return node.MainStatements, true
+}
+
+// returns reports whether the statements contain a return, other than
+// those of the functions they define.
+func returns(nodes []ast.Node) bool {
+	res := false
+	for _, node := range nodes {
+		ast.Inspect(node, func(node ast.Node) bool {
+			switch node.(type) {
+			case *ast.ReturnStatement:
+				res = true
+			case *ast.FunctionDefinition:
+				return false
+			}
+			return !res
+		})
+	}
+
+	return res
+}
+
+// declares reports whether the statements bind any name in their scope.
+func declares(nodes []ast.Node) bool {
+	res := false
+	for _, node := range nodes {
+		ast.Inspect(node, func(node ast.Node) bool {
+			switch node := node.(type) {
+			case *ast.LetStatement:
+				res = true
+			case *ast.FunctionDefinition:
+				res = res || node.Name != ""
+			}
+			return !res
+		})
+	}
+
+	return res
+}
@@ -0,0 +1,168 @@
+package optimizer
+
+import (
+	"bytes"
+	"maz-lang/ast"
+	"maz-lang/environment"
+	"maz-lang/evaluator"
+	"maz-lang/lexer"
+	"maz-lang/object"
+	"maz-lang/parser"
+	"maz-lang/token"
+	"strings"
+	"testing"
+)
+
+func parse(src string) *ast.Program {
+	l := lexer.New(src)
+	program := parser.New(&l).Parse(token.EOF)
+
+	return &program
+}
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		Source   string
+		Inline   bool
+		Expected string
+	}{
+		{
+			Source:   "1 + 2 * 3",
+			Expected: "7",
+		},
+		{
+			Source:   "-(2 ** 3) + 0.5",
+			Expected: "-7.5",
+		},
+		{
+			Source:   `"a" + "b" == "ab" && !false`,
+			Expected: "true",
+		},
+		{
+			Source:   `"say \"" + "hi\n" + "\\\u{7}"`,
+			Expected: `"say \"hi\n\\\u{7}"`,
+		},
+		{
+			Source:   "x + 1 * 2",
+			Expected: "(x + 2)",
+		},
+		{
+			Source:   "1 / 0",
+			Expected: "(1 / 0)",
+		},
+		{
+			Source:   "1 && true",
+			Expected: "(1 && true)",
+		},
+		{
+			Source:   "!1",
+			Expected: "(!1)",
+		},
+		{
+			Source:   "if 1 > 2 { a } else { b }",
+			Expected: "b",
+		},
+		{
+			Source:   "if false { a } else if x { b } else if true { c } else { d }",
+			Expected: "if (x\n) {\n\tb\n\n} else {\n\tc\n\n}",
+		},
+		{
+			Source:   "if true { let a = 1; a }",
+			Expected: "if (true\n) {\n\tlet a = 1;\n\n\ta\n\n}",
+		},
+		{
+			Source:   "if false { a } 1",
+			Expected: "1",
+		},
+		{
+			Source:   "fn f() { while x { if true { break; } y } return 1; z }",
+			Expected: "fn f() {\n\twhile (x\n) {\n\tbreak;\n\n}\treturn 1;\n}",
+		},
+		{
+			Source:   "fn sq(n) { return n * n; } sq(3) + sq(x)",
+			Inline:   true,
+			Expected: "fn sq(n) {\n\treturn (n * n);\n}\n(9 + (x * x))",
+		},
+		{
+			Source:   "fn sq(n) { return n * n; } sq(3)",
+			Expected: "fn sq(n) {\n\treturn (n * n);\n}\n(sq(3))",
+		},
+		{
+			Source:   "sq(3); fn sq(n) { return n * n; } sq(a()); sq = 1",
+			Inline:   true,
+			Expected: "(sq(3))\nfn sq(n) {\n\treturn (n * n);\n}\n(sq((a())))\nsq = 1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("optimizing: '%s'\n", tt.Source)
+		program := parse(tt.Source)
+		Optimize(program, Options{Inline: tt.Inline})
+
+		if got := strings.TrimSpace(program.String()); got != tt.Expected {
+			t.Errorf("expected the program to be\n%s\ninstead got\n%s\n", tt.Expected, got)
+		}
+	}
+}
+
+// TestOptimizeEquivalence checks that optimized programs give the same
+// results as the original ones.
+func TestOptimizeEquivalence(t *testing.T) {
+	tests := []string{
+		"let x = 2 * 3; if x > 5 { print(x) } else { print(0) }",
+		"fn f() { 1; if false { 2 } } f()",
+		"fn f() { if true { let a = 1; } let a = 2; return a; } f()",
+		"if true { return 1; } 2",
+		"let r = []; for i in range(5) { if i % 2 == 0 { continue; print(i) } r = r + [i]; } r",
+		"fn inc(a) { return a + 1; } let n = 0; while n < 3 { n = inc(n); } n",
+		`fn greet(name) { return "hi " + name; } greet("bob") + greet(1)`,
+		"fn pair(a, b) { return [a, b][1]; } pair(1, 2) + pair(2 * 2, 3)",
+		"if false { 1 } else if 2 > 1 { if true { \"yes\" } } else { \"no\" }",
+		"9223372036854775807 + 1",
+		`if false { let y = x; let x = 1; } print("ran")`,
+		"fn f() { return 1; let a = b; let b = 2; } f()",
+		`let x = true; if true { if x { return 1; } print("after"); } print("end");`,
+		`fn f(x) { if true { if x { return 1; } print("after"); } print("end"); } f(true)`,
+	}
+
+	for _, src := range tests {
+		t.Logf("evaluating: '%s'\n", src)
+
+		var results [2]string
+		for i, optimize := range []bool{false, true} {
+			program := parse(src)
+			if optimize {
+				Optimize(program, Options{Inline: true})
+			}
+
+			var out bytes.Buffer
+			e := evaluator.New()
+			e.Stdout = &out
+			env := environment.New()
+			obj := e.Eval(program, &env)
+			results[i] = obj.Inspect() + " " + out.String()
+		}
+
+		if results[0] != results[1] {
+			t.Errorf("expected %q, instead got %q once optimized\n", results[0], results[1])
+		}
+	}
+}
+
+// TestInlineLocation checks that the errors raised by an inlined call
+// point at the call rather than at the body of the function.
+func TestInlineLocation(t *testing.T) {
+	src := "fn half(n) { return n / 2; }\nlet s = \"a\";\nhalf(s)"
+	program := parse(src)
+	Optimize(program, Options{Inline: true})
+
+	env := environment.New()
+	obj := evaluator.New().Eval(program, &env)
+	err, ok := obj.(*object.Error)
+	if !ok {
+		t.Fatalf("expected an error, instead got %s\n", obj.Inspect())
+	}
+	if err.Loc.Start.Line != 3 || err.Loc.Start.Column != 1 {
+		t.Errorf("expected the error at 3:1, instead got %d:%d\n", err.Loc.Start.Line, err.Loc.Start.Column)
+	}
+}
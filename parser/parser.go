@@ -1,6 +1,8 @@
 package parser
 
 import (
+	"errors"
+	"math"
 	"maz-lang/ast"
 	"maz-lang/lexer"
 	"maz-lang/token"
@@ -11,10 +13,18 @@ import (
 const (
 	_ int = iota
 	LOWEST
+	ASSIGN
+	OR
+	AND
 	EQUAL
+	BIT_OR
+	BIT_XOR
+	BIT_AND
+	SHIFT
 	PLUS
 	PRODUCT
 	PREFIX
+	POWER
 	PAREN
 )
 
@@ -28,20 +38,61 @@ const (
 	ErrExpectedBlock             = "expected block"
 	ErrExpectedParenthesis       = "expected parenthesis"
 	ErrInvalidFunctionParameters = "function has invalid parameters"
+	ErrIllegalToken              = "illegal token"
+	ErrExpectedComma             = "expected ','"
+	ErrExpectedBracket           = "expected ']'"
+	ErrExpectedBrace             = "expected '}'"
+	ErrExpectedColon             = "expected ':'"
+	ErrInvalidAssignment         = "invalid assignment target"
+	ErrExpectedProperty          = "expected property name"
+	ErrExpectedIn                = "expected 'in'"
+	ErrUnterminatedComment       = "unterminated comment"
+	ErrUnterminatedString        = "unterminated string"
+	ErrInvalidEscape             = "invalid escape sequence"
+	ErrFloatOutOfRange           = "float literal out of range"
+	ErrIntegerOutOfRange         = "integer literal out of range"
+	ErrInvalidNumber             = "invalid number literal"
 )
 
+// illegalTokens holds the message of the tokens the lexer could not read.
+var illegalTokens = map[token.TokenType]string{
+	token.ILLEGAL:              ErrIllegalToken,
+	token.UNTERMINATED_COMMENT: ErrUnterminatedComment,
+	token.UNTERMINATED_STRING:  ErrUnterminatedString,
+	token.INVALID_ESCAPE:       ErrInvalidEscape,
+}
+
 var precedences = map[token.TokenType]int{
 	token.PLUS:     PLUS,
 	token.MINUS:    PLUS,
 	token.ASTERISK: PRODUCT,
 	token.SLASH:    PRODUCT,
+	token.PERCENT:  PRODUCT,
 	token.LPAREN:   PAREN,
+	token.DOT:      PAREN,
+	token.LBRACKET: PAREN,
+	token.ASSIGN:   ASSIGN,
+	token.OR:       OR,
+	token.AND:      AND,
 	token.EQ:       EQUAL,
 	token.NEQ:      EQUAL,
 	token.GT:       EQUAL,
 	token.LT:       EQUAL,
 	token.GTEQ:     EQUAL,
 	token.LTEQ:     EQUAL,
+
+	token.BIT_OR:      BIT_OR,
+	token.BIT_XOR:     BIT_XOR,
+	token.BIT_AND:     BIT_AND,
+	token.SHIFT_LEFT:  SHIFT,
+	token.SHIFT_RIGHT: SHIFT,
+	token.POWER:       POWER,
+
+	token.PLUS_ASSIGN:     ASSIGN,
+	token.MINUS_ASSIGN:    ASSIGN,
+	token.ASTERISK_ASSIGN: ASSIGN,
+	token.SLASH_ASSIGN:    ASSIGN,
+	token.PERCENT_ASSIGN:  ASSIGN,
 }
 
 type Parser struct {
@@ -55,6 +106,8 @@ type Parser struct {
 
 	prefixFns map[token.TokenType]PrefixFn
 	infixFns  map[token.TokenType]InfixFn
+
+	errors []*ast.SyntaxError
 }
 
 type PrefixFn func() ast.Node
@@ -71,7 +124,9 @@ func New(lexer *lexer.Lexer) *Parser {
 
 	p.registerPrefixFn(token.BANG, p.parsePrefixExpression)
 	p.registerPrefixFn(token.MINUS, p.parsePrefixExpression)
+	p.registerPrefixFn(token.BIT_NOT, p.parsePrefixExpression)
 	p.registerPrefixFn(token.INT, p.parseIntegerLiteral)
+	p.registerPrefixFn(token.FLOAT, p.parseFloatLiteral)
 	p.registerPrefixFn(token.TRUE, p.parseBooleanLiteral)
 	p.registerPrefixFn(token.FALSE, p.parseBooleanLiteral)
 	p.registerPrefixFn(token.STRING, p.parseStringLiteral)
@@ -81,18 +136,41 @@ func New(lexer *lexer.Lexer) *Parser {
 	p.registerPrefixFn(token.IF, p.parseIfStatement)
 	p.registerPrefixFn(token.RETURN, p.parseReturnStatement)
 	p.registerPrefixFn(token.FUNCTION, p.parseFunctionDefinition)
+	p.registerPrefixFn(token.LBRACKET, p.parseArrayLiteral)
+	p.registerPrefixFn(token.LBRACE, p.parseHashLiteral)
+	p.registerPrefixFn(token.WHILE, p.parseWhileStatement)
+	p.registerPrefixFn(token.FOR, p.parseForStatement)
+	p.registerPrefixFn(token.BREAK, p.parseBreakStatement)
+	p.registerPrefixFn(token.CONTINUE, p.parseContinueStatement)
 
 	p.registerInfixFn(token.PLUS, p.parseInfixExpression)
 	p.registerInfixFn(token.MINUS, p.parseInfixExpression)
 	p.registerInfixFn(token.ASTERISK, p.parseInfixExpression)
 	p.registerInfixFn(token.SLASH, p.parseInfixExpression)
+	p.registerInfixFn(token.PERCENT, p.parseInfixExpression)
+	p.registerInfixFn(token.BIT_AND, p.parseInfixExpression)
+	p.registerInfixFn(token.BIT_OR, p.parseInfixExpression)
+	p.registerInfixFn(token.BIT_XOR, p.parseInfixExpression)
+	p.registerInfixFn(token.SHIFT_LEFT, p.parseInfixExpression)
+	p.registerInfixFn(token.SHIFT_RIGHT, p.parseInfixExpression)
+	p.registerInfixFn(token.POWER, p.parseInfixExpression)
+	p.registerInfixFn(token.AND, p.parseInfixExpression)
+	p.registerInfixFn(token.OR, p.parseInfixExpression)
 	p.registerInfixFn(token.EQ, p.parseInfixExpression)
 	p.registerInfixFn(token.NEQ, p.parseInfixExpression)
 	p.registerInfixFn(token.GT, p.parseInfixExpression)
 	p.registerInfixFn(token.LT, p.parseInfixExpression)
 	p.registerInfixFn(token.GTEQ, p.parseInfixExpression)
 	p.registerInfixFn(token.LTEQ, p.parseInfixExpression)
-	p.registerInfixFn(token.LPAREN, p.parseFunctionCall)
+	p.registerInfixFn(token.LPAREN, p.parseCallExpression)
+	p.registerInfixFn(token.DOT, p.parseMemberExpression)
+	p.registerInfixFn(token.LBRACKET, p.parseIndexExpression)
+	p.registerInfixFn(token.ASSIGN, p.parseAssignExpression)
+	p.registerInfixFn(token.PLUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfixFn(token.MINUS_ASSIGN, p.parseAssignExpression)
+	p.registerInfixFn(token.ASTERISK_ASSIGN, p.parseAssignExpression)
+	p.registerInfixFn(token.SLASH_ASSIGN, p.parseAssignExpression)
+	p.registerInfixFn(token.PERCENT_ASSIGN, p.parseAssignExpression)
 
 	p.nextToken()
 	p.nextToken()
@@ -108,22 +186,84 @@ func (p *Parser) registerInfixFn(key token.TokenType, fn InfixFn) {
 	p.infixFns[key] = fn
 }
 
+// Parse parses statements until the end token (or EOF) is reached.
+// Statements that fail to parse are left out of the returned program,
+// their errors are collected and can be retrieved with Errors().
 func (p *Parser) Parse(end token.TokenType) ast.Program {
 	var program ast.Program
+	program.Loc.Start = p.curToken.Loc.Start
 
 	for {
 		tok := p.curToken
-		if tok.Type == end || tok.Type == token.ILLEGAL {
+		if tok.Type == end || tok.Type == token.EOF {
+			program.Loc.End = tok.Loc.Start
 			return program
 		}
 
 		node := p.parseExpression(LOWEST, end)
-		if p.isError(node) {
-			program.Statements = []ast.Node{node}
-			return program
+		if err, ok := node.(*ast.SyntaxError); ok {
+			p.errors = append(p.errors, err)
+			p.synchronize(end, tok)
+			continue
 		}
 
 		program.Statements = append(program.Statements, node)
+		// Semicolons after a statement are optional
+		if p.peekTokenIs(token.SEMICOLON) {
+			p.nextToken()
+		}
+		p.nextToken()
+	}
+}
+
+// Errors returns the syntax errors found so far, in the order they were found.
+func (p *Parser) Errors() []*ast.SyntaxError {
+	return p.errors
+}
+
+// synchronize skips tokens until the beginning of the next statement,
+// so that parsing can go on after a syntax error.
+// start is the first token of the statement that failed to parse.
+func (p *Parser) synchronize(end token.TokenType, start token.Token) {
+	// Always make progress, otherwise an error on the first token
+	// of a statement would be reported over and over again.
+	if p.curToken.Loc.Start == start.Loc.Start {
+		p.nextToken()
+	}
+
+	// Blocks opened by the broken statement are skipped as a whole
+	depth := 0
+	for {
+		switch p.curToken.Type {
+		case token.EOF:
+			return
+		case token.LBRACE:
+			depth++
+		case token.RBRACE:
+			if depth == 0 {
+				// The enclosing block is over, leave its '}' to the caller
+				if end == token.RBRACE {
+					return
+				}
+				p.nextToken()
+				return
+			}
+
+			depth--
+			if depth == 0 && !p.peekTokenIs(token.ELSE) {
+				p.nextToken()
+				return
+			}
+		case token.SEMICOLON:
+			if depth == 0 {
+				p.nextToken()
+				return
+			}
+		case token.LET, token.FUNCTION, token.RETURN, token.IF, token.WHILE, token.FOR, token.BREAK, token.CONTINUE:
+			if depth == 0 {
+				return
+			}
+		}
 		p.nextToken()
 	}
 }
@@ -136,6 +276,11 @@ func (p *Parser) nextToken() {
 	p.peekPrecedence = precedences[p.peekToken.Type]
 }
 
+// span returns the span going from start up to the end of the current token.
+func (p *Parser) span(start token.Position) token.Span {
+	return token.Span{Start: start, End: p.curToken.Loc.End}
+}
+
 func (p *Parser) peekTokenIs(token token.TokenType) bool {
 	if p.peekToken.Type == token {
 		return true
@@ -155,12 +300,24 @@ func (p *Parser) isError(node ast.Node) bool {
 
 func (p *Parser) parseExpression(precedence int, endTokens ...token.TokenType) ast.Node {
 	tok := p.curToken
+	if msg, ok := illegalTokens[tok.Type]; ok {
+		return &ast.SyntaxError{Msg: msg, Token: tok}
+	}
+
 	prefixFn, ok := p.prefixFns[tok.Type]
 	if !ok {
 		return &ast.SyntaxError{Msg: ErrExpectedExpression, Token: p.curToken}
 	}
 
 	left := prefixFn()
+	if p.isError(left) {
+		return left
+	}
+
+	// Statements like 'let' consume their own semicolon, nothing can follow them
+	if p.curToken.Type == token.SEMICOLON {
+		return left
+	}
 
 	for precedence < p.peekPrecedence && !slices.Contains(endTokens, p.peekToken.Type) {
 		infixFn, ok := p.infixFns[p.peekToken.Type]
@@ -170,6 +327,9 @@ func (p *Parser) parseExpression(precedence int, endTokens ...token.TokenType) a
 
 		p.nextToken()
 		left = infixFn(left, endTokens...)
+		if p.isError(left) {
+			return left
+		}
 	}
 
 	return left
@@ -178,12 +338,25 @@ func (p *Parser) parseExpression(precedence int, endTokens ...token.TokenType) a
 func (p *Parser) parsePrefixExpression() ast.Node {
 	prefix := p.curToken
 	p.nextToken()
+
+	// The smallest integer can only be written as a negative literal,
+	// since its absolute value does not fit in an int64. It is not when
+	// the next operator binds tighter than '-', like '**' does.
+	if prefix.Type == token.MINUS && p.curToken.Type == token.INT && p.peekPrecedence <= PREFIX {
+		if num, msg := parseInteger("-" + p.curToken.Literal); msg == "" && num == math.MinInt64 {
+			return &ast.IntegerLiteral{Value: num, Loc: p.span(prefix.Loc.Start)}
+		}
+	}
+
 	// NOTE: Well you see how parsePrefixExpression() is being called here? Like it? neither do I.
 	// This is like this due to a bug I found and will stay like this until I find the will to think
 	// of a better solution. I actually know what is the best way to fix this but this is just way
 	// easier so we will stick with this.
 	expression := p.parseExpression(PREFIX, token.EOF, token.SEMICOLON, token.COMMA, token.RPAREN)
-	node := ast.PrefixExpression{Prefix: prefix, Value: expression}
+	if p.isError(expression) {
+		return expression
+	}
+	node := ast.PrefixExpression{Prefix: prefix, Value: expression, Loc: p.span(prefix.Loc.Start)}
 
 	return &node
 }
@@ -191,11 +364,18 @@ func (p *Parser) parsePrefixExpression() ast.Node {
 func (p *Parser) parseInfixExpression(left ast.Node, endTokens ...token.TokenType) ast.Node {
 	node := ast.InfixExpression{Left: left, Operator: p.curToken}
 	p.nextToken()
-	node.Right = p.parseExpression(precedences[node.Operator.Type], endTokens...)
+
+	precedence := precedences[node.Operator.Type]
+	// '**' is right associative: '2 ** 3 ** 2' is '2 ** (3 ** 2)'
+	if node.Operator.Type == token.POWER {
+		precedence--
+	}
+	node.Right = p.parseExpression(precedence, endTokens...)
 
 	if p.isError(node.Right) {
 		return node.Right
 	}
+	node.Loc = p.span(left.Span().Start)
 
 	return &node
 }
@@ -203,6 +383,9 @@ func (p *Parser) parseInfixExpression(left ast.Node, endTokens ...token.TokenTyp
 func (p *Parser) parseParenExpression() ast.Node {
 	p.nextToken()
 	node := p.parseExpression(LOWEST, token.EOF)
+	if p.isError(node) {
+		return node
+	}
 	if !p.peekTokenIs(token.RPAREN) {
 		return &ast.SyntaxError{Msg: ErrUnexpectedParenthesis, Token: p.curToken}
 	}
@@ -212,21 +395,55 @@ func (p *Parser) parseParenExpression() ast.Node {
 }
 
 func (p *Parser) parseIntegerLiteral() ast.Node {
-	num, _ := strconv.Atoi(p.curToken.Literal)
-	node := ast.IntegerLiteral{Value: int64(num)}
+	num, msg := parseInteger(p.curToken.Literal)
+	if msg != "" {
+		return &ast.SyntaxError{Msg: msg, Token: p.curToken}
+	}
+	node := ast.IntegerLiteral{Value: num, Loc: p.curToken.Loc}
 
 	return &node
 }
 
+// parseInteger converts an integer literal, in any of the supported bases, to its value.
+// If the literal is not valid, the returned string is the error message.
+func parseInteger(literal string) (int64, string) {
+	// A leading zero does not mean octal, '0o' must be used instead
+	if len(literal) > 1 && literal[0] == '0' && (isDecimalDigit(literal[1]) || literal[1] == '_') {
+		return 0, ErrInvalidNumber
+	}
+
+	num, err := strconv.ParseInt(literal, 0, 64)
+	if errors.Is(err, strconv.ErrRange) {
+		return 0, ErrIntegerOutOfRange
+	} else if err != nil {
+		return 0, ErrInvalidNumber
+	}
+
+	return num, ""
+}
+
+func isDecimalDigit(char byte) bool {
+	return char >= '0' && char <= '9'
+}
+
+func (p *Parser) parseFloatLiteral() ast.Node {
+	num, err := strconv.ParseFloat(p.curToken.Literal, 64)
+	if err != nil {
+		return &ast.SyntaxError{Msg: ErrFloatOutOfRange, Token: p.curToken}
+	}
+
+	return &ast.FloatLiteral{Value: num, Loc: p.curToken.Loc}
+}
+
 func (p *Parser) parseBooleanLiteral() ast.Node {
 	if p.curToken.Type == token.TRUE {
-		return &ast.BooleanLiteral{Value: true}
+		return &ast.BooleanLiteral{Value: true, Loc: p.curToken.Loc}
 	}
-	return &ast.BooleanLiteral{Value: false}
+	return &ast.BooleanLiteral{Value: false, Loc: p.curToken.Loc}
 }
 
 func (p *Parser) parseStringLiteral() ast.Node {
-	return &ast.StringLiteral{Value: p.curToken.Literal}
+	return &ast.StringLiteral{Value: p.curToken.Literal, Loc: p.curToken.Loc}
 }
 
 // This function does not simply parse an Identifier.
@@ -235,31 +452,30 @@ func (p *Parser) parseStringLiteral() ast.Node {
 // the identifier refers to the name of a function you are calling.
 func (p *Parser) parseIdentifier() ast.Node {
 	name := p.curToken.Literal
-	// if p.peekTokenIs(token.LPAREN) {
-	// 	p.nextToken()
-	// 	args := p.parseArguments()
-	// 	return &ast.FunctionCall{Name: name, Arguments: args}
-	// }
 
-	return &ast.Identifier{Name: name}
+	return &ast.Identifier{Name: name, Loc: p.curToken.Loc}
 }
 
 func (p *Parser) parseLetStatement() ast.Node {
+	start := p.curToken.Loc.Start
+	doc := p.curToken.Doc
+
+	// The errors point at the token found instead of the one expected
 	if !p.peekTokenIs(token.IDENT) {
-		return &ast.SyntaxError{Msg: ErrExpectedIdentifier, Token: p.curToken}
+		return &ast.SyntaxError{Msg: ErrExpectedIdentifier, Token: p.peekToken}
 	}
 
 	p.nextToken()
 	ident := p.curToken.Literal
 
 	if !p.peekTokenIs(token.ASSIGN) {
-		return &ast.SyntaxError{Msg: ErrExpectedAssignment, Token: p.curToken}
+		return &ast.SyntaxError{Msg: ErrExpectedAssignment, Token: p.peekToken}
 	}
 
 	p.nextToken()
 
 	if p.peekTokenIs(token.SEMICOLON) || p.peekTokenIs(token.EOF) {
-		return &ast.SyntaxError{Msg: ErrExpectedExpression, Token: p.curToken}
+		return &ast.SyntaxError{Msg: ErrExpectedExpression, Token: p.peekToken}
 	}
 
 	p.nextToken()
@@ -275,11 +491,12 @@ func (p *Parser) parseLetStatement() ast.Node {
 
 	p.nextToken()
 
-	return &ast.LetStatement{Ident: ident, Value: exp}
+	return &ast.LetStatement{Ident: ident, Value: exp, Doc: doc, Loc: p.span(start)}
 }
 
 func (p *Parser) parseIfStatement() ast.Node {
 	node := ast.IfStatement{}
+	start := p.curToken.Loc.Start
 
 	// Parse main condition
 	p.nextToken()
@@ -289,19 +506,10 @@ func (p *Parser) parseIfStatement() ast.Node {
 	}
 	node.MainCondition = condition
 
-	// Next token must be a '{'
-	if !p.peekTokenIs(token.LBRACE) {
-		return &ast.SyntaxError{Msg: ErrExpectedBlock, Token: p.curToken}
-	}
-
 	// Parse body of main condition
-	p.nextToken()
-	p.nextToken()
-	stmts := p.Parse(token.RBRACE).Statements
-	for _, stmt := range stmts {
-		if p.isError(stmt) {
-			return stmt
-		}
+	stmts, err := p.parseBlock()
+	if err != nil {
+		return err
 	}
 	node.MainStatements = stmts
 
@@ -311,6 +519,7 @@ func (p *Parser) parseIfStatement() ast.Node {
 		if p.peekTokenIs(token.IF) {
 			// Parse else if condition
 			elseIf := ast.ElseIf{}
+			elseIfStart := p.curToken.Loc.Start
 			p.nextToken()
 			p.nextToken()
 
@@ -320,81 +529,147 @@ func (p *Parser) parseIfStatement() ast.Node {
 			}
 			elseIf.Condition = condition
 
-			// Next token must be a '{'
-			if !p.peekTokenIs(token.LBRACE) {
-				return &ast.SyntaxError{Msg: ErrExpectedBlock, Token: p.curToken}
-			}
-
 			// Parse body of else if condition
-			p.nextToken()
-			p.nextToken()
-			stmts = p.Parse(token.RBRACE).Statements
-			for _, stmt := range stmts {
-				if p.isError(stmt) {
-					return stmt
-				}
+			stmts, err = p.parseBlock()
+			if err != nil {
+				return err
 			}
 			elseIf.Statements = stmts
+			elseIf.Loc = p.span(elseIfStart)
 
 			elseIfs = append(elseIfs, elseIf)
 		} else {
 			// Parse body of else condition
-			// Next token must be a '{'
-			if !p.peekTokenIs(token.LBRACE) {
-				return &ast.SyntaxError{Msg: ErrExpectedBlock, Token: p.curToken}
-			}
-			p.nextToken()
-			p.nextToken()
-			stmts = p.Parse(token.RBRACE).Statements
-			for _, stmt := range stmts {
-				if p.isError(stmt) {
-					return stmt
-				}
+			stmts, err = p.parseBlock()
+			if err != nil {
+				return err
 			}
 			node.ElseStatements = stmts
 		}
 	}
 	node.ElseIfs = elseIfs
+	node.Loc = p.span(start)
 
 	return &node
 }
 
+func (p *Parser) parseWhileStatement() ast.Node {
+	start := p.curToken.Loc.Start
+
+	p.nextToken()
+	condition := p.parseExpression(LOWEST, token.LBRACE)
+	if p.isError(condition) {
+		return condition
+	}
+
+	stmts, err := p.parseBlock()
+	if err != nil {
+		return err
+	}
+
+	return &ast.WhileStatement{Condition: condition, Statements: stmts, Loc: p.span(start)}
+}
+
+func (p *Parser) parseForStatement() ast.Node {
+	start := p.curToken.Loc.Start
+
+	if !p.peekTokenIs(token.IDENT) {
+		return &ast.SyntaxError{Msg: ErrExpectedIdentifier, Token: p.curToken}
+	}
+	p.nextToken()
+	variable := p.curToken.Literal
+
+	if !p.peekTokenIs(token.IN) {
+		return &ast.SyntaxError{Msg: ErrExpectedIn, Token: p.peekToken}
+	}
+	p.nextToken()
+	p.nextToken()
+
+	iterable := p.parseExpression(LOWEST, token.LBRACE)
+	if p.isError(iterable) {
+		return iterable
+	}
+
+	stmts, err := p.parseBlock()
+	if err != nil {
+		return err
+	}
+
+	return &ast.ForStatement{Variable: variable, Iterable: iterable, Statements: stmts, Loc: p.span(start)}
+}
+
+func (p *Parser) parseBreakStatement() ast.Node {
+	start := p.curToken.Loc.Start
+	if !p.peekTokenIs(token.SEMICOLON) {
+		return &ast.SyntaxError{Msg: ErrMissingSemicolon, Token: p.curToken}
+	}
+	p.nextToken()
+
+	return &ast.BreakStatement{Loc: p.span(start)}
+}
+
+func (p *Parser) parseContinueStatement() ast.Node {
+	start := p.curToken.Loc.Start
+	if !p.peekTokenIs(token.SEMICOLON) {
+		return &ast.SyntaxError{Msg: ErrMissingSemicolon, Token: p.curToken}
+	}
+	p.nextToken()
+
+	return &ast.ContinueStatement{Loc: p.span(start)}
+}
+
 func (p *Parser) parseReturnStatement() ast.Node {
 	if p.peekTokenIs(token.SEMICOLON) {
-		return &ast.SyntaxError{Msg: ErrExpectedExpression, Token: p.curToken}
+		return &ast.SyntaxError{Msg: ErrExpectedExpression, Token: p.peekToken}
 	}
 
+	start := p.curToken.Loc.Start
 	p.nextToken()
 	node := ast.ReturnStatement{}
 	node.Expression = p.parseExpression(LOWEST, token.SEMICOLON)
+	if p.isError(node.Expression) {
+		return node.Expression
+	}
 
 	if !p.peekTokenIs(token.SEMICOLON) {
 		return &ast.SyntaxError{Msg: ErrMissingSemicolon, Token: p.curToken}
 	}
 	p.nextToken()
+	node.Loc = p.span(start)
 
 	return &node
 }
 
-func (p *Parser) parseFunctionCall(left ast.Node, _ ...token.TokenType) ast.Node {
-	ident, ok := left.(*ast.Identifier)
-	if !ok {
-		return &ast.SyntaxError{Msg: ErrExpectedIdentifier, Token: p.curToken}
+// parseCallExpression parses a call, anything that evaluates to a function can be called.
+func (p *Parser) parseCallExpression(left ast.Node, _ ...token.TokenType) ast.Node {
+	args := p.parseArguments()
+	if len(args) > 0 && p.isError(args[len(args)-1]) {
+		return args[len(args)-1]
 	}
 
-	args := p.parseArguments()
-	return &ast.FunctionCall{Name: ident.Name, Arguments: args}
+	return &ast.CallExpression{Callee: left, Arguments: args, Loc: p.span(left.Span().Start)}
+}
+
+func (p *Parser) parseMemberExpression(left ast.Node, _ ...token.TokenType) ast.Node {
+	if !p.peekTokenIs(token.IDENT) {
+		return &ast.SyntaxError{Msg: ErrExpectedProperty, Token: p.peekToken}
+	}
+	p.nextToken()
+
+	return &ast.MemberExpression{Object: left, Property: p.curToken.Literal, Loc: p.span(left.Span().Start)}
 }
 
 func (p *Parser) parseFunctionDefinition() ast.Node {
-	node := ast.FunctionDefinition{}
+	node := ast.FunctionDefinition{Doc: p.curToken.Doc}
+	start := p.curToken.Loc.Start
 
-	// Parse the function's name
-	if !p.peekTokenIs(token.IDENT) {
+	// Parse the function's name, anonymous functions don't have one
+	if p.peekTokenIs(token.IDENT) {
+		p.nextToken()
+		node.Name = p.curToken.Literal
+	} else if !p.peekTokenIs(token.LPAREN) {
 		return &ast.SyntaxError{Msg: ErrExpectedIdentifier, Token: p.curToken}
 	}
-	p.nextToken()
-	node.Name = p.curToken.Literal
 
 	// Parse the parameters of the function
 	if !p.peekTokenIs(token.LPAREN) {
@@ -409,6 +684,10 @@ func (p *Parser) parseFunctionDefinition() ast.Node {
 		p.nextToken()
 
 		param := p.parseIdentifier()
+		// Only names can be parameters, not calls like b()
+		if p.peekTokenIs(token.LPAREN) {
+			return &ast.SyntaxError{Msg: ErrExpectedIdentifier, Token: p.peekToken}
+		}
 
 		switch param.(type) {
 		case *ast.Identifier:
@@ -424,35 +703,142 @@ func (p *Parser) parseFunctionDefinition() ast.Node {
 	p.nextToken()
 
 	// Parse the body of the function
-	if !p.peekTokenIs(token.LBRACE) {
-		return &ast.SyntaxError{Msg: ErrExpectedBlock, Token: p.curToken}
-	}
-	p.nextToken()
-	p.nextToken()
-
-	body := p.Parse(token.RBRACE)
-	for _, stmt := range body.Statements {
-		if p.isError(stmt) {
-			return stmt
-		}
+	body, err := p.parseBlock()
+	if err != nil {
+		return err
 	}
-	node.Body = body.Statements
+	node.Body = body
+	node.Loc = p.span(start)
 
 	return &node
 }
 
+// parseArguments parses a list of comma separated expressions up to ')'.
+// If an argument cannot be parsed, the syntax error is the last element of the list.
 func (p *Parser) parseArguments() []ast.Node {
+	return p.parseExpressionList(token.RPAREN)
+}
+
+// parseExpressionList parses a list of comma separated expressions up to the end token.
+// If an expression cannot be parsed, the syntax error is the last element of the list.
+func (p *Parser) parseExpressionList(end token.TokenType) []ast.Node {
 	node := []ast.Node{}
 
-	for !p.peekTokenIs(token.RPAREN) {
+	for !p.peekTokenIs(end) {
 		p.nextToken()
-		arg := p.parseExpression(LOWEST, token.COMMA, token.RPAREN)
+		arg := p.parseExpression(LOWEST, token.COMMA, end)
 		node = append(node, arg)
+		if p.isError(arg) {
+			return node
+		}
+
 		if p.peekTokenIs(token.COMMA) {
 			p.nextToken()
+		} else if !p.peekTokenIs(end) {
+			return append(node, &ast.SyntaxError{Msg: ErrExpectedComma, Token: p.peekToken})
 		}
 	}
 	p.nextToken()
 
 	return node
 }
+
+func (p *Parser) parseArrayLiteral() ast.Node {
+	start := p.curToken.Loc.Start
+
+	elements := p.parseExpressionList(token.RBRACKET)
+	if len(elements) > 0 && p.isError(elements[len(elements)-1]) {
+		return elements[len(elements)-1]
+	}
+
+	return &ast.ArrayLiteral{Elements: elements, Loc: p.span(start)}
+}
+
+func (p *Parser) parseIndexExpression(left ast.Node, _ ...token.TokenType) ast.Node {
+	p.nextToken()
+	index := p.parseExpression(LOWEST, token.RBRACKET)
+	if p.isError(index) {
+		return index
+	}
+
+	if !p.peekTokenIs(token.RBRACKET) {
+		return &ast.SyntaxError{Msg: ErrExpectedBracket, Token: p.peekToken}
+	}
+	p.nextToken()
+
+	return &ast.IndexExpression{Left: left, Index: index, Loc: p.span(left.Span().Start)}
+}
+
+// parseBlock parses the statements enclosed in braces, the next token must be a '{'.
+// Blocks only exist after keywords like 'if' and 'fn', which call this function directly,
+// a '{' found in expression position is always the beginning of a hash literal.
+func (p *Parser) parseBlock() ([]ast.Node, *ast.SyntaxError) {
+	if !p.peekTokenIs(token.LBRACE) {
+		return nil, &ast.SyntaxError{Msg: ErrExpectedBlock, Token: p.curToken}
+	}
+	p.nextToken()
+	p.nextToken()
+
+	block := p.Parse(token.RBRACE)
+	if p.curToken.Type != token.RBRACE {
+		return nil, &ast.SyntaxError{Msg: ErrExpectedBrace, Token: p.curToken}
+	}
+
+	return block.Statements, nil
+}
+
+// parseHashLiteral parses a hash literal, the '{' is in expression position.
+func (p *Parser) parseHashLiteral() ast.Node {
+	node := ast.HashLiteral{Pairs: []ast.HashPair{}}
+	start := p.curToken.Loc.Start
+
+	for !p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+		key := p.parseExpression(LOWEST, token.COLON)
+		if p.isError(key) {
+			return key
+		}
+
+		if !p.peekTokenIs(token.COLON) {
+			return &ast.SyntaxError{Msg: ErrExpectedColon, Token: p.peekToken}
+		}
+		p.nextToken()
+		p.nextToken()
+
+		value := p.parseExpression(LOWEST, token.COMMA, token.RBRACE)
+		if p.isError(value) {
+			return value
+		}
+		node.Pairs = append(node.Pairs, ast.HashPair{Key: key, Value: value})
+
+		if p.peekTokenIs(token.COMMA) {
+			p.nextToken()
+		} else if !p.peekTokenIs(token.RBRACE) {
+			return &ast.SyntaxError{Msg: ErrExpectedComma, Token: p.peekToken}
+		}
+	}
+	p.nextToken()
+	node.Loc = p.span(start)
+
+	return &node
+}
+
+func (p *Parser) parseAssignExpression(left ast.Node, endTokens ...token.TokenType) ast.Node {
+	switch left.(type) {
+	case *ast.Identifier, *ast.IndexExpression, *ast.MemberExpression:
+	default:
+		return &ast.SyntaxError{Msg: ErrInvalidAssignment, Token: p.curToken}
+	}
+
+	node := ast.AssignExpression{Target: left, Operator: p.curToken}
+	p.nextToken()
+
+	// Assignments are right associative: 'a[0] = b[0] = 1' sets both to 1
+	node.Value = p.parseExpression(ASSIGN-1, endTokens...)
+	if p.isError(node.Value) {
+		return node.Value
+	}
+	node.Loc = p.span(left.Span().Start)
+
+	return &node
+}
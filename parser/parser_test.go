@@ -1,14 +1,32 @@
 package parser
 
 import (
+	"math"
 	"maz-lang/ast"
 	"maz-lang/lexer"
 	"maz-lang/token"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 )
 
+// ignoreLoc makes comparisons ignore source positions, they are tested on their own.
+var ignoreLoc = cmpopts.IgnoreTypes(token.Span{})
+
+// firstNode returns the first syntax error reported by the parser if any,
+// otherwise the first statement of the program.
+func firstNode(p *Parser, program ast.Program) ast.Node {
+	if len(p.Errors()) > 0 {
+		return p.Errors()[0]
+	}
+
+	if len(program.Statements) == 0 {
+		return nil
+	}
+	return program.Statements[0]
+}
+
 func TestParseStringLiteral(t *testing.T) {
 	tests := []struct {
 		Expression   string
@@ -30,8 +48,8 @@ func TestParseStringLiteral(t *testing.T) {
 		p := New(&l)
 		program := p.Parse(token.EOF)
 
-		if !cmp.Equal(program.Statements[0], tt.ExpectedNode) {
-			t.Errorf("expected %s, instead got %s\n", tt.ExpectedNode, program.Statements[0])
+		if node := firstNode(p, program); !cmp.Equal(node, tt.ExpectedNode, ignoreLoc) {
+			t.Errorf("expected %s, instead got %s\n", tt.ExpectedNode, node)
 		}
 	}
 }
@@ -90,11 +108,11 @@ func TestParsePrefixExpression(t *testing.T) {
 				t.Fatalf("expected node of type ast.PrefixExpression, got=%T\n", pe)
 			}
 
-			if !cmp.Equal(pe.Prefix, tt.ExpectedNode.Prefix) {
+			if !cmp.Equal(pe.Prefix, tt.ExpectedNode.Prefix, ignoreLoc) {
 				t.Errorf("expected prefix token to be %+v, instead got %+v\n", tt.ExpectedNode.Prefix, pe.Prefix)
 			}
 
-			if !cmp.Equal(pe.Value, tt.ExpectedNode.Value) {
+			if !cmp.Equal(pe.Value, tt.ExpectedNode.Value, ignoreLoc) {
 				t.Errorf("expected value token to be %+v, instead got %+v\n", tt.ExpectedNode.Value, pe.Value)
 			}
 		}
@@ -206,8 +224,8 @@ func TestParseExpression(t *testing.T) {
 			Expression: "foo(a) * 5 + 1",
 			ExpectedNode: &ast.InfixExpression{
 				Left: &ast.InfixExpression{
-					Left: &ast.FunctionCall{
-						Name:      "foo",
+					Left: &ast.CallExpression{
+						Callee:    &ast.Identifier{Name: "foo"},
 						Arguments: []ast.Node{&ast.Identifier{Name: "a"}},
 					},
 					Operator: token.Token{Type: token.ASTERISK, Literal: "*"},
@@ -253,8 +271,8 @@ func TestParseExpression(t *testing.T) {
 		p := New(&l)
 		program := p.Parse(token.EOF)
 
-		if !cmp.Equal(program.Statements[0], tt.ExpectedNode) {
-			t.Errorf("expected node: %+v, instead got: %+v\n", tt.ExpectedNode, program.Statements[0])
+		if node := firstNode(p, program); !cmp.Equal(node, tt.ExpectedNode, ignoreLoc) {
+			t.Errorf("expected node: %+v, instead got: %+v\n", tt.ExpectedNode, node)
 		}
 	}
 }
@@ -305,14 +323,14 @@ func TestParseLetStatement(t *testing.T) {
 			Expression: "let 0 = 5+1;",
 			ExpectedNode: &ast.SyntaxError{
 				Msg:   ErrExpectedIdentifier,
-				Token: token.Token{Type: token.LET, Literal: "let"},
+				Token: token.Token{Type: token.INT, Literal: "0"},
 			},
 		},
 		{
 			Expression: "let a",
 			ExpectedNode: &ast.SyntaxError{
 				Msg:   ErrExpectedAssignment,
-				Token: token.Token{Type: token.IDENT, Literal: "a"},
+				Token: token.Token{Type: token.EOF, Literal: ""},
 			},
 		},
 		{
@@ -326,21 +344,21 @@ func TestParseLetStatement(t *testing.T) {
 			Expression: "let a = ;",
 			ExpectedNode: &ast.SyntaxError{
 				Msg:   ErrExpectedExpression,
-				Token: token.Token{Type: token.ASSIGN, Literal: "="},
+				Token: token.Token{Type: token.SEMICOLON, Literal: ";"},
 			},
 		},
 		{
 			Expression: "let 0 = 5;",
 			ExpectedNode: &ast.SyntaxError{
 				Msg:   ErrExpectedIdentifier,
-				Token: token.Token{Type: token.LET, Literal: "let"},
+				Token: token.Token{Type: token.INT, Literal: "0"},
 			},
 		},
 		{
 			Expression: "let a = ;",
 			ExpectedNode: &ast.SyntaxError{
 				Msg:   ErrExpectedExpression,
-				Token: token.Token{Type: token.ASSIGN, Literal: "="},
+				Token: token.Token{Type: token.SEMICOLON, Literal: ";"},
 			},
 		},
 		{
@@ -358,8 +376,8 @@ func TestParseLetStatement(t *testing.T) {
 		p := New(&l)
 		program := p.Parse(token.EOF)
 
-		if !cmp.Equal(program.Statements[0], tt.ExpectedNode) {
-			t.Errorf("expected node: %+v, instead got %+v\n", tt.ExpectedNode, program.Statements[0])
+		if node := firstNode(p, program); !cmp.Equal(node, tt.ExpectedNode, ignoreLoc) {
+			t.Errorf("expected node: %+v, instead got %+v\n", tt.ExpectedNode, node)
 		}
 	}
 }
@@ -428,7 +446,7 @@ func TestParseIfStatement(t *testing.T) {
 		{
 			Expression: "if a > b { let a = 5;",
 			ExpectedNode: &ast.SyntaxError{
-				Msg: ErrExpectedExpression, Token: token.Token{Type: token.EOF, Literal: ""},
+				Msg: ErrExpectedBrace, Token: token.Token{Type: token.EOF, Literal: ""},
 			},
 		},
 	}
@@ -439,8 +457,8 @@ func TestParseIfStatement(t *testing.T) {
 		p := New(&l)
 		program := p.Parse(token.EOF)
 
-		if !cmp.Equal(program.Statements[0], tt.ExpectedNode) {
-			t.Errorf("expected %s, instead got %s\n", tt.ExpectedNode, program.Statements[0])
+		if node := firstNode(p, program); !cmp.Equal(node, tt.ExpectedNode, ignoreLoc) {
+			t.Errorf("expected %s, instead got %s\n", tt.ExpectedNode, node)
 		}
 	}
 }
@@ -467,7 +485,7 @@ func TestParseReturnStatement(t *testing.T) {
 			Expression: "return ;",
 			ExpectedNode: &ast.SyntaxError{
 				Msg:   ErrExpectedExpression,
-				Token: token.Token{Type: token.RETURN, Literal: "return"},
+				Token: token.Token{Type: token.SEMICOLON, Literal: ";"},
 			},
 		},
 	}
@@ -478,8 +496,8 @@ func TestParseReturnStatement(t *testing.T) {
 		p := New(&l)
 		program := p.Parse(token.EOF)
 
-		if !cmp.Equal(program.Statements[0], tt.ExpectedNode) {
-			t.Errorf("expected %s, instead got %s\n", tt.ExpectedNode, program.Statements[0])
+		if node := firstNode(p, program); !cmp.Equal(node, tt.ExpectedNode, ignoreLoc) {
+			t.Errorf("expected %s, instead got %s\n", tt.ExpectedNode, node)
 		}
 	}
 }
@@ -562,7 +580,7 @@ func TestParseFunction(t *testing.T) {
 		{
 			Expression: "fn foo(a, b) {",
 			ExpectedNode: &ast.SyntaxError{
-				Msg:   ErrExpectedExpression,
+				Msg:   ErrExpectedBrace,
 				Token: token.Token{Type: token.EOF, Literal: ""},
 			},
 		},
@@ -581,8 +599,8 @@ func TestParseFunction(t *testing.T) {
 		p := New(&l)
 		program := p.Parse(token.EOF)
 
-		if !cmp.Equal(program.Statements[0], tt.ExpectedNode) {
-			t.Errorf("expected %s, instead got %s\n", tt.ExpectedNode, program.Statements[0])
+		if node := firstNode(p, program); !cmp.Equal(node, tt.ExpectedNode, ignoreLoc) {
+			t.Errorf("expected %s, instead got %s\n", tt.ExpectedNode, node)
 		}
 	}
 }
@@ -643,7 +661,7 @@ func TestParseArguments(t *testing.T) {
 		l := lexer.New(tt.Expression)
 		p := New(&l)
 		arguments := p.parseArguments()
-		if !cmp.Equal(arguments, tt.ExpectedNode) {
+		if !cmp.Equal(arguments, tt.ExpectedNode, ignoreLoc) {
 			t.Errorf("expected %s, instead got %s\n", tt.ExpectedNode, arguments)
 		}
 	}
@@ -656,15 +674,15 @@ func TestParseFunctionCall(t *testing.T) {
 	}{
 		{
 			Expression: "foo()",
-			ExpectedNode: &ast.FunctionCall{
-				Name:      "foo",
+			ExpectedNode: &ast.CallExpression{
+				Callee:    &ast.Identifier{Name: "foo"},
 				Arguments: []ast.Node{},
 			},
 		},
 		{
 			Expression: "foo(a, b)",
-			ExpectedNode: &ast.FunctionCall{
-				Name: "foo",
+			ExpectedNode: &ast.CallExpression{
+				Callee: &ast.Identifier{Name: "foo"},
 				Arguments: []ast.Node{
 					&ast.Identifier{Name: "a"},
 					&ast.Identifier{Name: "b"},
@@ -673,8 +691,8 @@ func TestParseFunctionCall(t *testing.T) {
 		},
 		{
 			Expression: "foo(1+2, 1)",
-			ExpectedNode: &ast.FunctionCall{
-				Name: "foo",
+			ExpectedNode: &ast.CallExpression{
+				Callee: &ast.Identifier{Name: "foo"},
 				Arguments: []ast.Node{
 					&ast.InfixExpression{
 						Left:     &ast.IntegerLiteral{Value: 1},
@@ -693,8 +711,895 @@ func TestParseFunctionCall(t *testing.T) {
 		p := New(&l)
 		program := p.Parse(token.EOF)
 
-		if !cmp.Equal(program.Statements[0], tt.ExpectedNode) {
-			t.Errorf("expected %s, instead got %s\n", tt.ExpectedNode, program.Statements[0])
+		if node := firstNode(p, program); !cmp.Equal(node, tt.ExpectedNode, ignoreLoc) {
+			t.Errorf("expected %s, instead got %s\n", tt.ExpectedNode, node)
+		}
+	}
+}
+
+func TestNodeSpan(t *testing.T) {
+	tests := []struct {
+		Expression    string
+		ExpectedStart int
+		ExpectedEnd   int
+	}{
+		{Expression: "  foo", ExpectedStart: 2, ExpectedEnd: 5},
+		{Expression: "1 + 2 * 3", ExpectedStart: 0, ExpectedEnd: 9},
+		{Expression: "-foo", ExpectedStart: 0, ExpectedEnd: 4},
+		{Expression: "let a = 5;", ExpectedStart: 0, ExpectedEnd: 10},
+		{Expression: "return a + 1;", ExpectedStart: 0, ExpectedEnd: 13},
+		{Expression: "foo(1, 2)", ExpectedStart: 0, ExpectedEnd: 9},
+		{Expression: "if a { 1 } else { 2 }", ExpectedStart: 0, ExpectedEnd: 21},
+		{Expression: "fn foo(a) { return a; }", ExpectedStart: 0, ExpectedEnd: 23},
+	}
+
+	for _, tt := range tests {
+		t.Logf("parsing: '%s'\n", tt.Expression)
+		l := lexer.New(tt.Expression)
+		p := New(&l)
+		program := p.Parse(token.EOF)
+
+		span := program.Statements[0].Span()
+		if span.Start.Offset != tt.ExpectedStart || span.End.Offset != tt.ExpectedEnd {
+			t.Errorf("expected span [%d, %d), instead got [%d, %d)\n", tt.ExpectedStart, tt.ExpectedEnd, span.Start.Offset, span.End.Offset)
+		}
+	}
+}
+
+func TestParseErrorRecovery(t *testing.T) {
+	tests := []struct {
+		Expression         string
+		ExpectedErrors     []*ast.SyntaxError
+		ExpectedStatements int
+	}{
+		{
+			Expression: "let a = ; let b = 1; let c 2; c",
+			ExpectedErrors: []*ast.SyntaxError{
+				{Msg: ErrExpectedExpression, Token: token.Token{Type: token.SEMICOLON, Literal: ";"}},
+				{Msg: ErrExpectedAssignment, Token: token.Token{Type: token.INT, Literal: "2"}},
+			},
+			ExpectedStatements: 2,
+		},
+		{
+			Expression: "fn foo(a) { let b = ; return a; } foo(1) 5 +",
+			ExpectedErrors: []*ast.SyntaxError{
+				{Msg: ErrExpectedExpression, Token: token.Token{Type: token.SEMICOLON, Literal: ";"}},
+				{Msg: ErrExpectedExpression, Token: token.Token{Type: token.EOF, Literal: ""}},
+			},
+			ExpectedStatements: 2,
+		},
+		{
+			Expression: "if a > ) { let x = 1; } else { let y = 2; } let z = 3; )",
+			ExpectedErrors: []*ast.SyntaxError{
+				{Msg: ErrExpectedExpression, Token: token.Token{Type: token.RPAREN, Literal: ")"}},
+				{Msg: ErrExpectedExpression, Token: token.Token{Type: token.RPAREN, Literal: ")"}},
+			},
+			ExpectedStatements: 1,
+		},
+		{
+			Expression: "foo(1 2); bar(1,",
+			ExpectedErrors: []*ast.SyntaxError{
+				{Msg: ErrExpectedComma, Token: token.Token{Type: token.INT, Literal: "2"}},
+				{Msg: ErrExpectedExpression, Token: token.Token{Type: token.EOF, Literal: ""}},
+			},
+			ExpectedStatements: 0,
+		},
+		{
+			Expression: "let a = 1; @ let b = 2;",
+			ExpectedErrors: []*ast.SyntaxError{
+				{Msg: ErrIllegalToken, Token: token.Token{Type: token.ILLEGAL, Literal: "@"}},
+			},
+			ExpectedStatements: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("parsing: '%s'\n", tt.Expression)
+		l := lexer.New(tt.Expression)
+		p := New(&l)
+		program := p.Parse(token.EOF)
+
+		if !cmp.Equal(p.Errors(), tt.ExpectedErrors, ignoreLoc) {
+			t.Errorf("expected errors %+v, instead got %+v\n", tt.ExpectedErrors, p.Errors())
+		}
+
+		if len(program.Statements) != tt.ExpectedStatements {
+			t.Errorf("expected %d statements, instead got %d\n", tt.ExpectedStatements, len(program.Statements))
+		}
+	}
+}
+
+func TestParseAnonymousFunction(t *testing.T) {
+	tests := []struct {
+		Expression   string
+		ExpectedNode ast.Node
+	}{
+		{
+			Expression: "fn (x) { return x; }",
+			ExpectedNode: &ast.FunctionDefinition{
+				Parameters: []ast.Node{&ast.Identifier{Name: "x"}},
+				Body: []ast.Node{
+					&ast.ReturnStatement{Expression: &ast.Identifier{Name: "x"}},
+				},
+			},
+		},
+		{
+			Expression: "let f = fn () {};",
+			ExpectedNode: &ast.LetStatement{
+				Ident: "f",
+				Value: &ast.FunctionDefinition{},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("parsing: %s\n", tt.Expression)
+		l := lexer.New(tt.Expression)
+		p := New(&l)
+		program := p.Parse(token.EOF)
+
+		if node := firstNode(p, program); !cmp.Equal(node, tt.ExpectedNode, ignoreLoc) {
+			t.Errorf("expected %s, instead got %s\n", tt.ExpectedNode, node)
+		}
+	}
+}
+
+func TestParseCallExpression(t *testing.T) {
+	tests := []struct {
+		Expression   string
+		ExpectedNode ast.Node
+	}{
+		{
+			Expression: "make_adder(1)(2)",
+			ExpectedNode: &ast.CallExpression{
+				Callee: &ast.CallExpression{
+					Callee:    &ast.Identifier{Name: "make_adder"},
+					Arguments: []ast.Node{&ast.IntegerLiteral{Value: 1}},
+				},
+				Arguments: []ast.Node{&ast.IntegerLiteral{Value: 2}},
+			},
+		},
+		{
+			Expression: "(if c { f } else { g })(x)",
+			ExpectedNode: &ast.CallExpression{
+				Callee: &ast.IfStatement{
+					MainCondition:  &ast.Identifier{Name: "c"},
+					MainStatements: []ast.Node{&ast.Identifier{Name: "f"}},
+					ElseStatements: []ast.Node{&ast.Identifier{Name: "g"}},
+				},
+				Arguments: []ast.Node{&ast.Identifier{Name: "x"}},
+			},
+		},
+		{
+			Expression: "obj.method()",
+			ExpectedNode: &ast.CallExpression{
+				Callee: &ast.MemberExpression{
+					Object:   &ast.Identifier{Name: "obj"},
+					Property: "method",
+				},
+				Arguments: []ast.Node{},
+			},
+		},
+		{
+			Expression: "obj.",
+			ExpectedNode: &ast.SyntaxError{
+				Msg:   ErrExpectedProperty,
+				Token: token.Token{Type: token.EOF, Literal: ""},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("parsing: '%s'\n", tt.Expression)
+		l := lexer.New(tt.Expression)
+		p := New(&l)
+		program := p.Parse(token.EOF)
+
+		if node := firstNode(p, program); !cmp.Equal(node, tt.ExpectedNode, ignoreLoc) {
+			t.Errorf("expected %s, instead got %s\n", tt.ExpectedNode, node)
+		}
+	}
+}
+
+func TestParseArray(t *testing.T) {
+	tests := []struct {
+		Expression   string
+		ExpectedNode ast.Node
+	}{
+		{
+			Expression: "[1, a, 2 + 3]",
+			ExpectedNode: &ast.ArrayLiteral{
+				Elements: []ast.Node{
+					&ast.IntegerLiteral{Value: 1},
+					&ast.Identifier{Name: "a"},
+					&ast.InfixExpression{
+						Left:     &ast.IntegerLiteral{Value: 2},
+						Operator: token.Token{Type: token.PLUS, Literal: "+"},
+						Right:    &ast.IntegerLiteral{Value: 3},
+					},
+				},
+			},
+		},
+		{
+			Expression:   "[]",
+			ExpectedNode: &ast.ArrayLiteral{Elements: []ast.Node{}},
+		},
+		{
+			Expression: "a[1 + 1] * 2",
+			ExpectedNode: &ast.InfixExpression{
+				Left: &ast.IndexExpression{
+					Left: &ast.Identifier{Name: "a"},
+					Index: &ast.InfixExpression{
+						Left:     &ast.IntegerLiteral{Value: 1},
+						Operator: token.Token{Type: token.PLUS, Literal: "+"},
+						Right:    &ast.IntegerLiteral{Value: 1},
+					},
+				},
+				Operator: token.Token{Type: token.ASTERISK, Literal: "*"},
+				Right:    &ast.IntegerLiteral{Value: 2},
+			},
+		},
+		{
+			Expression: "ops[0](a, b)",
+			ExpectedNode: &ast.CallExpression{
+				Callee: &ast.IndexExpression{
+					Left:  &ast.Identifier{Name: "ops"},
+					Index: &ast.IntegerLiteral{Value: 0},
+				},
+				Arguments: []ast.Node{
+					&ast.Identifier{Name: "a"},
+					&ast.Identifier{Name: "b"},
+				},
+			},
+		},
+		{
+			Expression: "[1 2]",
+			ExpectedNode: &ast.SyntaxError{
+				Msg:   ErrExpectedComma,
+				Token: token.Token{Type: token.INT, Literal: "2"},
+			},
+		},
+		{
+			Expression: "a[1",
+			ExpectedNode: &ast.SyntaxError{
+				Msg:   ErrExpectedBracket,
+				Token: token.Token{Type: token.EOF, Literal: ""},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("parsing: '%s'\n", tt.Expression)
+		l := lexer.New(tt.Expression)
+		p := New(&l)
+		program := p.Parse(token.EOF)
+
+		if node := firstNode(p, program); !cmp.Equal(node, tt.ExpectedNode, ignoreLoc) {
+			t.Errorf("expected %s, instead got %s\n", tt.ExpectedNode, node)
+		}
+	}
+}
+
+func TestParseHash(t *testing.T) {
+	tests := []struct {
+		Expression   string
+		ExpectedNode ast.Node
+	}{
+		{
+			Expression: "{\"name\": \"x\", 1: true}",
+			ExpectedNode: &ast.HashLiteral{
+				Pairs: []ast.HashPair{
+					{Key: &ast.StringLiteral{Value: "name"}, Value: &ast.StringLiteral{Value: "x"}},
+					{Key: &ast.IntegerLiteral{Value: 1}, Value: &ast.BooleanLiteral{Value: true}},
+				},
+			},
+		},
+		{
+			Expression:   "{}",
+			ExpectedNode: &ast.HashLiteral{Pairs: []ast.HashPair{}},
+		},
+		{
+			Expression: "if a { {1: 2} }",
+			ExpectedNode: &ast.IfStatement{
+				MainCondition: &ast.Identifier{Name: "a"},
+				MainStatements: []ast.Node{
+					&ast.HashLiteral{
+						Pairs: []ast.HashPair{
+							{Key: &ast.IntegerLiteral{Value: 1}, Value: &ast.IntegerLiteral{Value: 2}},
+						},
+					},
+				},
+			},
+		},
+		{
+			Expression: "h[\"k\"] = h.v = 1 + 2",
+			ExpectedNode: &ast.AssignExpression{
+				Target: &ast.IndexExpression{
+					Left:  &ast.Identifier{Name: "h"},
+					Index: &ast.StringLiteral{Value: "k"},
+				},
+				Operator: token.Token{Type: token.ASSIGN, Literal: "="},
+				Value: &ast.AssignExpression{
+					Target:   &ast.MemberExpression{Object: &ast.Identifier{Name: "h"}, Property: "v"},
+					Operator: token.Token{Type: token.ASSIGN, Literal: "="},
+					Value: &ast.InfixExpression{
+						Left:     &ast.IntegerLiteral{Value: 1},
+						Operator: token.Token{Type: token.PLUS, Literal: "+"},
+						Right:    &ast.IntegerLiteral{Value: 2},
+					},
+				},
+			},
+		},
+		{
+			Expression: "{1 2}",
+			ExpectedNode: &ast.SyntaxError{
+				Msg:   ErrExpectedColon,
+				Token: token.Token{Type: token.INT, Literal: "2"},
+			},
+		},
+		{
+			Expression: "{1: 2 3: 4}",
+			ExpectedNode: &ast.SyntaxError{
+				Msg:   ErrExpectedComma,
+				Token: token.Token{Type: token.INT, Literal: "3"},
+			},
+		},
+		{
+			Expression: "1 = 2",
+			ExpectedNode: &ast.SyntaxError{
+				Msg:   ErrInvalidAssignment,
+				Token: token.Token{Type: token.ASSIGN, Literal: "="},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("parsing: '%s'\n", tt.Expression)
+		l := lexer.New(tt.Expression)
+		p := New(&l)
+		program := p.Parse(token.EOF)
+
+		if node := firstNode(p, program); !cmp.Equal(node, tt.ExpectedNode, ignoreLoc) {
+			t.Errorf("expected %s, instead got %s\n", tt.ExpectedNode, node)
+		}
+	}
+}
+
+func TestParseLoops(t *testing.T) {
+	tests := []struct {
+		Expression   string
+		ExpectedNode ast.Node
+	}{
+		{
+			Expression: "while i < 10 { i; break; }",
+			ExpectedNode: &ast.WhileStatement{
+				Condition: &ast.InfixExpression{
+					Left:     &ast.Identifier{Name: "i"},
+					Operator: token.Token{Type: token.LT, Literal: "<"},
+					Right:    &ast.IntegerLiteral{Value: 10},
+				},
+				Statements: []ast.Node{
+					&ast.Identifier{Name: "i"},
+					&ast.BreakStatement{},
+				},
+			},
+		},
+		{
+			Expression: "for x in [1, 2] { continue; }",
+			ExpectedNode: &ast.ForStatement{
+				Variable: "x",
+				Iterable: &ast.ArrayLiteral{
+					Elements: []ast.Node{&ast.IntegerLiteral{Value: 1}, &ast.IntegerLiteral{Value: 2}},
+				},
+				Statements: []ast.Node{&ast.ContinueStatement{}},
+			},
+		},
+		{
+			Expression: "for i in range(3) {}",
+			ExpectedNode: &ast.ForStatement{
+				Variable: "i",
+				Iterable: &ast.CallExpression{
+					Callee:    &ast.Identifier{Name: "range"},
+					Arguments: []ast.Node{&ast.IntegerLiteral{Value: 3}},
+				},
+			},
+		},
+		{
+			Expression: "for 1 in a {}",
+			ExpectedNode: &ast.SyntaxError{
+				Msg:   ErrExpectedIdentifier,
+				Token: token.Token{Type: token.FOR, Literal: "for"},
+			},
+		},
+		{
+			Expression: "for x of a {}",
+			ExpectedNode: &ast.SyntaxError{
+				Msg:   ErrExpectedIn,
+				Token: token.Token{Type: token.IDENT, Literal: "of"},
+			},
+		},
+		{
+			Expression: "while true",
+			ExpectedNode: &ast.SyntaxError{
+				Msg:   ErrExpectedBlock,
+				Token: token.Token{Type: token.TRUE, Literal: "true"},
+			},
+		},
+		{
+			Expression: "while true { break }",
+			ExpectedNode: &ast.SyntaxError{
+				Msg:   ErrMissingSemicolon,
+				Token: token.Token{Type: token.BREAK, Literal: "break"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("parsing: '%s'\n", tt.Expression)
+		l := lexer.New(tt.Expression)
+		p := New(&l)
+		program := p.Parse(token.EOF)
+
+		if node := firstNode(p, program); !cmp.Equal(node, tt.ExpectedNode, ignoreLoc) {
+			t.Errorf("expected %s, instead got %s\n", tt.ExpectedNode, node)
+		}
+	}
+}
+
+func TestParseAssignment(t *testing.T) {
+	tests := []struct {
+		Expression   string
+		ExpectedNode ast.Node
+	}{
+		{
+			Expression: "x = 1",
+			ExpectedNode: &ast.AssignExpression{
+				Target:   &ast.Identifier{Name: "x"},
+				Operator: token.Token{Type: token.ASSIGN, Literal: "="},
+				Value:    &ast.IntegerLiteral{Value: 1},
+			},
+		},
+		{
+			Expression: "x += y * 2",
+			ExpectedNode: &ast.AssignExpression{
+				Target:   &ast.Identifier{Name: "x"},
+				Operator: token.Token{Type: token.PLUS_ASSIGN, Literal: "+="},
+				Value: &ast.InfixExpression{
+					Left:     &ast.Identifier{Name: "y"},
+					Operator: token.Token{Type: token.ASTERISK, Literal: "*"},
+					Right:    &ast.IntegerLiteral{Value: 2},
+				},
+			},
+		},
+		{
+			Expression: "a[0] -= 1",
+			ExpectedNode: &ast.AssignExpression{
+				Target: &ast.IndexExpression{
+					Left:  &ast.Identifier{Name: "a"},
+					Index: &ast.IntegerLiteral{Value: 0},
+				},
+				Operator: token.Token{Type: token.MINUS_ASSIGN, Literal: "-="},
+				Value:    &ast.IntegerLiteral{Value: 1},
+			},
+		},
+		{
+			Expression: "x *= y /= 2",
+			ExpectedNode: &ast.AssignExpression{
+				Target:   &ast.Identifier{Name: "x"},
+				Operator: token.Token{Type: token.ASTERISK_ASSIGN, Literal: "*="},
+				Value: &ast.AssignExpression{
+					Target:   &ast.Identifier{Name: "y"},
+					Operator: token.Token{Type: token.SLASH_ASSIGN, Literal: "/="},
+					Value:    &ast.IntegerLiteral{Value: 2},
+				},
+			},
+		},
+		{
+			Expression: "f() += 1",
+			ExpectedNode: &ast.SyntaxError{
+				Msg:   ErrInvalidAssignment,
+				Token: token.Token{Type: token.PLUS_ASSIGN, Literal: "+="},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("parsing: '%s'\n", tt.Expression)
+		l := lexer.New(tt.Expression)
+		p := New(&l)
+		program := p.Parse(token.EOF)
+
+		if node := firstNode(p, program); !cmp.Equal(node, tt.ExpectedNode, ignoreLoc) {
+			t.Errorf("expected %s, instead got %s\n", tt.ExpectedNode, node)
+		}
+	}
+}
+
+func TestParseLogicalExpression(t *testing.T) {
+	tests := []struct {
+		Expression   string
+		ExpectedNode ast.Node
+	}{
+		{
+			Expression: "a || b && c",
+			ExpectedNode: &ast.InfixExpression{
+				Left:     &ast.Identifier{Name: "a"},
+				Operator: token.Token{Type: token.OR, Literal: "||"},
+				Right: &ast.InfixExpression{
+					Left:     &ast.Identifier{Name: "b"},
+					Operator: token.Token{Type: token.AND, Literal: "&&"},
+					Right:    &ast.Identifier{Name: "c"},
+				},
+			},
+		},
+		{
+			Expression: "a == 1 && !b",
+			ExpectedNode: &ast.InfixExpression{
+				Left: &ast.InfixExpression{
+					Left:     &ast.Identifier{Name: "a"},
+					Operator: token.Token{Type: token.EQ, Literal: "=="},
+					Right:    &ast.IntegerLiteral{Value: 1},
+				},
+				Operator: token.Token{Type: token.AND, Literal: "&&"},
+				Right: &ast.PrefixExpression{
+					Prefix: token.Token{Type: token.BANG, Literal: "!"},
+					Value:  &ast.Identifier{Name: "b"},
+				},
+			},
+		},
+		{
+			Expression: "x = a || b",
+			ExpectedNode: &ast.AssignExpression{
+				Target:   &ast.Identifier{Name: "x"},
+				Operator: token.Token{Type: token.ASSIGN, Literal: "="},
+				Value: &ast.InfixExpression{
+					Left:     &ast.Identifier{Name: "a"},
+					Operator: token.Token{Type: token.OR, Literal: "||"},
+					Right:    &ast.Identifier{Name: "b"},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("parsing: '%s'\n", tt.Expression)
+		l := lexer.New(tt.Expression)
+		p := New(&l)
+		program := p.Parse(token.EOF)
+
+		if node := firstNode(p, program); !cmp.Equal(node, tt.ExpectedNode, ignoreLoc) {
+			t.Errorf("expected %s, instead got %s\n", tt.ExpectedNode, node)
+		}
+	}
+}
+
+func TestParseBitwiseExpression(t *testing.T) {
+	tests := []struct {
+		Expression   string
+		ExpectedNode ast.Node
+	}{
+		{
+			Expression: "2 ** 3 ** 2",
+			ExpectedNode: &ast.InfixExpression{
+				Left:     &ast.IntegerLiteral{Value: 2},
+				Operator: token.Token{Type: token.POWER, Literal: "**"},
+				Right: &ast.InfixExpression{
+					Left:     &ast.IntegerLiteral{Value: 3},
+					Operator: token.Token{Type: token.POWER, Literal: "**"},
+					Right:    &ast.IntegerLiteral{Value: 2},
+				},
+			},
+		},
+		{
+			Expression: "-2 ** 2",
+			ExpectedNode: &ast.PrefixExpression{
+				Prefix: token.Token{Type: token.MINUS, Literal: "-"},
+				Value: &ast.InfixExpression{
+					Left:     &ast.IntegerLiteral{Value: 2},
+					Operator: token.Token{Type: token.POWER, Literal: "**"},
+					Right:    &ast.IntegerLiteral{Value: 2},
+				},
+			},
+		},
+		{
+			Expression: "1 | 2 ^ 3 & 4",
+			ExpectedNode: &ast.InfixExpression{
+				Left:     &ast.IntegerLiteral{Value: 1},
+				Operator: token.Token{Type: token.BIT_OR, Literal: "|"},
+				Right: &ast.InfixExpression{
+					Left:     &ast.IntegerLiteral{Value: 2},
+					Operator: token.Token{Type: token.BIT_XOR, Literal: "^"},
+					Right: &ast.InfixExpression{
+						Left:     &ast.IntegerLiteral{Value: 3},
+						Operator: token.Token{Type: token.BIT_AND, Literal: "&"},
+						Right:    &ast.IntegerLiteral{Value: 4},
+					},
+				},
+			},
+		},
+		{
+			Expression: "1 << 2 + 3",
+			ExpectedNode: &ast.InfixExpression{
+				Left:     &ast.IntegerLiteral{Value: 1},
+				Operator: token.Token{Type: token.SHIFT_LEFT, Literal: "<<"},
+				Right: &ast.InfixExpression{
+					Left:     &ast.IntegerLiteral{Value: 2},
+					Operator: token.Token{Type: token.PLUS, Literal: "+"},
+					Right:    &ast.IntegerLiteral{Value: 3},
+				},
+			},
+		},
+		{
+			Expression: "a & 1 == 0",
+			ExpectedNode: &ast.InfixExpression{
+				Left: &ast.InfixExpression{
+					Left:     &ast.Identifier{Name: "a"},
+					Operator: token.Token{Type: token.BIT_AND, Literal: "&"},
+					Right:    &ast.IntegerLiteral{Value: 1},
+				},
+				Operator: token.Token{Type: token.EQ, Literal: "=="},
+				Right:    &ast.IntegerLiteral{Value: 0},
+			},
+		},
+		{
+			Expression: "~a >> 1",
+			ExpectedNode: &ast.InfixExpression{
+				Left: &ast.PrefixExpression{
+					Prefix: token.Token{Type: token.BIT_NOT, Literal: "~"},
+					Value:  &ast.Identifier{Name: "a"},
+				},
+				Operator: token.Token{Type: token.SHIFT_RIGHT, Literal: ">>"},
+				Right:    &ast.IntegerLiteral{Value: 1},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("parsing: '%s'\n", tt.Expression)
+		l := lexer.New(tt.Expression)
+		p := New(&l)
+		program := p.Parse(token.EOF)
+
+		if node := firstNode(p, program); !cmp.Equal(node, tt.ExpectedNode, ignoreLoc) {
+			t.Errorf("expected %s, instead got %s\n", tt.ExpectedNode, node)
+		}
+	}
+}
+
+func TestParseDocComments(t *testing.T) {
+	tests := []struct {
+		Expression   string
+		ExpectedNode ast.Node
+	}{
+		{
+			Expression: "/// The answer.\nlet a = 42;",
+			ExpectedNode: &ast.LetStatement{
+				Ident: "a",
+				Value: &ast.IntegerLiteral{Value: 42},
+				Doc:   "The answer.",
+			},
+		},
+		{
+			Expression: "/// Returns x.\n/// Nothing else.\nfn id(x) { return x; }",
+			ExpectedNode: &ast.FunctionDefinition{
+				Name:       "id",
+				Parameters: []ast.Node{&ast.Identifier{Name: "x"}},
+				Body: []ast.Node{
+					&ast.ReturnStatement{Expression: &ast.Identifier{Name: "x"}},
+				},
+				Doc: "Returns x.\nNothing else.",
+			},
+		},
+		{
+			Expression: "// Not a doc comment.\nlet a = 1;",
+			ExpectedNode: &ast.LetStatement{
+				Ident: "a",
+				Value: &ast.IntegerLiteral{Value: 1},
+			},
+		},
+		{
+			Expression: "let a = 1; /* not closed",
+			ExpectedNode: &ast.SyntaxError{
+				Msg:   ErrUnterminatedComment,
+				Token: token.Token{Type: token.UNTERMINATED_COMMENT, Literal: "/*"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("parsing: '%s'\n", tt.Expression)
+		l := lexer.New(tt.Expression)
+		p := New(&l)
+		program := p.Parse(token.EOF)
+
+		if node := firstNode(p, program); !cmp.Equal(node, tt.ExpectedNode, ignoreLoc) {
+			t.Errorf("expected %s, instead got %s\n", tt.ExpectedNode, node)
+		}
+	}
+}
+
+func TestParseStringErrors(t *testing.T) {
+	tests := []struct {
+		Expression   string
+		ExpectedNode ast.Node
+	}{
+		{
+			Expression: `let a = "never closed;`,
+			ExpectedNode: &ast.SyntaxError{
+				Msg:   ErrUnterminatedString,
+				Token: token.Token{Type: token.UNTERMINATED_STRING, Literal: `"`},
+			},
+		},
+		{
+			Expression: `let a = "bad \x";`,
+			ExpectedNode: &ast.SyntaxError{
+				Msg:   ErrInvalidEscape,
+				Token: token.Token{Type: token.INVALID_ESCAPE, Literal: `\x`},
+			},
+		},
+		{
+			Expression: `println(\)`,
+			ExpectedNode: &ast.SyntaxError{
+				Msg:   ErrIllegalToken,
+				Token: token.Token{Type: token.ILLEGAL, Literal: `\`},
+			},
+		},
+		{
+			Expression:   `"tab\tquote\""`,
+			ExpectedNode: &ast.StringLiteral{Value: "tab\tquote\""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("parsing: '%s'\n", tt.Expression)
+		l := lexer.New(tt.Expression)
+		p := New(&l)
+		program := p.Parse(token.EOF)
+
+		if node := firstNode(p, program); !cmp.Equal(node, tt.ExpectedNode, ignoreLoc) {
+			t.Errorf("expected %s, instead got %s\n", tt.ExpectedNode, node)
+		}
+	}
+}
+
+func TestParseFloatLiteral(t *testing.T) {
+	tests := []struct {
+		Expression   string
+		ExpectedNode ast.Node
+	}{
+		{
+			Expression:   "3.14",
+			ExpectedNode: &ast.FloatLiteral{Value: 3.14},
+		},
+		{
+			Expression: "1e-9 * 2",
+			ExpectedNode: &ast.InfixExpression{
+				Left:     &ast.FloatLiteral{Value: 1e-9},
+				Operator: token.Token{Type: token.ASTERISK, Literal: "*"},
+				Right:    &ast.IntegerLiteral{Value: 2},
+			},
+		},
+		{
+			Expression: "1e999",
+			ExpectedNode: &ast.SyntaxError{
+				Msg:   ErrFloatOutOfRange,
+				Token: token.Token{Type: token.FLOAT, Literal: "1e999"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("parsing: '%s'\n", tt.Expression)
+		l := lexer.New(tt.Expression)
+		p := New(&l)
+		program := p.Parse(token.EOF)
+
+		if node := firstNode(p, program); !cmp.Equal(node, tt.ExpectedNode, ignoreLoc) {
+			t.Errorf("expected %s, instead got %s\n", tt.ExpectedNode, node)
+		}
+	}
+}
+
+func TestParseIntegerLiteral(t *testing.T) {
+	tests := []struct {
+		Expression   string
+		ExpectedNode ast.Node
+	}{
+		{
+			Expression:   "0xFF",
+			ExpectedNode: &ast.IntegerLiteral{Value: 255},
+		},
+		{
+			Expression:   "0o755",
+			ExpectedNode: &ast.IntegerLiteral{Value: 493},
+		},
+		{
+			Expression:   "0b1010",
+			ExpectedNode: &ast.IntegerLiteral{Value: 10},
+		},
+		{
+			Expression:   "1_000_000",
+			ExpectedNode: &ast.IntegerLiteral{Value: 1000000},
+		},
+		{
+			Expression:   "0",
+			ExpectedNode: &ast.IntegerLiteral{Value: 0},
+		},
+		{
+			Expression:   "9223372036854775807",
+			ExpectedNode: &ast.IntegerLiteral{Value: math.MaxInt64},
+		},
+		{
+			Expression:   "-9223372036854775808",
+			ExpectedNode: &ast.IntegerLiteral{Value: math.MinInt64},
+		},
+		{
+			// '**' binds tighter than '-', like for any other literal
+			Expression: "-9223372036854775808 ** 2",
+			ExpectedNode: &ast.SyntaxError{
+				Msg:   ErrIntegerOutOfRange,
+				Token: token.Token{Type: token.INT, Literal: "9223372036854775808"},
+			},
+		},
+		{
+			Expression: "-9223372036854775807",
+			ExpectedNode: &ast.PrefixExpression{
+				Prefix: token.Token{Type: token.MINUS, Literal: "-"},
+				Value:  &ast.IntegerLiteral{Value: math.MaxInt64},
+			},
+		},
+		{
+			Expression: "9223372036854775808",
+			ExpectedNode: &ast.SyntaxError{
+				Msg:   ErrIntegerOutOfRange,
+				Token: token.Token{Type: token.INT, Literal: "9223372036854775808"},
+			},
+		},
+		{
+			Expression: "0x1_0000_0000_0000_0000",
+			ExpectedNode: &ast.SyntaxError{
+				Msg:   ErrIntegerOutOfRange,
+				Token: token.Token{Type: token.INT, Literal: "0x1_0000_0000_0000_0000"},
+			},
+		},
+		{
+			Expression: "0b102",
+			ExpectedNode: &ast.SyntaxError{
+				Msg:   ErrInvalidNumber,
+				Token: token.Token{Type: token.INT, Literal: "0b102"},
+			},
+		},
+		{
+			Expression: "0755",
+			ExpectedNode: &ast.SyntaxError{
+				Msg:   ErrInvalidNumber,
+				Token: token.Token{Type: token.INT, Literal: "0755"},
+			},
+		},
+		{
+			Expression: "1__0",
+			ExpectedNode: &ast.SyntaxError{
+				Msg:   ErrInvalidNumber,
+				Token: token.Token{Type: token.INT, Literal: "1__0"},
+			},
+		},
+		{
+			Expression: "0x",
+			ExpectedNode: &ast.SyntaxError{
+				Msg:   ErrInvalidNumber,
+				Token: token.Token{Type: token.INT, Literal: "0x"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("parsing: '%s'\n", tt.Expression)
+		l := lexer.New(tt.Expression)
+		p := New(&l)
+		program := p.Parse(token.EOF)
+
+		if node := firstNode(p, program); !cmp.Equal(node, tt.ExpectedNode, ignoreLoc) {
+			t.Errorf("expected %s, instead got %s\n", tt.ExpectedNode, node)
 		}
 	}
 }
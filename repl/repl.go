@@ -6,6 +6,7 @@ import (
 	"maz-lang/environment"
 	"maz-lang/evaluator"
 	"maz-lang/lexer"
+	"maz-lang/object"
 	"maz-lang/parser"
 	"maz-lang/token"
 	"os"
@@ -16,6 +17,8 @@ func Run() {
 
 	fmt.Println("Welcome to the Maz REPL!")
 	env := environment.New()
+	e := evaluator.New()
+	e.Stdin = reader
 	for {
 		fmt.Print(">> ")
 		input, _ := reader.ReadString('\n')
@@ -23,8 +26,22 @@ func Run() {
 		l := lexer.New(input)
 		p := parser.New(&l)
 		program := p.Parse(token.EOF)
-		obj := evaluator.Eval(&program, &env)
+		if len(p.Errors()) > 0 {
+			for _, err := range p.Errors() {
+				fmt.Printf("%s\n", err.Describe(input))
+			}
+			continue
+		}
+
+		obj := e.Eval(&program, &env)
+		if exit, ok := obj.(*object.Exit); ok {
+			os.Exit(exit.Code)
+		}
 		// fmt.Printf("%s\n", program.String())
+		if err, ok := obj.(*object.Error); ok {
+			fmt.Printf("%s\n", err.Describe(input))
+			continue
+		}
 		fmt.Printf("%s\n", obj.Inspect())
 	}
 }
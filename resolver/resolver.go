@@ -0,0 +1,260 @@
+// Package resolver binds every variable of a program to the slot it is
+// stored in, so that the evaluator accesses variables by index, and reports
+// the variables used before their declaration or declared twice.
+package resolver
+
+import (
+	"fmt"
+	"maz-lang/ast"
+	"maz-lang/environment"
+	"maz-lang/token"
+)
+
+// Error is a mistake found in a program before running it.
+type Error struct {
+	Message string
+	Loc     token.Span
+}
+
+func (e *Error) Error() string { return e.Message }
+
+// scope mirrors an environment created by the evaluator: the program, a
+// function call, a block of an if statement or an iteration of a loop.
+type scope struct {
+	outer *scope
+	slots map[string]int
+	size  int
+	// pending holds the names declared later in the statements being resolved.
+	pending map[string]bool
+	// function is set for the scope of a function body and for the program,
+	// whose nested functions are resolved once all of its statements are.
+	function bool
+	deferred []func()
+
+	// globals is only set for the program, whose variables live in the
+	// environment passed to Resolve.
+	globals *environment.Environment
+}
+
+// lookup returns the slot of a variable declared in s.
+func (s *scope) lookup(name string) (int, bool) {
+	if s.globals == nil {
+		slot, ok := s.slots[name]
+		return slot, ok
+	}
+
+	return s.globals.Slot(name)
+}
+
+func (s *scope) declare(name string) int {
+	if s.globals != nil {
+		s.slots[name] = s.globals.Declare(name)
+	} else if _, ok := s.slots[name]; !ok {
+		s.slots[name] = s.size
+		s.size++
+	}
+	delete(s.pending, name)
+
+	return s.slots[name]
+}
+
+type resolver struct {
+	scope *scope
+	err   *Error
+}
+
+// Resolve sets the depth and slot of the variables of program, which is
+// then run in globals. It returns the first error found, if any.
+func Resolve(program *ast.Program, globals *environment.Environment) *Error {
+	r := &resolver{}
+	r.scope = &scope{
+		slots:    map[string]int{},
+		pending:  map[string]bool{},
+		function: true,
+		globals:  globals,
+	}
+
+	r.statements(program.Statements)
+	r.flush(r.scope)
+
+	return r.err
+}
+
+func (r *resolver) fail(loc token.Span, format string, a ...any) {
+	if r.err == nil {
+		r.err = &Error{Message: fmt.Sprintf(format, a...), Loc: loc}
+	}
+}
+
+// statements resolves a list of statements in the current scope, the names
+// they declare are pending until their declaration is reached.
+func (r *resolver) statements(nodes []ast.Node) {
+	for _, node := range nodes {
+		switch node := node.(type) {
+		case *ast.LetStatement:
+			r.scope.pending[node.Ident] = true
+		case *ast.FunctionDefinition:
+			if node.Name != "" {
+				r.scope.pending[node.Name] = true
+			}
+		}
+	}
+
+	for _, node := range nodes {
+		r.resolve(node)
+	}
+}
+
+// block resolves statements in a new scope, after running enter in it.
+func (r *resolver) block(enter func(), nodes []ast.Node) {
+	r.scope = &scope{outer: r.scope, slots: map[string]int{}, pending: map[string]bool{}}
+	if enter != nil {
+		enter()
+	}
+	r.statements(nodes)
+	r.scope = r.scope.outer
+}
+
+func (r *resolver) resolveAll(nodes []ast.Node) {
+	for _, node := range nodes {
+		r.resolve(node)
+	}
+}
+
+func (r *resolver) resolve(node ast.Node) {
+	switch node := node.(type) {
+	case *ast.Identifier:
+		r.identifier(node)
+	case *ast.PrefixExpression:
+		r.resolve(node.Value)
+	case *ast.InfixExpression:
+		r.resolve(node.Left)
+		r.resolve(node.Right)
+	case *ast.LetStatement:
+		r.resolve(node.Value)
+		if _, ok := r.scope.slots[node.Ident]; ok {
+			r.fail(node.Loc, "variable '%s' is already declared in this scope", node.Ident)
+		}
+		node.Slot = r.scope.declare(node.Ident)
+	case *ast.IfStatement:
+		r.resolve(node.MainCondition)
+		r.block(nil, node.MainStatements)
+		for i := range node.ElseIfs {
+			elseIf := &node.ElseIfs[i]
+			r.block(func() { r.resolve(elseIf.Condition) }, elseIf.Statements)
+		}
+		if len(node.ElseStatements) != 0 {
+			r.block(nil, node.ElseStatements)
+		}
+	case *ast.WhileStatement:
+		r.resolve(node.Condition)
+		r.block(nil, node.Statements)
+	case *ast.ForStatement:
+		// The variable takes the first slot of every iteration
+		r.resolve(node.Iterable)
+		r.block(func() { r.scope.declare(node.Variable) }, node.Statements)
+	case *ast.ReturnStatement:
+		r.resolve(node.Expression)
+	case *ast.FunctionDefinition:
+		r.function(node)
+	case *ast.CallExpression:
+		r.resolve(node.Callee)
+		r.resolveAll(node.Arguments)
+	case *ast.MemberExpression:
+		r.resolve(node.Object)
+	case *ast.ArrayLiteral:
+		r.resolveAll(node.Elements)
+	case *ast.IndexExpression:
+		r.resolve(node.Left)
+		r.resolve(node.Index)
+	case *ast.HashLiteral:
+		for _, pair := range node.Pairs {
+			r.resolve(pair.Key)
+			r.resolve(pair.Value)
+		}
+	case *ast.AssignExpression:
+		r.resolve(node.Target)
+		r.resolve(node.Value)
+	}
+}
+
+// identifier binds id to the closest declaration of its name. A name declared
+// later in the same function cannot be used yet, while functions can use
+// any variable of the enclosing scopes since they run after being defined.
+func (r *resolver) identifier(id *ast.Identifier) {
+	if depth, slot, ok := r.lookup(id.Name); ok {
+		id.Depth, id.Slot = depth, slot
+		return
+	}
+
+	for s := r.scope; ; s = s.outer {
+		if s.pending[id.Name] {
+			r.fail(id.Loc, "variable '%s' is used before being declared", id.Name)
+		}
+		if s.function {
+			break
+		}
+	}
+
+	// Anything else is left to the runtime, it can be a builtin or a
+	// global defined by another program. It is looked up by name, a slot
+	// kept for it would make the name look declared to the next programs.
+	id.Depth, id.Slot = 0, ast.Unresolved
+}
+
+// lookup returns where the closest declaration of name is stored.
+func (r *resolver) lookup(name string) (depth, slot int, ok bool) {
+	for s := r.scope; s != nil; s = s.outer {
+		if slot, ok := s.lookup(name); ok {
+			return depth, slot, true
+		}
+		depth++
+	}
+
+	return 0, 0, false
+}
+
+// function binds the name of a named function in the current scope, its
+// body is resolved once the enclosing function is.
+func (r *resolver) function(node *ast.FunctionDefinition) {
+	if node.Name != "" {
+		if depth, slot, ok := r.lookup(node.Name); ok {
+			node.Shadowed = &ast.Identifier{Name: node.Name, Loc: node.Loc, Depth: depth, Slot: slot}
+		}
+		node.Slot = r.scope.declare(node.Name)
+	}
+
+	outer := r.scope
+	for !outer.function {
+		outer = outer.outer
+	}
+
+	defined := r.scope
+	outer.deferred = append(outer.deferred, func() {
+		body := &scope{outer: defined, slots: map[string]int{}, pending: map[string]bool{}, function: true}
+		for i, param := range node.Parameters {
+			if param, ok := param.(*ast.Identifier); ok {
+				if _, ok := body.slots[param.Name]; ok {
+					r.fail(param.Loc, "parameter '%s' is already declared", param.Name)
+				}
+				param.Slot = i
+				body.slots[param.Name] = i
+			}
+		}
+		body.size = len(node.Parameters)
+
+		r.scope = body
+		r.statements(node.Body)
+		r.flush(body)
+		node.NumLocals = body.size
+	})
+}
+
+// flush resolves the bodies of the functions defined in s.
+func (r *resolver) flush(s *scope) {
+	for len(s.deferred) > 0 {
+		fn := s.deferred[0]
+		s.deferred = s.deferred[1:]
+		fn()
+	}
+}
@@ -0,0 +1,169 @@
+package resolver
+
+import (
+	"maz-lang/ast"
+	"maz-lang/environment"
+	"maz-lang/lexer"
+	"maz-lang/parser"
+	"maz-lang/token"
+	"testing"
+)
+
+func parse(src string) *ast.Program {
+	l := lexer.New(src)
+	program := parser.New(&l).Parse(token.EOF)
+
+	return &program
+}
+
+// identifiers returns the identifiers of program in the order they appear.
+func identifiers(program *ast.Program) []*ast.Identifier {
+	var res []*ast.Identifier
+	ast.Inspect(program, func(node ast.Node) bool {
+		if ident, ok := node.(*ast.Identifier); ok {
+			res = append(res, ident)
+		}
+		return true
+	})
+
+	return res
+}
+
+func TestResolve(t *testing.T) {
+	type binding struct {
+		Name  string
+		Depth int
+		Slot  int
+	}
+
+	tests := []struct {
+		Source   string
+		Expected []binding
+	}{
+		{
+			Source:   "let a = 1; let b = 2; b + a",
+			Expected: []binding{{"b", 0, 1}, {"a", 0, 0}},
+		},
+		{
+			Source:   "let a = 1; if true { let a = a; a }",
+			Expected: []binding{{"a", 1, 0}, {"a", 0, 0}},
+		},
+		{
+			Source:   "let x = 1; fn f(a, b) { let c = a; return [b, c, x]; }",
+			Expected: []binding{{"a", 0, 0}, {"b", 0, 1}, {"a", 0, 0}, {"b", 0, 1}, {"c", 0, 2}, {"x", 1, 0}},
+		},
+		{
+			Source:   "for i in range(3) { while i { i } }",
+			Expected: []binding{{"range", 0, ast.Unresolved}, {"i", 0, 0}, {"i", 1, 0}},
+		},
+		{
+			Source:   "fn f() { return g(); } fn g() { return 1; }",
+			Expected: []binding{{"g", 1, 1}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("resolving: '%s'\n", tt.Source)
+		program := parse(tt.Source)
+		globals := environment.New()
+		if err := Resolve(program, &globals); err != nil {
+			t.Fatalf("unexpected error: %s\n", err)
+		}
+
+		idents := identifiers(program)
+		if len(idents) != len(tt.Expected) {
+			t.Fatalf("expected %d identifiers, instead got %d\n", len(tt.Expected), len(idents))
+		}
+		for i, ident := range idents {
+			if got := (binding{ident.Name, ident.Depth, ident.Slot}); got != tt.Expected[i] {
+				t.Errorf("expected identifier %d to be %+v, instead got %+v\n", i, tt.Expected[i], got)
+			}
+		}
+	}
+}
+
+func TestResolveErrors(t *testing.T) {
+	tests := []struct {
+		Source        string
+		ExpectedError string
+	}{
+		{
+			Source:        "x; let x = 1;",
+			ExpectedError: "1:1: variable 'x' is used before being declared",
+		},
+		{
+			Source:        "let x = x;",
+			ExpectedError: "1:9: variable 'x' is used before being declared",
+		},
+		{
+			Source:        "if true { f(); fn f() {} }",
+			ExpectedError: "1:11: variable 'f' is used before being declared",
+		},
+		{
+			Source:        "fn f() { y += 1; let y = 0; }",
+			ExpectedError: "1:10: variable 'y' is used before being declared",
+		},
+		{
+			Source:        "let a = 1; let a = 2;",
+			ExpectedError: "1:12: variable 'a' is already declared in this scope",
+		},
+		{
+			Source:        "fn f(a) { let a = 2; }",
+			ExpectedError: "1:11: variable 'a' is already declared in this scope",
+		},
+		{
+			Source:        "for i in [1] { let i = 2; }",
+			ExpectedError: "1:16: variable 'i' is already declared in this scope",
+		},
+		{
+			Source:        "fn f(a, b, a) { return a; }",
+			ExpectedError: "1:12: parameter 'a' is already declared",
+		},
+		{
+			Source:        "let g = fn(x, x) { return x; };",
+			ExpectedError: "1:15: parameter 'x' is already declared",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("resolving: '%s'\n", tt.Source)
+		globals := environment.New()
+		err := Resolve(parse(tt.Source), &globals)
+		if err == nil {
+			t.Errorf("expected an error, instead got nil\n")
+			continue
+		}
+
+		got := err.Loc.Start.String() + ": " + err.Error()
+		if got != tt.ExpectedError {
+			t.Errorf("expected error '%s', instead got '%s'\n", tt.ExpectedError, got)
+		}
+	}
+}
+
+func TestResolveGlobals(t *testing.T) {
+	// Globals are kept between programs, like in the REPL, which can
+	// declare them again.
+	globals := environment.New()
+	for _, src := range []string{"let a = 1; a", "let a = a + 1; a", "let b = a;"} {
+		if err := Resolve(parse(src), &globals); err != nil {
+			t.Fatalf("unexpected error resolving '%s': %s\n", src, err)
+		}
+	}
+
+	if slot, ok := globals.Slot("b"); !ok || slot != 1 {
+		t.Errorf("expected b to be declared in slot 1, instead got %d %v\n", slot, ok)
+	}
+
+	// A name which is only used is not declared for the next programs
+	if err := Resolve(parse("print(x)"), &globals); err != nil {
+		t.Fatalf("unexpected error resolving 'print(x)': %s\n", err)
+	}
+	if _, ok := globals.Slot("x"); ok {
+		t.Errorf("expected x not to be declared\n")
+	}
+	err := Resolve(parse("let y = x; let x = 1;"), &globals)
+	if err == nil || err.Error() != "variable 'x' is used before being declared" {
+		t.Errorf("expected x to be used before being declared, instead got %v\n", err)
+	}
+}
@@ -0,0 +1,67 @@
+package token
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Position is a location in the source code.
+// Line and Column start at 1, Offset is the byte offset starting at 0.
+type Position struct {
+	Filename string
+	Offset   int
+	Line     int
+	Column   int
+}
+
+// IsValid reports whether the position was actually set by the lexer.
+func (p Position) IsValid() bool { return p.Line > 0 }
+
+func (p Position) String() string {
+	if !p.IsValid() {
+		if p.Filename != "" {
+			return p.Filename
+		}
+		return "-"
+	}
+
+	if p.Filename == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.Filename, p.Line, p.Column)
+}
+
+// Span is the portion of the source between Start (inclusive) and End (exclusive).
+type Span struct {
+	Start Position
+	End   Position
+}
+
+// Excerpt returns the source line containing pos followed by a line
+// with a caret under the column pointed by pos.
+func Excerpt(src string, pos Position) string {
+	if !pos.IsValid() || pos.Offset > len(src) {
+		return ""
+	}
+
+	start := strings.LastIndexByte(src[:pos.Offset], '\n') + 1
+	end := strings.IndexByte(src[pos.Offset:], '\n')
+	if end == -1 {
+		end = len(src)
+	} else {
+		end += pos.Offset
+	}
+	line := strings.TrimRight(src[start:end], "\r")
+
+	// Tabs are kept so that the caret lines up with the source line.
+	var padding strings.Builder
+	for _, char := range src[start:pos.Offset] {
+		if char == '\t' {
+			padding.WriteRune('\t')
+		} else {
+			padding.WriteRune(' ')
+		}
+	}
+
+	return fmt.Sprintf("%s\n%s^", line, padding.String())
+}
@@ -5,30 +5,61 @@ type TokenType string
 type Token struct {
 	Type    TokenType
 	Literal string
+	Loc     Span
+
+	// Doc is the text of the '///' comments right before the token.
+	Doc string
 }
 
 const (
 	EOF     = "EOF"
 	ILLEGAL = "ILLEGAL"
 
+	// Tokens the lexer could not read, with the reason why
+	UNTERMINATED_COMMENT = "UNTERMINATED_COMMENT"
+	UNTERMINATED_STRING  = "UNTERMINATED_STRING"
+	INVALID_ESCAPE       = "INVALID_ESCAPE"
+
 	IDENT  = "IDENT"
 	INT    = "INT"
+	FLOAT  = "FLOAT"
 	STRING = "STRING"
 
-	ASSIGN   = "="
-	PLUS     = "+"
-	MINUS    = "-"
-	ASTERISK = "*"
-	SLASH    = "/"
-	BANG     = "!"
+	ASSIGN          = "="
+	PLUS_ASSIGN     = "+="
+	MINUS_ASSIGN    = "-="
+	ASTERISK_ASSIGN = "*="
+	SLASH_ASSIGN    = "/="
+	PERCENT_ASSIGN  = "%="
+	PLUS            = "+"
+	MINUS           = "-"
+	ASTERISK        = "*"
+	SLASH           = "/"
+	PERCENT         = "%"
+	BANG            = "!"
 
 	SEMICOLON = ";"
+	COLON     = ":"
 	COMMA     = ","
+	DOT       = "."
+
+	LBRACE   = "{"
+	RBRACE   = "}"
+	LPAREN   = "("
+	RPAREN   = ")"
+	LBRACKET = "["
+	RBRACKET = "]"
+
+	AND = "&&"
+	OR  = "||"
 
-	LBRACE = "{"
-	RBRACE = "}"
-	LPAREN = "("
-	RPAREN = ")"
+	BIT_AND     = "&"
+	BIT_OR      = "|"
+	BIT_XOR     = "^"
+	BIT_NOT     = "~"
+	SHIFT_LEFT  = "<<"
+	SHIFT_RIGHT = ">>"
+	POWER       = "**"
 
 	EQ   = "=="
 	NEQ  = "!="
@@ -44,16 +75,26 @@ const (
 	FUNCTION = "FUNCTION"
 	TRUE     = "TRUE"
 	FALSE    = "FALSE"
+	WHILE    = "WHILE"
+	FOR      = "FOR"
+	IN       = "IN"
+	BREAK    = "BREAK"
+	CONTINUE = "CONTINUE"
 )
 
 var keywords = map[string]TokenType{
-	"let":    LET,
-	"return": RETURN,
-	"if":     IF,
-	"else":   ELSE,
-	"fn":     FUNCTION,
-	"true":   TRUE,
-	"false":  FALSE,
+	"let":      LET,
+	"return":   RETURN,
+	"if":       IF,
+	"else":     ELSE,
+	"fn":       FUNCTION,
+	"true":     TRUE,
+	"false":    FALSE,
+	"while":    WHILE,
+	"for":      FOR,
+	"in":       IN,
+	"break":    BREAK,
+	"continue": CONTINUE,
 }
 
 func Lookupkeyword(word string) TokenType {
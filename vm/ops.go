@@ -0,0 +1,136 @@
+package vm
+
+import (
+	"math"
+	"maz-lang/code"
+	"maz-lang/object"
+	"slices"
+	"unicode/utf8"
+)
+
+var infixOperators = map[code.Opcode]string{
+	code.OpAdd:          "+",
+	code.OpSub:          "-",
+	code.OpMul:          "*",
+	code.OpDiv:          "/",
+	code.OpMod:          "%",
+	code.OpPow:          "**",
+	code.OpBitAnd:       "&",
+	code.OpBitOr:        "|",
+	code.OpBitXor:       "^",
+	code.OpShiftLeft:    "<<",
+	code.OpShiftRight:   ">>",
+	code.OpEqual:        "==",
+	code.OpNotEqual:     "!=",
+	code.OpGreater:      ">",
+	code.OpGreaterEqual: ">=",
+	code.OpLess:         "<",
+	code.OpLessEqual:    "<=",
+}
+
+var prefixOperators = map[code.Opcode]string{
+	code.OpMinus:  "-",
+	code.OpBang:   "!",
+	code.OpBitNot: "~",
+}
+
+// binary applies a binary operator, the common operations on integers
+// which do not overflow are done right away, anything else is left to
+// the evaluator.
+func (vm *VM) binary(op code.Opcode, left, right object.Object) object.Object {
+	if l, ok := left.(*object.Integer); ok {
+		if r, ok := right.(*object.Integer); ok {
+			switch op {
+			case code.OpAdd:
+				res := l.Value + r.Value
+				if !((r.Value > 0 && res < l.Value) || (r.Value < 0 && res > l.Value)) {
+					return &object.Integer{Value: res}
+				}
+			case code.OpSub:
+				res := l.Value - r.Value
+				if !((r.Value > 0 && res > l.Value) || (r.Value < 0 && res < l.Value)) {
+					return &object.Integer{Value: res}
+				}
+			case code.OpEqual:
+				return nativeBoolToBoolean(l.Value == r.Value)
+			case code.OpNotEqual:
+				return nativeBoolToBoolean(l.Value != r.Value)
+			case code.OpGreater:
+				return nativeBoolToBoolean(l.Value > r.Value)
+			case code.OpGreaterEqual:
+				return nativeBoolToBoolean(l.Value >= r.Value)
+			case code.OpLess:
+				return nativeBoolToBoolean(l.Value < r.Value)
+			case code.OpLessEqual:
+				return nativeBoolToBoolean(l.Value <= r.Value)
+			}
+		}
+	}
+
+	return vm.e.Infix(infixOperators[op], left, right)
+}
+
+// iterator walks through the values of an iterable in a for loop.
+type iterator struct {
+	next func() (object.Object, bool)
+}
+
+func (it *iterator) Type() object.ObjectType { return "ITERATOR" }
+func (it *iterator) Inspect() string         { return "<iterator>" }
+
+// newIterator returns an iterator over the elements of an array, the
+// characters of a string, the numbers of a range or the keys of a hash.
+func newIterator(obj object.Object) (*iterator, *object.Error) {
+	switch obj := obj.(type) {
+	case *object.Array:
+		elements, i := obj.Elements, 0
+		return &iterator{next: func() (object.Object, bool) {
+			if i >= len(elements) {
+				return nil, false
+			}
+			i++
+			return elements[i-1], true
+		}}, nil
+	case *object.String:
+		s := obj.Value
+		return &iterator{next: func() (object.Object, bool) {
+			if s == "" {
+				return nil, false
+			}
+			char, size := utf8.DecodeRuneInString(s)
+			s = s[size:]
+			return &object.String{Value: string(char)}, true
+		}}, nil
+	case *object.Range:
+		r, i, done := *obj, obj.Start, false
+		return &iterator{next: func() (object.Object, bool) {
+			if done || !((r.Step > 0 && i < r.End) || (r.Step < 0 && i > r.End)) {
+				return nil, false
+			}
+			res := &object.Integer{Value: i}
+
+			// Stop before i wraps around
+			if (r.Step > 0 && i > math.MaxInt64-r.Step) || (r.Step < 0 && i < math.MinInt64-r.Step) {
+				done = true
+			} else {
+				i += r.Step
+			}
+			return res, true
+		}}, nil
+	case *object.Hash:
+		// Keys are iterated in insertion order, the ones deleted by the loop body are skipped
+		keys, hash := slices.Clone(obj.Order), obj
+		return &iterator{next: func() (object.Object, bool) {
+			for len(keys) > 0 {
+				pair, ok := hash.Pairs[keys[0]]
+				keys = keys[1:]
+				if ok {
+					return pair.Key, true
+				}
+			}
+			return nil, false
+		}}, nil
+	}
+
+	return nil, newError("cannot iterate over %s", obj.Type())
+}
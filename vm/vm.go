@@ -0,0 +1,560 @@
+// Package vm executes the programs compiled by the compiler package.
+package vm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maz-lang/code"
+	"maz-lang/compiler"
+	"maz-lang/evaluator"
+	"maz-lang/object"
+	"slices"
+)
+
+// initialStackSize is the number of values the stack can hold before
+// growing for the first time.
+const initialStackSize = 1024
+
+// How many instructions are executed between checks of the context.
+const contextCheckInterval = 1024
+
+// frame is a function call in progress.
+type frame struct {
+	cl *object.Closure
+	// ip is the position of the instruction being executed.
+	ip int
+	// bp is where the locals of the function start on the stack.
+	bp int
+	// loops is the number of loops running when the function was called.
+	loops int
+}
+
+// mark is the top level statement a return leaves.
+type mark struct {
+	sp    int
+	loops int
+	exit  int
+}
+
+// VM runs a compiled program with the settings, builtins and limits of an
+// evaluator, so that it gives the same results.
+type VM struct {
+	e           *evaluator.Evaluator
+	constants   []object.Object
+	globals     []object.Object
+	globalNames []string
+	main        *object.Closure
+
+	stack  []object.Object
+	sp     int
+	frames []frame
+	// loops holds the stack pointer at the start of each running loop,
+	// break and continue bring the stack back to it.
+	loops []int
+	marks []mark
+
+	ctx   context.Context
+	steps int64
+}
+
+// New returns a virtual machine running bytecode, e provides the settings
+// and the builtins.
+func New(bytecode *compiler.Bytecode, e *evaluator.Evaluator) *VM {
+	return &VM{
+		e:           e,
+		constants:   bytecode.Constants,
+		globals:     make([]object.Object, len(bytecode.Globals)),
+		globalNames: bytecode.Globals,
+		main:        &object.Closure{Fn: bytecode.Main},
+	}
+}
+
+// Run executes the program and returns the value of its last statement,
+// errors and calls to exit() stop the program and are returned instead.
+func (vm *VM) Run() object.Object {
+	return vm.RunContext(context.Background())
+}
+
+// RunContext is like Run, but the program stops with an error of kind
+// object.CanceledError as soon as ctx is done.
+//
+// The instructions of a decoded program are checked but the values they
+// leave on the stack are not, an instruction which finds the stack in a
+// state the compiler never produces stops the program with an error
+// wrapping compiler.ErrCorrupted.
+func (vm *VM) RunContext(ctx context.Context) (res object.Object) {
+	// The allocations are accounted for by the evaluator, whose
+	// builtins and operators create objects too
+	defer vm.e.Begin(ctx)()
+	defer func() {
+		if r := recover(); r != nil {
+			res = &object.Error{Value: fmt.Errorf("%w: %v", compiler.ErrCorrupted, r)}
+		}
+	}()
+	vm.ctx = ctx
+	vm.steps = 0
+	vm.stack = make([]object.Object, initialStackSize)
+	vm.sp = vm.main.Fn.NumLocals
+	vm.frames = []frame{{cl: vm.main, ip: -1}}
+	vm.loops = vm.loops[:0]
+	vm.marks = vm.marks[:0]
+
+	return vm.run()
+}
+
+func (vm *VM) run() object.Object {
+	f := &vm.frames[0]
+	ins := f.cl.Fn.Instructions
+
+	for {
+		vm.steps++
+		if vm.e.MaxSteps > 0 && vm.steps > vm.e.MaxSteps {
+			return vm.fail(&object.Error{Value: fmt.Errorf("%w: %d", evaluator.ErrStepLimit, vm.e.MaxSteps), Kind: object.StepLimitError})
+		}
+		if vm.steps%contextCheckInterval == 0 {
+			if err := vm.ctx.Err(); err != nil {
+				return vm.fail(&object.Error{Value: fmt.Errorf("evaluation stopped: %w", err), Kind: object.CanceledError})
+			}
+		}
+
+		f.ip++
+		ip := f.ip
+		op := code.Opcode(ins[ip])
+
+		switch op {
+		case code.OpConstant:
+			f.ip += 2
+			vm.push(vm.constants[code.ReadUint16(ins[ip+1:])])
+		case code.OpTrue:
+			vm.push(&evaluator.TRUE)
+		case code.OpFalse:
+			vm.push(&evaluator.FALSE)
+		case code.OpNull:
+			vm.push(&evaluator.NULL)
+		case code.OpPop:
+			vm.sp--
+		case code.OpDup:
+			vm.push(vm.stack[vm.sp-1])
+		case code.OpDup2:
+			vm.push(vm.stack[vm.sp-2])
+			vm.push(vm.stack[vm.sp-2])
+
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod, code.OpPow,
+			code.OpBitAnd, code.OpBitOr, code.OpBitXor, code.OpShiftLeft, code.OpShiftRight,
+			code.OpEqual, code.OpNotEqual, code.OpGreater, code.OpGreaterEqual, code.OpLess, code.OpLessEqual:
+			right := vm.pop()
+			left := vm.pop()
+			res := vm.binary(op, left, right)
+			if err, ok := res.(*object.Error); ok {
+				return vm.fail(err)
+			}
+			if err := vm.alloc(1); err != nil {
+				return vm.fail(err)
+			}
+			vm.push(res)
+
+		case code.OpMinus, code.OpBang, code.OpBitNot:
+			res := vm.e.Prefix(prefixOperators[op], vm.pop())
+			if err, ok := res.(*object.Error); ok {
+				return vm.fail(err)
+			}
+			if err := vm.alloc(1); err != nil {
+				return vm.fail(err)
+			}
+			vm.push(res)
+		case code.OpBool:
+			value, err := vm.truthy(vm.pop())
+			if err != nil {
+				return vm.fail(err)
+			}
+			vm.push(nativeBoolToBoolean(value))
+
+		case code.OpJump:
+			f.ip = int(code.ReadUint16(ins[ip+1:])) - 1
+		case code.OpJumpIfFalse:
+			f.ip += 2
+			value, err := vm.truthy(vm.pop())
+			if err != nil {
+				return vm.fail(err)
+			}
+			if !value {
+				f.ip = int(code.ReadUint16(ins[ip+1:])) - 1
+			}
+		case code.OpJumpIfFalseOrPop, code.OpJumpIfTrueOrPop:
+			f.ip += 2
+			// The value was already turned into a boolean by OpBool
+			if vm.stack[vm.sp-1].(*object.Boolean).Value == (op == code.OpJumpIfTrueOrPop) {
+				f.ip = int(code.ReadUint16(ins[ip+1:])) - 1
+			} else {
+				vm.sp--
+			}
+
+		case code.OpGetGlobal:
+			f.ip += 2
+			vm.push(vm.global(int(code.ReadUint16(ins[ip+1:]))))
+		case code.OpSetGlobal:
+			f.ip += 2
+			vm.globals[code.ReadUint16(ins[ip+1:])] = vm.pop()
+		case code.OpCheckGlobal:
+			f.ip += 2
+			index := code.ReadUint16(ins[ip+1:])
+			if vm.globals[index] == nil {
+				return vm.fail(newError("cannot assign to undeclared variable '%s'", vm.globalNames[index]))
+			}
+		case code.OpCheckFunction:
+			f.ip += 2
+			index := code.ReadUint16(ins[ip+1:])
+			if vm.globals[index] != nil {
+				return vm.fail(newError("evaluation error: function with name '%s' already exists", vm.globalNames[index]))
+			}
+		case code.OpGetLocal:
+			f.ip += 2
+			vm.push(orNull(vm.stack[f.bp+int(code.ReadUint16(ins[ip+1:]))]))
+		case code.OpSetLocal:
+			f.ip += 2
+			vm.stack[f.bp+int(code.ReadUint16(ins[ip+1:]))] = vm.pop()
+		case code.OpNewCell:
+			f.ip += 2
+			vm.stack[f.bp+int(code.ReadUint16(ins[ip+1:]))] = &object.Cell{}
+		case code.OpGetCell:
+			f.ip += 2
+			vm.push(orNull(vm.cell(f, int(code.ReadUint16(ins[ip+1:]))).Value))
+		case code.OpSetCell:
+			f.ip += 2
+			vm.cell(f, int(code.ReadUint16(ins[ip+1:]))).Value = vm.pop()
+		case code.OpLoadCell:
+			f.ip += 2
+			vm.push(vm.cell(f, int(code.ReadUint16(ins[ip+1:]))))
+		case code.OpGetFree:
+			f.ip++
+			vm.push(orNull(f.cl.Free[ins[ip+1]].Value))
+		case code.OpSetFree:
+			f.ip++
+			f.cl.Free[ins[ip+1]].Value = vm.pop()
+		case code.OpLoadFree:
+			f.ip++
+			vm.push(f.cl.Free[ins[ip+1]])
+
+		case code.OpArray:
+			f.ip += 2
+			n := int(code.ReadUint16(ins[ip+1:]))
+			elements := slices.Clone(vm.stack[vm.sp-n : vm.sp])
+			if elements == nil {
+				elements = []object.Object{}
+			}
+			vm.sp -= n
+			if err := vm.alloc(1 + int64(n)); err != nil {
+				return vm.fail(err)
+			}
+			vm.push(&object.Array{Elements: elements})
+		case code.OpHash:
+			f.ip += 2
+			n := int(code.ReadUint16(ins[ip+1:]))
+			hash := object.NewHash()
+			for i := vm.sp - 2*n; i < vm.sp; i += 2 {
+				key, ok := vm.stack[i].(object.Hashable)
+				if !ok {
+					return vm.fail(newError("unusable as hash key: %s", vm.stack[i].Type()))
+				}
+				hash.Set(key, vm.stack[i+1])
+			}
+			vm.sp -= 2 * n
+			if err := vm.alloc(1 + int64(n)); err != nil {
+				return vm.fail(err)
+			}
+			vm.push(hash)
+		case code.OpIndex:
+			index := vm.pop()
+			res := evaluator.Index(vm.pop(), index)
+			if err, ok := res.(*object.Error); ok {
+				return vm.fail(err)
+			}
+			vm.push(res)
+		case code.OpSetIndex:
+			f.ip++
+			value := vm.pop()
+			index := vm.pop()
+			obj := vm.pop()
+			if op := code.Opcode(ins[ip+1]); op != 0 {
+				current := evaluator.Index(obj, index)
+				if err, ok := current.(*object.Error); ok {
+					return vm.fail(err)
+				}
+				value = vm.binary(op, current, value)
+				if err, ok := value.(*object.Error); ok {
+					return vm.fail(err)
+				}
+			}
+			res := evaluator.SetIndex(obj, index, value)
+			if err, ok := res.(*object.Error); ok {
+				return vm.fail(err)
+			}
+			vm.push(res)
+		case code.OpMember:
+			f.ip += 2
+			property := vm.constants[code.ReadUint16(ins[ip+1:])].(*object.String).Value
+			res := evaluator.Member(vm.pop(), property)
+			if err, ok := res.(*object.Error); ok {
+				return vm.fail(err)
+			}
+			vm.push(res)
+
+		case code.OpClosure:
+			f.ip += 3
+			fn := vm.constants[code.ReadUint16(ins[ip+1:])].(*object.CompiledFunction)
+			n := int(ins[ip+3])
+			free := make([]*object.Cell, n)
+			for i := range n {
+				free[i] = vm.stack[vm.sp-n+i].(*object.Cell)
+			}
+			vm.sp -= n
+			if err := vm.alloc(1); err != nil {
+				return vm.fail(err)
+			}
+			vm.push(&object.Closure{Fn: fn, Free: free})
+		case code.OpCall:
+			f.ip += 5
+			argc := int(ins[ip+1])
+			if err := vm.alloc(1); err != nil {
+				return vm.fail(err)
+			}
+
+			switch callee := vm.stack[vm.sp-1-argc].(type) {
+			case *object.Closure:
+				if err := vm.call(callee, argc); err != nil {
+					return vm.fail(err)
+				}
+				f = &vm.frames[len(vm.frames)-1]
+				ins = f.cl.Fn.Instructions
+			case *object.Builtin:
+				args := slices.Clone(vm.stack[vm.sp-argc : vm.sp])
+				res := callee.Fn(args...)
+				if res == nil {
+					res = &evaluator.NULL
+				}
+				switch res := res.(type) {
+				case *object.Error:
+					return vm.fail(res)
+				case *object.Exit:
+					return res
+				}
+				vm.sp -= argc + 1
+				vm.push(res)
+			default:
+				if global := int(code.ReadUint16(ins[ip+4:])); global > 0 && vm.globals[global-1] == nil {
+					return vm.fail(newError("invalid function call: no function with name '%s'", vm.globalNames[global-1]))
+				}
+				name := vm.constants[code.ReadUint16(ins[ip+2:])].(*object.String).Value
+				return vm.fail(newError("'%s' cannot be called, it is not a function", name))
+			}
+		case code.OpReturnValue:
+			value := vm.pop()
+
+			if len(vm.frames) == 1 {
+				if len(vm.marks) == 0 {
+					return value
+				}
+
+				// A return outside of functions leaves the current statement
+				m := vm.marks[len(vm.marks)-1]
+				vm.marks = vm.marks[:len(vm.marks)-1]
+				vm.sp = m.sp
+				vm.loops = vm.loops[:m.loops]
+				vm.push(value)
+				f.ip = m.exit - 1
+				continue
+			}
+
+			vm.leave()
+			f = &vm.frames[len(vm.frames)-1]
+			ins = f.cl.Fn.Instructions
+			vm.push(value)
+
+		case code.OpLoop:
+			vm.loops = append(vm.loops, vm.sp)
+		case code.OpLoopEnd:
+			vm.loops = vm.loops[:len(vm.loops)-1]
+		case code.OpBreak, code.OpContinue:
+			vm.sp = vm.loops[len(vm.loops)-1]
+			f.ip = int(code.ReadUint16(ins[ip+1:])) - 1
+		case code.OpIter:
+			it, err := newIterator(vm.pop())
+			if err != nil {
+				return vm.fail(err)
+			}
+			vm.push(it)
+		case code.OpIterNext:
+			f.ip += 2
+			value, ok := vm.stack[vm.sp-1].(*iterator).next()
+			if !ok {
+				f.ip = int(code.ReadUint16(ins[ip+1:])) - 1
+				break
+			}
+			if err := vm.alloc(1); err != nil {
+				return vm.fail(err)
+			}
+			vm.push(value)
+		case code.OpMark:
+			f.ip += 2
+			vm.marks = append(vm.marks, mark{sp: vm.sp, loops: len(vm.loops), exit: int(code.ReadUint16(ins[ip+1:]))})
+		case code.OpUnmark:
+			vm.marks = vm.marks[:len(vm.marks)-1]
+		case code.OpLoopSignal:
+			f.ip++
+			signal := "break"
+			if ins[ip+1] == 1 {
+				signal = "continue"
+			}
+
+			// Like in the evaluator, the error comes from the call of the
+			// function the loop signal escaped.
+			if len(vm.frames) > 1 {
+				vm.leave()
+			}
+			return vm.fail(newError("%s outside of a loop", signal))
+		case code.OpError:
+			f.ip += 2
+			msg := vm.constants[code.ReadUint16(ins[ip+1:])].(*object.String).Value
+			return vm.fail(&object.Error{Value: errors.New(msg)})
+
+		default:
+			return vm.fail(newError("unknown opcode %d", op))
+		}
+	}
+}
+
+func (vm *VM) push(obj object.Object) {
+	if vm.sp >= len(vm.stack) {
+		vm.stack = append(vm.stack, make([]object.Object, len(vm.stack))...)
+	}
+
+	vm.stack[vm.sp] = obj
+	vm.sp++
+}
+
+func (vm *VM) pop() object.Object {
+	vm.sp--
+	return vm.stack[vm.sp]
+}
+
+// call enters fn, whose arguments are on top of the stack.
+func (vm *VM) call(cl *object.Closure, argc int) *object.Error {
+	if argc != cl.Fn.NumParameters {
+		return newError("expected %d arguments in function call, instead got %d", cl.Fn.NumParameters, argc)
+	}
+	if vm.e.MaxDepth > 0 && len(vm.frames)-1 >= vm.e.MaxDepth {
+		return &object.Error{Value: fmt.Errorf("%w: %d", evaluator.ErrDepthLimit, vm.e.MaxDepth), Kind: object.DepthLimitError}
+	}
+
+	bp := vm.sp - argc
+	top := bp + cl.Fn.NumLocals
+	for len(vm.stack) <= top {
+		vm.stack = append(vm.stack, make([]object.Object, len(vm.stack))...)
+	}
+	clear(vm.stack[vm.sp:top])
+
+	vm.frames = append(vm.frames, frame{cl: cl, ip: -1, bp: bp, loops: len(vm.loops)})
+	vm.sp = top
+
+	return nil
+}
+
+// leave discards the frame of the current function along with its callee.
+func (vm *VM) leave() {
+	f := vm.frames[len(vm.frames)-1]
+	vm.frames = vm.frames[:len(vm.frames)-1]
+	vm.sp = f.bp - 1
+	vm.loops = vm.loops[:f.loops]
+}
+
+// global returns the value of a global, the builtin with the same name
+// if it was never set, or null if there is none.
+func (vm *VM) global(index int) object.Object {
+	if obj := vm.globals[index]; obj != nil {
+		return obj
+	}
+	if builtin := vm.e.Builtin(vm.globalNames[index]); builtin != nil {
+		return builtin
+	}
+
+	return &evaluator.NULL
+}
+
+// cell returns the cell in a local slot, creating it if the declaration
+// of the variable was skipped.
+func (vm *VM) cell(f *frame, index int) *object.Cell {
+	slot := &vm.stack[f.bp+index]
+	cell, ok := (*slot).(*object.Cell)
+	if !ok {
+		cell = &object.Cell{}
+		*slot = cell
+	}
+
+	return cell
+}
+
+func (vm *VM) truthy(obj object.Object) (bool, *object.Error) {
+	if obj, ok := obj.(*object.Boolean); ok {
+		return obj.Value, nil
+	}
+
+	value, err := vm.e.Truthy(obj)
+	if err != nil {
+		return false, err.(*object.Error)
+	}
+	return value, nil
+}
+
+// alloc accounts for the creation of objects of the given size.
+func (vm *VM) alloc(size int64) *object.Error {
+	return vm.e.Allocate(size)
+}
+
+// fail tags err with the location of the current instruction and the
+// stack of the calls in progress.
+func (vm *VM) fail(err *object.Error) *object.Error {
+	if err.Loc.Start.IsValid() {
+		return err
+	}
+
+	f := vm.frames[len(vm.frames)-1]
+	err.Loc = f.cl.Fn.Debug.Span(f.ip)
+	err.Stack = vm.stackTrace()
+
+	return err
+}
+
+// stackTrace returns the calls in progress with the innermost first,
+// the program itself is not part of it.
+func (vm *VM) stackTrace() []object.Frame {
+	var res []object.Frame
+	for i := len(vm.frames) - 1; i > 0; i-- {
+		caller := vm.frames[i-1]
+		name := vm.frames[i].cl.Fn.Name
+		if name == "" {
+			name = "<anonymous>"
+		}
+		res = append(res, object.Frame{Function: name, Loc: caller.cl.Fn.Debug.Span(caller.ip).Start})
+	}
+
+	return res
+}
+
+func newError(format string, a ...any) *object.Error {
+	return &object.Error{Value: fmt.Errorf(format, a...)}
+}
+
+func orNull(obj object.Object) object.Object {
+	if obj == nil {
+		return &evaluator.NULL
+	}
+	return obj
+}
+
+func nativeBoolToBoolean(value bool) *object.Boolean {
+	if value {
+		return &evaluator.TRUE
+	}
+	return &evaluator.FALSE
+}
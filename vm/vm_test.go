@@ -0,0 +1,1118 @@
+package vm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"maz-lang/ast"
+	"maz-lang/code"
+	"maz-lang/compiler"
+	"maz-lang/environment"
+	"maz-lang/evaluator"
+	"maz-lang/lexer"
+	"maz-lang/object"
+	"maz-lang/parser"
+	"maz-lang/token"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The function used by the return statement tests of the evaluator.
+const returnFoo = `
+fn foo(a, b) {
+	if a > b {
+		if a == 10 {return -1;}
+		return -2;
+	}
+
+	if a < b {return -3;}
+
+	return -4;
+}
+`
+
+func parse(src string) *ast.Program {
+	l := lexer.New(src)
+	program := parser.New(&l).Parse(token.EOF)
+
+	return &program
+}
+
+func compile(t *testing.T, program *ast.Program) *compiler.Bytecode {
+	c := compiler.New()
+	if err := c.Compile(program); err != nil {
+		t.Fatalf("unexpected compiler error: %s\n", err)
+	}
+
+	return c.Bytecode()
+}
+
+// TestVM checks that the virtual machine gives the same results as the
+// evaluator, on the programs of the evaluator tests.
+func TestVM(t *testing.T) {
+	tests := []struct {
+		Source   string
+		Overflow evaluator.OverflowPolicy
+		Strict   bool
+		Input    string
+	}{
+		{
+			Source: "5",
+		},
+		{
+			Source: "100",
+		},
+		{
+			Source: "true",
+		},
+		{
+			Source: "false",
+		},
+		{
+			Source: "!true",
+		},
+		{
+			Source: "!false",
+		},
+		{
+			Source: "-10",
+		},
+		{
+			Source: "!0",
+		},
+		{
+			Source: "!\"a\"",
+		},
+		{
+			Source: "!![]",
+		},
+		{
+			Source: "2+1*5",
+		},
+		{
+			Source: "(2*(1+5))-10",
+		},
+		{
+			Source: "((100+5)-(3*5))/5",
+		},
+		{
+			Source: "5 >= 5",
+		},
+		{
+			Source: "1 > 2",
+		},
+		{
+			Source: "10 == 10",
+		},
+		{
+			Source: "10 != 10",
+		},
+		{
+			Source: "10 < 20",
+		},
+		{
+			Source: "10 <= 10",
+		},
+		{
+			Source: "10 + 20 * 2",
+		},
+		{
+			Source: "(10 + 20) * 2",
+		},
+		{
+			Source: "10 / 2 + 5",
+		},
+		{
+			Source: "true == true",
+		},
+		{
+			Source: "true != false",
+		},
+		{
+			Source: "2 * (3 + 4) - 5",
+		},
+		{
+			Source: "2 * 3 + 4 * 5",
+		},
+		{
+			Source: "2 * (3 + 4 * 5)",
+		},
+		{
+			Source: "2 * 3 + 4 * 5 - 6 / 2",
+		},
+		{
+			Source: "2 * (3 + 4) - 6 / 2",
+		},
+		{
+			Source: "2 * 3 + 4 * (5 - 6) / 2",
+		},
+		{
+			Source: "2 * (3 + 4 * (5 - 6)) / 2",
+		},
+		{
+			Source: "2 * 3 + 4 * 5 - 6 / 2 + 10",
+		},
+		{
+			Source: "2 * (3 + 4) - 6 / 2 + 10",
+		},
+		{
+			Source: "2 * 3 + 4 * (5 - 6) / 2 + 10",
+		},
+		{
+			Source: "2 * (3 + 4 * (5 - 6)) / 2 + 10",
+		},
+		{
+			Source: "\"foo\" + \" \" + \"bar\"",
+		},
+		{
+			Source: "10 % 3",
+		},
+		{
+			Source: "-7 % 3",
+		},
+		{
+			Source: "2 + 10 % 4 * 3",
+		},
+		{
+			Source: "let a = 10; a",
+		},
+		{
+			Source: "let a = !true; a",
+		},
+		{
+			Source: "if 5 > 2 {10} else {20}",
+		},
+		{
+			Source: "let a = 5; let b = 7; if a > b {10} else {20}",
+		},
+		{
+			Source: "let a = true; let b = false; if a == b {10} else if a != b {20} else {30}",
+		},
+		{
+			Source: "if 1 > 2 {let a = 10;} else {let a = 20;} a",
+		},
+		{
+			Source: returnFoo + "foo(1,1)",
+		},
+		{
+			Source: returnFoo + "foo(10, 1)",
+		},
+		{
+			Source: returnFoo + "foo(1, 10)",
+		},
+		{
+			Source: returnFoo + "foo(5, 1)",
+		},
+		{
+			Source: "return 5;",
+		},
+		{
+			Source: "if true { return 5; }",
+		},
+		{
+			Source: "fn sum(a, b) {return a + b;} sum(1,2)",
+		},
+		{
+			Source: "fn fib(n) { if n == 0 { return 0; } else if n == 1 { return 1; } else if n == 2 { return 1; } else { return fib(n-1) + fib(n-2); } } fib(19)",
+		},
+		{
+			Source: "let a = \"foo\"; a",
+		},
+		{
+			Source: "let a = \"\"; a",
+		},
+		{
+			Source: "1 + true",
+		},
+		{
+			Source: "-\"a\"",
+		},
+		{
+			Source: "true + false",
+		},
+		{
+			Source: "\"a\" - \"b\"",
+		},
+		{
+			Source: "1 / 0",
+		},
+		{
+			Source: "let a = 0; 10 % a",
+		},
+		{
+			Source: "let a = 1 + true; 10",
+		},
+		{
+			Source: "1 + true; 10",
+		},
+		{
+			Source: "fn foo() { -true; return 10; } foo()",
+		},
+		{
+			Source: "if 1 > 0 { if true { [] * 2; } return 1; }",
+		},
+		{
+			Source: "len([1] + 1)",
+		},
+		{
+			Source: "fn id(a) { return a; } id(-true)",
+		},
+		{
+			Source: "[1, -\"a\", 3]",
+		},
+		{
+			Source: "if 1 + true == 2 { 1 }",
+		},
+		{
+			Source: "fn foo() { return 1 + true; } foo() + 1",
+		},
+		{
+			Source: "1 == true",
+		},
+		{
+			Source: "\"1\" != 1",
+		},
+		{
+			Source: "fn foo() {} foo() == foo()",
+		},
+		{
+			Source: "",
+		},
+		{
+			Source:   "9223372036854775807 + 1",
+			Overflow: evaluator.OverflowError,
+		},
+		{
+			Source:   "-9223372036854775807 - 2",
+			Overflow: evaluator.OverflowError,
+		},
+		{
+			Source:   "4611686018427387904 * 2",
+			Overflow: evaluator.OverflowError,
+		},
+		{
+			Source:   "-(-9223372036854775807 - 1)",
+			Overflow: evaluator.OverflowError,
+		},
+		{
+			Source:   "(-9223372036854775807 - 1) / -1",
+			Overflow: evaluator.OverflowError,
+		},
+		{
+			Source:   "(-9223372036854775807 - 1) % -1",
+			Overflow: evaluator.OverflowError,
+		},
+		{
+			Source:   "9223372036854775807 + 1",
+			Overflow: evaluator.OverflowWrap,
+		},
+		{
+			Source:   "4611686018427387904 * 2",
+			Overflow: evaluator.OverflowWrap,
+		},
+		{
+			Source:   "9223372036854775807 + 1",
+			Overflow: evaluator.OverflowPromote,
+		},
+		{
+			Source:   "9223372036854775807 + 1 - 1",
+			Overflow: evaluator.OverflowPromote,
+		},
+		{
+			Source:   "let a = 9223372036854775807 * 2; a > 9223372036854775807",
+			Overflow: evaluator.OverflowPromote,
+		},
+		{
+			Source:   "(9223372036854775807 + 1) % 0",
+			Overflow: evaluator.OverflowPromote,
+		},
+		{
+			Source:   "1 << 63",
+			Overflow: evaluator.OverflowError,
+		},
+		{
+			Source:   "3 ** 40",
+			Overflow: evaluator.OverflowError,
+		},
+		{
+			Source:   "1 << 63",
+			Overflow: evaluator.OverflowWrap,
+		},
+		{
+			Source:   "2 ** 64",
+			Overflow: evaluator.OverflowPromote,
+		},
+		{
+			Source:   "(1 << 64) >> 60",
+			Overflow: evaluator.OverflowPromote,
+		},
+		{
+			Source:   "~(1 << 64) & 255",
+			Overflow: evaluator.OverflowPromote,
+		},
+		{
+			Source: "let double = fn (x) { return x * 2; }; double(21)",
+		},
+		{
+			Source: "fn make_adder(x) { return fn (y) { return x + y; }; } let add_two = make_adder(2); add_two(3)",
+		},
+		{
+			Source: "let x = 1; fn get_x() { return x; } fn call(x) { return get_x(); } call(100)",
+		},
+		{
+			Source: "fn twice(f, x) { return f(f(x)); } let inc = fn (x) { return x + 1; }; twice(inc, 5)",
+		},
+		{
+			Source: "fn ignore() { return 1; } fn foo() { ignore(); return 2; } foo()",
+		},
+		{
+			Source: "fn make_adder(x) { return fn (y) { return x + y; }; } make_adder(1)(2)",
+		},
+		{
+			Source: "let f = fn (x) { return x * 2; }; let g = fn (x) { return x * 3; }; (if 1 > 2 { f } else { g })(5)",
+		},
+		{
+			Source: "fn (x) { return x; }(7)",
+		},
+		{
+			Source: "[1, 2 * 2, \"foo\"]",
+		},
+		{
+			Source: "[]",
+		},
+		{
+			Source: "let a = [1, 2, 3]; a[1 + 1]",
+		},
+		{
+			Source: "let ops = [fn (a, b) { return a + b; }]; ops[0](1, 2)",
+		},
+		{
+			Source: "[[1, 2], [3, 4]][1][0]",
+		},
+		{
+			Source: "len([1, 2, 3]) + len(\"foo\")",
+		},
+		{
+			Source: "let a = [1]; push(a, 2, 3); a",
+		},
+		{
+			Source: "first([1, 2, 3]) + last([1, 2, 3])",
+		},
+		{
+			Source: "first([])",
+		},
+		{
+			Source: "rest([1, 2, 3])",
+		},
+		{
+			Source: "slice([1, 2, 3, 4], 1, 3)",
+		},
+		{
+			Source: "slice([1, 2, 3, 4], 3)",
+		},
+		{
+			Source: "[1, 2, 3][3]",
+		},
+		{
+			Source: "[1, 2, 3][-1]",
+		},
+		{
+			Source: "[1][true]",
+		},
+		{
+			Source: "len(1)",
+		},
+		{
+			Source: "push([])",
+		},
+		{
+			Source: "slice([1, 2], 1, 5)",
+		},
+		{
+			Source: "let h = {\"name\": \"x\", 1: true, false: 2}; h[\"name\"]",
+		},
+		{
+			Source: "let h = {\"name\": \"x\", 1: true, false: 2}; h[1]",
+		},
+		{
+			Source: "let h = {\"name\": \"x\", 1: true, false: 2}; h[1 > 2]",
+		},
+		{
+			Source: "{}[\"missing\"]",
+		},
+		{
+			Source: "let h = {}; h[\"a\"] = 1; h[\"a\"] = h[\"a\"] + 1; h[\"a\"]",
+		},
+		{
+			Source: "let h = {\"greet\": fn (name) { return \"hi \" + name; }}; h.greet(\"bob\")",
+		},
+		{
+			Source: "let h = {}; h.count = 3; h[\"count\"]",
+		},
+		{
+			Source: "let a = [1, 2]; a[0] = 5; a[0] + a[1]",
+		},
+		{
+			Source: "keys({\"b\": 1, \"a\": 2})",
+		},
+		{
+			Source: "values({\"b\": 1, \"a\": 2})",
+		},
+		{
+			Source: "has({\"a\": 1}, \"a\")",
+		},
+		{
+			Source: "has({\"a\": 1}, \"b\")",
+		},
+		{
+			Source: "let h = {\"a\": 1, \"b\": 2}; delete(h, \"a\"); len(h)",
+		},
+		{
+			Source: "let c = [0]; while c[0] < 5 { c[0] = c[0] + 1; } c[0]",
+		},
+		{
+			Source: "while false { 1 }",
+		},
+		{
+			Source: "let sum = [0]; for x in [1, 2, 3] { sum[0] = sum[0] + x; } sum[0]",
+		},
+		{
+			Source: "let res = []; for c in \"héllo\" { push(res, c); } res",
+		},
+		{
+			Source: "let res = []; for i in range(3) { push(res, i); } res",
+		},
+		{
+			Source: "let res = []; for i in range(10, 0, -4) { push(res, i); } res",
+		},
+		{
+			Source: "let res = []; for i in range(9223372036854775806, 9223372036854775807, 2) { push(res, i); } len(res)",
+		},
+		{
+			Source: "let res = []; for k in {\"b\": 1, \"a\": 2} { push(res, k); } res",
+		},
+		{
+			Source: "let h = {1: 1, 2: 2, 3: 3}; let res = []; for k in h { delete(h, 2); push(res, k); } res",
+		},
+		{
+			Source: "let res = []; for i in range(10) { if i == 3 { break; } push(res, i); } res",
+		},
+		{
+			Source: "let res = []; for i in range(4) { if i % 2 == 0 { continue; } push(res, i); } res",
+		},
+		{
+			Source: "let res = []; for i in range(3) { for j in range(3) { if j == 1 { break; } push(res, j); } } len(res)",
+		},
+		{
+			Source: "fn find(arr, x) { for i in range(len(arr)) { if arr[i] == x { return i; } } return -1; } find([5, 6, 7], 7)",
+		},
+		{
+			Source: "let fns = []; for i in range(3) { push(fns, fn() { return i; }); } fns[1]()",
+		},
+		{
+			Source: "for x in 10 { x }",
+		},
+		{
+			Source: "for i in range(3) { -true; }",
+		},
+		{
+			Source: "range(0, 10, 0)",
+		},
+		{
+			Source: "if true { break; }",
+		},
+		{
+			Source: "fn foo() { continue; } for i in range(3) { foo(); }",
+		},
+		{
+			Source: "let x = 1; x = 2; x",
+		},
+		{
+			Source: "let x = 1; x = 5",
+		},
+		{
+			Source: "let x = 1; if true { x = 2; } x",
+		},
+		{
+			Source: "let x = 1; if true { let x = 10; x = 2; } x",
+		},
+		{
+			Source: "let i = 0; let sum = 0; while i < 5 { i += 1; sum += i; } sum",
+		},
+		{
+			Source: "let x = 10; x -= 3; x *= 2; x /= 7; x",
+		},
+		{
+			Source: "let x = 10; x %= 4; x",
+		},
+		{
+			Source: "let s = \"foo\"; s += \"bar\"; s",
+		},
+		{
+			Source: "let a = [1, 2]; a[1] += 10; a",
+		},
+		{
+			Source: "let h = {\"n\": 1}; h.n *= 3; h[\"n\"]",
+		},
+		{
+			Source: "let x = 1; let y = 2; x = y = 3; x + y",
+		},
+		{
+			Source: "fn counter() { let n = 0; return fn() { n += 1; return n; }; } let c = counter(); c(); c(); c()",
+		},
+		{
+			Source: "x = 1",
+		},
+		{
+			Source: "if true { let x = 1; } x += 1",
+		},
+		{
+			Source: "let x = 1; x += true",
+		},
+		{
+			Source: "let x = 1; x /= 0",
+		},
+		{
+			Source: "let a = [1]; a[1] += 1",
+		},
+		{
+			Source: "true && false",
+		},
+		{
+			Source: "false || true",
+		},
+		{
+			Source: "1 < 2 && 2 < 3",
+		},
+		{
+			Source: "true || false && false",
+		},
+		{
+			Source: "(true || false) && false",
+		},
+		{
+			Source: "1 && \"a\"",
+		},
+		{
+			Source: "0 || \"\"",
+		},
+		{
+			Source: "false && 1 + true",
+		},
+		{
+			Source: "true || undefined()",
+		},
+		{
+			Source: "let calls = 0; fn f() { calls += 1; return true; } f() || f(); calls",
+		},
+		{
+			Source: "let x = false || true; x",
+		},
+		{
+			Source: "if 0 { 1 } else { 2 }",
+		},
+		{
+			Source: "if -1 { 1 } else { 2 }",
+		},
+		{
+			Source: "if \"\" { 1 } else if [0] { 2 }",
+		},
+		{
+			Source: "if {} { 1 } else if range(0) { 2 } else { 3 }",
+		},
+		{
+			Source: "fn nothing() {} if nothing() { 1 } else { 2 }",
+		},
+		{
+			Source: "if fn() {} { 1 }",
+		},
+		{
+			Source: "let n = 3; let res = 0; while n { res += n; n -= 1; } res",
+		},
+		{
+			Source: "!1",
+			Strict: true,
+		},
+		{
+			Source: "if 1 { 1 }",
+			Strict: true,
+		},
+		{
+			Source: "if false { 1 } else if \"a\" { 2 }",
+			Strict: true,
+		},
+		{
+			Source: "while 1 { 1 }",
+			Strict: true,
+		},
+		{
+			Source: "true && 1",
+			Strict: true,
+		},
+		{
+			Source: "[] || true",
+			Strict: true,
+		},
+		{
+			Source: "1.5 + 2.25",
+		},
+		{
+			Source: "1 + 0.5",
+		},
+		{
+			Source: "7 / 2.0",
+		},
+		{
+			Source: "7 / 2",
+		},
+		{
+			Source: "-2.5 * 2",
+		},
+		{
+			Source: "5.5 % 2",
+		},
+		{
+			Source: "1 == 1.0",
+		},
+		{
+			Source: "2 > 1.5",
+		},
+		{
+			Source: "0.1 + 0.2 != 0.3",
+		},
+		{
+			Source: "if 0.0 { 1 } else { 2 }",
+		},
+		{
+			Source: "let x = 1; x += 0.5; x",
+		},
+		{
+			Source: "int(3.99)",
+		},
+		{
+			Source: "int(-3.99)",
+		},
+		{
+			Source: "int(\" 42 \")",
+		},
+		{
+			Source: "float(2)",
+		},
+		{
+			Source: "float(\"1e-3\")",
+		},
+		{
+			Source: "{1.5: \"a\"}[1.5]",
+		},
+		{
+			Source: "1.5 / 0",
+		},
+		{
+			Source: "1.5 + \"a\"",
+		},
+		{
+			Source: "int(\"abc\")",
+		},
+		{
+			Source: "int(1e30)",
+		},
+		{
+			Source: "float([])",
+		},
+		{
+			Source: "12 & 10",
+		},
+		{
+			Source: "12 | 10",
+		},
+		{
+			Source: "12 ^ 10",
+		},
+		{
+			Source: "~0",
+		},
+		{
+			Source: "1 << 10",
+		},
+		{
+			Source: "-16 >> 2",
+		},
+		{
+			Source: "1 >> 64",
+		},
+		{
+			Source: "0 << 100",
+		},
+		{
+			Source: "let flags = 0; flags = flags | 1 << 3; flags & 8 != 0",
+		},
+		{
+			Source: "2 ** 10",
+		},
+		{
+			Source: "2 ** 3 ** 2",
+		},
+		{
+			Source: "-2 ** 2",
+		},
+		{
+			Source: "(-2) ** 63",
+		},
+		{
+			Source: "5 ** 0",
+		},
+		{
+			Source: "2 ** -1",
+		},
+		{
+			Source: "2.0 ** 0.5 == float(2) ** 0.5",
+		},
+		{
+			Source: "1 << -1",
+		},
+		{
+			Source: "8 >> -2",
+		},
+		{
+			Source: "1.5 & 1",
+		},
+		{
+			Source: "~true",
+		},
+		{
+			Source: "\"a\" ** 2",
+		},
+		{
+			Source: "type(1)",
+		},
+		{
+			Source: "type(\"a\") == type(str(1.5))",
+		},
+		{
+			Source: "type(len)",
+		},
+		{
+			Source: "str([1, \"a\"])",
+		},
+		{
+			Source: "str(\"a\")",
+		},
+		{
+			Source: "int(str(42)) + 1",
+		},
+		{
+			Source: "let type = 1; type",
+		},
+		{
+			Source: "exit(2)",
+		},
+		{
+			Source: "fn f() { for x in range(10) { if x == 3 { exit(x); } } return 0; } f(); 1",
+		},
+		{
+			Source: "let a = [exit()]; 1",
+		},
+		{
+			Source: "print(1, \"a\", [\"b\"]); print(true)",
+		},
+		{
+			Source: "println(\"a\"); println(); println(1, 2)",
+		},
+		{
+			Source: "let name = input(\"name: \"); println(\"hi \" + name)",
+			Input:  "bob\r\n",
+		},
+		{
+			Source: "[input(), input(), input()]",
+			Input:  "a\nb",
+		},
+		{
+			Source: "println(\"before\"); exit(1); println(\"after\")",
+		},
+		{
+			Source: "type()",
+		},
+		{
+			Source: "str(1, 2)",
+		},
+		{
+			Source: "exit(\"a\")",
+		},
+		{
+			Source: "input(1, 2)",
+		},
+		{
+			Source: "let res = []; for i in range(3) { let j = i * 2; push(res, fn() { return j; }); } res[0]() + res[2]()",
+		},
+		{
+			Source: "fn outer() { let x = 1; let get = fn() { return x; }; x = 2; return get(); } outer()",
+		},
+		{
+			Source: "fn outer(n) { fn inner() { return fn() { n += 1; return n; }; } let f = inner(); f(); return f(); } outer(10)",
+		},
+		{
+			Source: "fn fact(n) { if n <= 1 { return 1; } return n * fact(n - 1); } fact(20)",
+		},
+		{
+			Source: "fn f() { let g = fn(n) { if n == 0 { return 0; } return g(n - 1); }; return g(3); } f()",
+		},
+		{
+			Source: "let total = 0; for i in range(10) { if i == 4 { return total; } total += i; }",
+		},
+		{
+			Source: "for i in range(3) { return i; } 10",
+		},
+		{
+			Source: "let i = 0; while true { i += 1; if i > 3 { break; } } i",
+		},
+		{
+			Source: "fn f() { fn g() {} fn g() {} } f()",
+		},
+		{
+			Source: "let a = 1; fn a() {}",
+		},
+		{
+			Source: "fn f() { return x; } let x = 5; f()",
+		},
+		{
+			Source: "let h = {\"a\": [1, 2]}; h.a[1] += 5; h[\"a\"]",
+		},
+		{
+			Source: "1 = 2",
+		},
+		{
+			Source: "continue",
+		},
+		{
+			Source: "let f = fn() { break; }; f()",
+		},
+		{
+			Source: "fn f(x) { return g(x); } f(1)",
+		},
+		{
+			Source: "let a = 1; a(2)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("evaluating: '%s'\n", tt.Source)
+		program := parse(tt.Source)
+
+		var evalOut, vmOut bytes.Buffer
+		newEvaluator := func(out *bytes.Buffer) *evaluator.Evaluator {
+			e := evaluator.New()
+			e.Overflow = tt.Overflow
+			e.Strict = tt.Strict
+			e.Stdout = out
+			e.Stdin = strings.NewReader(tt.Input)
+			return e
+		}
+
+		env := environment.New()
+		expected := newEvaluator(&evalOut).Eval(program, &env)
+		obj := New(compile(t, program), newEvaluator(&vmOut)).Run()
+
+		if obj.Type() != expected.Type() || obj.Inspect() != expected.Inspect() {
+			t.Errorf("expected object to be %s %s, instead got %s %s\n", expected.Type(), expected.Inspect(), obj.Type(), obj.Inspect())
+			continue
+		}
+		if err, ok := expected.(*object.Error); ok && obj.(*object.Error).Loc != err.Loc {
+			t.Errorf("expected error at %s, instead got %s\n", err.Loc.Start, obj.(*object.Error).Loc.Start)
+		}
+		if vmOut.String() != evalOut.String() {
+			t.Errorf("expected output %q, instead got %q\n", evalOut.String(), vmOut.String())
+		}
+	}
+}
+
+func TestVMStackTrace(t *testing.T) {
+	src := `fn fib(n) {
+	if n == 0 {
+		return 1 / 0;
+	}
+	return fib(n - 1) + 1;
+}
+let f = fn(x) { return fib(x); };
+f(2)`
+	l := lexer.NewWithFilename("fib.mz", src)
+	program := parser.New(&l).Parse(token.EOF)
+	obj := New(compile(t, &program), evaluator.New()).Run()
+
+	err, ok := obj.(*object.Error)
+	if !ok {
+		t.Fatalf("expected object.Error, instead got %+v\n", obj)
+	}
+
+	expected := strings.Join([]string{
+		"\tat fib (fib.mz:5:9)",
+		"\tat fib (fib.mz:5:9)",
+		"\tat fib (fib.mz:7:24)",
+		"\tat <anonymous> (fib.mz:8:1)",
+	}, "\n")
+	if err.StackTrace() != expected {
+		t.Errorf("expected stack trace\n%s\ninstead got\n%s\n", expected, err.StackTrace())
+	}
+	if err.Loc.Start.String() != "fib.mz:3:10" {
+		t.Errorf("expected error at fib.mz:3:10, instead got %s\n", err.Loc.Start)
+	}
+}
+
+func TestVMLimits(t *testing.T) {
+	tests := []struct {
+		Source         string
+		MaxSteps       int64
+		MaxDepth       int
+		MaxAllocations int64
+		Overflow       evaluator.OverflowPolicy
+		ExpectedKind   object.ErrorKind
+		ExpectedErr    error
+	}{
+		{
+			Source:       "while true {}",
+			MaxSteps:     1000,
+			ExpectedKind: object.StepLimitError,
+			ExpectedErr:  evaluator.ErrStepLimit,
+		},
+		{
+			Source:       "fn f(n) { return f(n + 1); } f(0)",
+			MaxDepth:     100,
+			ExpectedKind: object.DepthLimitError,
+			ExpectedErr:  evaluator.ErrDepthLimit,
+		},
+		{
+			Source:       "fn f(n) { return f(n + 1); } f(0)",
+			ExpectedKind: object.DepthLimitError,
+			ExpectedErr:  evaluator.ErrDepthLimit,
+		},
+		{
+			Source:         "let a = []; while true { push(a, [1, 2]); }",
+			MaxAllocations: 1000,
+			ExpectedKind:   object.AllocationLimitError,
+			ExpectedErr:    evaluator.ErrAllocationLimit,
+		},
+		{
+			Source:         "let s = \"ab\"; while true { s = s + s; }",
+			MaxAllocations: 1000000,
+			ExpectedKind:   object.AllocationLimitError,
+			ExpectedErr:    evaluator.ErrAllocationLimit,
+		},
+		{
+			Source:         "let a = [0, 0, 0, 0, 0, 0, 0, 0, 0, 0]; for x in a { push(a, x, x, x, x, x, x, x, x, x, x); }",
+			MaxAllocations: 100,
+			ExpectedKind:   object.AllocationLimitError,
+			ExpectedErr:    evaluator.ErrAllocationLimit,
+		},
+		{
+			Source:         "2 ** 100000000",
+			Overflow:       evaluator.OverflowPromote,
+			MaxAllocations: 1000,
+			ExpectedKind:   object.AllocationLimitError,
+			ExpectedErr:    evaluator.ErrAllocationLimit,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("evaluating: '%s'\n", tt.Source)
+		e := evaluator.New()
+		e.MaxSteps = tt.MaxSteps
+		if tt.MaxDepth > 0 {
+			e.MaxDepth = tt.MaxDepth
+		}
+		e.MaxAllocations = tt.MaxAllocations
+		e.Overflow = tt.Overflow
+		obj := New(compile(t, parse(tt.Source)), e).Run()
+
+		err, ok := obj.(*object.Error)
+		if !ok {
+			t.Errorf("expected object.Error, instead got %+v\n", obj)
+			continue
+		}
+
+		if err.Kind != tt.ExpectedKind || !errors.Is(err.Value, tt.ExpectedErr) {
+			t.Errorf("expected a %s error, instead got a %s error '%s'\n", tt.ExpectedKind, err.Kind, err.Inspect())
+		}
+	}
+}
+
+func TestVMContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	obj := New(compile(t, parse("while true { 1 + 1; }")), evaluator.New()).RunContext(ctx)
+
+	err, ok := obj.(*object.Error)
+	if !ok {
+		t.Fatalf("expected object.Error, instead got %+v\n", obj)
+	}
+
+	if err.Kind != object.CanceledError || !errors.Is(err.Value, context.DeadlineExceeded) {
+		t.Errorf("expected a %s error, instead got a %s error '%s'\n", object.CanceledError, err.Kind, err.Inspect())
+	}
+}
+
+// TestVMCorrupted runs decoded programs whose instructions are valid one
+// by one but leave the stack in a state the compiler never produces.
+func TestVMCorrupted(t *testing.T) {
+	ret := code.Make(code.OpReturnValue)
+	tests := []struct {
+		Name         string
+		Instructions [][]byte
+	}{
+		{
+			Name:         "pop of an empty stack",
+			Instructions: [][]byte{code.Make(code.OpPop), ret},
+		},
+		{
+			Name:         "break outside of a loop",
+			Instructions: [][]byte{code.Make(code.OpBreak, 3), code.Make(code.OpNull), ret},
+		},
+		{
+			Name:         "continue outside of a loop",
+			Instructions: [][]byte{code.Make(code.OpContinue, 3), code.Make(code.OpNull), ret},
+		},
+		{
+			Name:         "next of a value which is not an iterator",
+			Instructions: [][]byte{code.Make(code.OpNull), code.Make(code.OpIterNext, 4), ret},
+		},
+		{
+			Name:         "jump on a value which is not a boolean",
+			Instructions: [][]byte{code.Make(code.OpNull), code.Make(code.OpJumpIfFalseOrPop, 4), ret},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Logf("running: '%s'\n", tt.Name)
+		bytecode := compile(t, parse("1"))
+		bytecode.Main.Instructions = slices.Concat(tt.Instructions...)
+
+		var buf bytes.Buffer
+		if err := bytecode.Encode(&buf); err != nil {
+			t.Fatalf("unexpected error: %s\n", err)
+		}
+		decoded, err := compiler.Decode(&buf)
+		if err != nil {
+			t.Errorf("unexpected decoding error: %s\n", err)
+			continue
+		}
+
+		obj := New(decoded, evaluator.New()).Run()
+		if err, ok := obj.(*object.Error); !ok || !errors.Is(err.Value, compiler.ErrCorrupted) {
+			t.Errorf("expected a corrupted program error, instead got %s\n", obj.Inspect())
+		}
+	}
+}
+
+func BenchmarkFibonacci(b *testing.B) {
+	l := lexer.New("fn fib(n) { if n < 2 { return n; } return fib(n - 1) + fib(n - 2); } fib(25)")
+	program := parser.New(&l).Parse(token.EOF)
+	c := compiler.New()
+	if err := c.Compile(&program); err != nil {
+		b.Fatalf("unexpected compiler error: %s\n", err)
+	}
+	bytecode := c.Bytecode()
+
+	for range b.N {
+		New(bytecode, evaluator.New()).Run()
+	}
+}